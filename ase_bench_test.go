@@ -0,0 +1,99 @@
+package asefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func readChica(tb testing.TB) []byte {
+	tb.Helper()
+	chica, err := os.ReadFile("example/Chica.aseprite")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return chica
+}
+
+// chicaChunks splits the example sprite into the data of its chunks by type
+func chicaChunks(tb testing.TB) map[uint16][][]byte {
+	chica := readChica(tb)
+	chunks := make(map[uint16][][]byte)
+	offset := 128
+	for frame := 0; frame < int(binary.LittleEndian.Uint16(chica[6:])); frame += 1 {
+		frameSize := int(binary.LittleEndian.Uint32(chica[offset:]))
+		numChunks := int(binary.LittleEndian.Uint16(chica[offset+6:]))
+		chunkOffset := offset + 16
+		for x := 0; x < numChunks; x += 1 {
+			chunkSize := int(binary.LittleEndian.Uint32(chica[chunkOffset:]))
+			chunkType := binary.LittleEndian.Uint16(chica[chunkOffset+4:])
+			chunks[chunkType] = append(chunks[chunkType], chica[chunkOffset+6:chunkOffset+chunkSize])
+			chunkOffset += chunkSize
+		}
+		offset += frameSize
+	}
+	return chunks
+}
+
+func BenchmarkDecodeHeader(b *testing.B) {
+	chica := readChica(b)[:128]
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chica)))
+	for x := 0; x < b.N; x += 1 {
+		var aseHeader AsepriteHeader
+		if err := aseHeader.Decode(bytes.NewReader(chica)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePalette(b *testing.B) {
+	chunkDat := chicaChunks(b)[0x2019][0]
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunkDat)))
+	for x := 0; x < b.N; x += 1 {
+		var palette AsepritePaletteChunk2019
+		if err := palette.decodeFields(&fieldReader{chunkDat}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeCel(b *testing.B) {
+	chunkDat := chicaChunks(b)[0x2005][0]
+	aseHeader := AsepriteHeader{ColorDepth: 32}
+	dec := &decoder{}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunkDat)))
+	for x := 0; x < b.N; x += 1 {
+		cel := AsepriteCelChunk2005{parentHeader: &aseHeader}
+		if err := cel.decodeWith(dec, &fieldReader{chunkDat}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeFile(b *testing.B) {
+	benchmarkDecodeFile(b, DecodeOptions{})
+}
+
+func BenchmarkDecodeFileLazy(b *testing.B) {
+	benchmarkDecodeFile(b, DecodeOptions{LazyCels: true})
+}
+
+func BenchmarkDecodeFileConcurrent(b *testing.B) {
+	benchmarkDecodeFile(b, DecodeOptions{Concurrency: 4})
+}
+
+func benchmarkDecodeFile(b *testing.B, opts DecodeOptions) {
+	chica := readChica(b)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chica)))
+	for x := 0; x < b.N; x += 1 {
+		var aseFile AsepriteFile
+		if err := aseFile.DecodeWithOptions(bytes.NewReader(chica), opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
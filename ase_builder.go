@@ -0,0 +1,347 @@
+package asefile
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+/**
+ * SpriteBuilder creates new sprites from scratch.
+ *
+ * Layers, frames, tags and slices are added in order and refer to each other by
+ * the index returned when they were added. The first error is remembered and
+ * returned by Build, so calls can be chained without checking each one.
+ */
+type SpriteBuilder struct {
+	width, height int
+	colorMode     ColorMode
+	palette       color.Palette
+	layers        []AsepriteLayerChunk2004
+	frames        []AsepriteFrame
+	tags          []AsepriteTagsChunk2018Tag
+	slices        []AsepriteSliceChunk2022
+	err           error
+}
+
+// NewSprite starts a sprite of w x h pixels in the given color mode
+func NewSprite(w, h int, colorMode ColorMode) *SpriteBuilder {
+	builder := &SpriteBuilder{width: w, height: h, colorMode: colorMode}
+	if w <= 0 || h <= 0 || w > 0xFFFF || h > 0xFFFF {
+		builder.fail(fmt.Errorf("sprite size %dx%d out of range", w, h))
+	}
+	switch colorMode {
+	case ColorModeIndexed, ColorModeGrayscale, ColorModeRGBA:
+	default:
+		builder.fail(fmt.Errorf("unknown color mode %d", colorMode))
+	}
+	return builder
+}
+
+func (builder *SpriteBuilder) fail(err error) {
+	if builder.err == nil {
+		builder.err = err
+	}
+}
+
+// SetPalette sets the palette of the sprite. Indexed sprites must have one.
+func (builder *SpriteBuilder) SetPalette(palette color.Palette) *SpriteBuilder {
+	if len(palette) == 0 || len(palette) > 0xFFFF {
+		builder.fail(fmt.Errorf("palette size %d out of range", len(palette)))
+		return builder
+	}
+	builder.palette = append(color.Palette(nil), palette...)
+	return builder
+}
+
+// AddLayer adds an image layer and returns its layer index. The layer is placed
+// inside the group with index parent, or at the top level when parent is -1.
+func (builder *SpriteBuilder) AddLayer(name string, parent int) int {
+	return builder.addLayer(name, LayerTypeImage, parent)
+}
+
+// AddGroup adds a layer group and returns its layer index. Layers are added to
+// it by passing that index as the parent of AddLayer or AddGroup.
+func (builder *SpriteBuilder) AddGroup(name string, parent int) int {
+	return builder.addLayer(name, LayerTypeGroup, parent)
+}
+
+func (builder *SpriteBuilder) addLayer(name string, layerType LayerType, parent int) int {
+	childLevel := uint16(0)
+	if parent != -1 {
+		if parent < 0 || parent >= len(builder.layers) || builder.layers[parent].LayerType != LayerTypeGroup {
+			builder.fail(fmt.Errorf("layer %q: parent %d is not a group", name, parent))
+			return -1
+		}
+		// Layers are stored depth first, so only the group whose subtree ends
+		// the layer list can still receive children
+		parentLevel := builder.layers[parent].LayerChildLevel
+		for _, layer := range builder.layers[parent+1:] {
+			if layer.LayerChildLevel <= parentLevel {
+				builder.fail(fmt.Errorf("layer %q: group %d is already closed by a later layer", name, parent))
+				return -1
+			}
+		}
+		childLevel = parentLevel + 1
+	}
+	builder.layers = append(builder.layers, AsepriteLayerChunk2004{
+		Flags:           LayerFlagVisible | LayerFlagEditable,
+		LayerType:       layerType,
+		LayerChildLevel: childLevel,
+		Opacity:         255,
+		LayerName:       name,
+	})
+	return len(builder.layers) - 1
+}
+
+// AddFrame appends a frame shown for duration milliseconds and returns its index
+func (builder *SpriteBuilder) AddFrame(duration int) int {
+	if duration < 0 || duration > 0xFFFF {
+		builder.fail(fmt.Errorf("frame duration %d out of range", duration))
+		return -1
+	}
+	builder.frames = append(builder.frames, AsepriteFrame{
+		FrameDurationMilliseconds: uint16(duration),
+	})
+	return len(builder.frames) - 1
+}
+
+// SetCel places img on the given frame and image layer with its top left
+// corner at pos, replacing any cel already there. Indexed sprites only accept
+// *image.Paletted images, whose indices are stored as they are.
+func (builder *SpriteBuilder) SetCel(frame, layer int, img image.Image, pos image.Point) *SpriteBuilder {
+	if frame < 0 || frame >= len(builder.frames) {
+		builder.fail(fmt.Errorf("cel frame %d out of range", frame))
+		return builder
+	}
+	if layer < 0 || layer >= len(builder.layers) || builder.layers[layer].LayerType != LayerTypeImage {
+		builder.fail(fmt.Errorf("cel layer %d is not an image layer", layer))
+		return builder
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() > 0xFFFF || bounds.Dy() > 0xFFFF {
+		builder.fail(fmt.Errorf("cel image size %dx%d out of range", bounds.Dx(), bounds.Dy()))
+		return builder
+	}
+	if pos.X < -0x8000 || pos.X > 0x7FFF || pos.Y < -0x8000 || pos.Y > 0x7FFF {
+		builder.fail(fmt.Errorf("cel position %v out of range", pos))
+		return builder
+	}
+	pixels, err := encodeCelPixels(img, builder.colorMode)
+	if err != nil {
+		builder.fail(err)
+		return builder
+	}
+	cel := AsepriteCelChunk2005{
+		LayerIndex:   uint16(layer),
+		X:            int16(pos.X),
+		Y:            int16(pos.Y),
+		OpacityLevel: 255,
+		CelType:      CelTypeCompressedImage,
+		WidthInPix:   uint16(bounds.Dx()),
+		HeightInPix:  uint16(bounds.Dy()),
+		RawCelData:   pixels,
+	}
+	cels := builder.frames[frame].Cels
+	for x := range cels {
+		if int(cels[x].LayerIndex) == layer {
+			cels[x] = cel
+			return builder
+		}
+	}
+	// Keep the cels of a frame in layer order, as Aseprite writes them
+	at := len(cels)
+	for at > 0 && int(cels[at-1].LayerIndex) > layer {
+		at -= 1
+	}
+	cels = append(cels, AsepriteCelChunk2005{})
+	copy(cels[at+1:], cels[at:])
+	cels[at] = cel
+	builder.frames[frame].Cels = cels
+	return builder
+}
+
+// encodeCelPixels converts img to the raw cel pixel layout of colorMode
+func encodeCelPixels(img image.Image, colorMode ColorMode) ([]byte, error) {
+	bounds := img.Bounds()
+	switch colorMode {
+	case ColorModeIndexed:
+		paletted, ok := img.(*image.Paletted)
+		if !ok {
+			return nil, fmt.Errorf("indexed sprites need *image.Paletted cels, got %T", img)
+		}
+		pixels := make([]byte, 0, bounds.Dx()*bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+			offset := paletted.PixOffset(bounds.Min.X, y)
+			pixels = append(pixels, paletted.Pix[offset:offset+bounds.Dx()]...)
+		}
+		return pixels, nil
+	case ColorModeGrayscale:
+		pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*2)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+			for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+				col := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				gray := color.GrayModel.Convert(color.NRGBA{col.R, col.G, col.B, 255}).(color.Gray)
+				pixels = append(pixels, gray.Y, col.A)
+			}
+		}
+		return pixels, nil
+	default:
+		pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*4)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+			for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+				col := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				pixels = append(pixels, col.R, col.G, col.B, col.A)
+			}
+		}
+		return pixels, nil
+	}
+}
+
+// AddTag adds an animation tag over the frames from..to (inclusive) played in
+// the given direction and returns its index
+func (builder *SpriteBuilder) AddTag(name string, from, to int, direction LoopDirection) int {
+	if from < 0 || from > to || to > 0xFFFF {
+		builder.fail(fmt.Errorf("tag %q: frame range %d..%d is invalid", name, from, to))
+		return -1
+	}
+	if direction > LoopPingPongReverse {
+		builder.fail(fmt.Errorf("tag %q: unknown loop direction %d", name, direction))
+		return -1
+	}
+	builder.tags = append(builder.tags, AsepriteTagsChunk2018Tag{
+		FromFrame:         uint16(from),
+		ToFrame:           uint16(to),
+		LoopAnimDirection: direction,
+		TagName:           name,
+	})
+	return len(builder.tags) - 1
+}
+
+// AddSlice adds a slice with one key per frame where its bounds change and
+// returns its index. It is saved as a 9-patch slice when any key has a center
+// and with pivot information when any key has a pivot.
+func (builder *SpriteBuilder) AddSlice(name string, keys ...SliceKey) int {
+	if len(keys) == 0 {
+		builder.fail(fmt.Errorf("slice %q has no keys", name))
+		return -1
+	}
+	sliceChunk := AsepriteSliceChunk2022{
+		NumSliceKeys:  uint32(len(keys)),
+		Name:          name,
+		SliceKeysData: append([]SliceKey(nil), keys...),
+	}
+	for x, key := range keys {
+		if x > 0 && key.FrameNumber <= keys[x-1].FrameNumber {
+			builder.fail(fmt.Errorf("slice %q: keys are not in frame order", name))
+			return -1
+		}
+		if key.CenterWidth != 0 || key.CenterHeight != 0 {
+			sliceChunk.Flags |= SliceFlagNinePatch
+		}
+		if key.PivotX != 0 || key.PivotY != 0 {
+			sliceChunk.Flags |= SliceFlagHasPivot
+		}
+	}
+	builder.slices = append(builder.slices, sliceChunk)
+	return len(builder.slices) - 1
+}
+
+// Build assembles the sprite, filling in the magic numbers and the frame and
+// chunk counts. The file and frame sizes depend on how cels are compressed
+// and are filled in when the sprite is encoded.
+func (builder *SpriteBuilder) Build() (*AsepriteFile, error) {
+	if builder.err != nil {
+		return nil, builder.err
+	}
+	if len(builder.frames) == 0 {
+		return nil, fmt.Errorf("sprite has no frames")
+	}
+	if len(builder.frames) > 0xFFFF {
+		return nil, fmt.Errorf("sprite has %d frames, at most 65535 are allowed", len(builder.frames))
+	}
+	for _, tag := range builder.tags {
+		if int(tag.ToFrame) >= len(builder.frames) {
+			return nil, fmt.Errorf("tag %q ends after the last frame", tag.TagName)
+		}
+	}
+	for _, sliceChunk := range builder.slices {
+		if int(sliceChunk.SliceKeysData[0].FrameNumber) >= len(builder.frames) {
+			return nil, fmt.Errorf("slice %q starts after the last frame", sliceChunk.Name)
+		}
+	}
+	palette := builder.palette
+	if palette == nil {
+		if builder.colorMode == ColorModeIndexed {
+			return nil, fmt.Errorf("indexed sprites need a palette")
+		}
+		palette = color.Palette{color.Black}
+	}
+
+	aseFile := &AsepriteFile{
+		Header: AsepriteHeader{
+			MagicNumber:    0xA5E0,
+			Frames:         uint16(len(builder.frames)),
+			WidthInPixels:  uint16(builder.width),
+			HeightInPixels: uint16(builder.height),
+			ColorDepth:     builder.colorMode,
+			Flags:          HeaderFlagLayerOpacityValid,
+			Speed:          100,
+			NumberOfColors: uint16(len(palette)),
+			PixelWidth:     1,
+			PixelHeight:    1,
+			GridWidth:      16,
+			GridHeight:     16,
+		},
+		Frames: make([]AsepriteFrame, len(builder.frames)),
+	}
+	for x, frame := range builder.frames {
+		aseFile.Frames[x] = AsepriteFrame{
+			parentHeader:              &aseFile.Header,
+			MagicNumber:               0xF1FA,
+			FrameDurationMilliseconds: frame.FrameDurationMilliseconds,
+			Cels:                      append([]AsepriteCelChunk2005(nil), frame.Cels...),
+		}
+		for y := range aseFile.Frames[x].Cels {
+			aseFile.Frames[x].Cels[y].parentHeader = &aseFile.Header
+		}
+	}
+
+	first := &aseFile.Frames[0]
+	first.ColorProfiles = []AsepriteColorProfileChunk2007{{Type: ColorProfileSRGB}}
+	first.Palettes = []AsepritePaletteChunk2019{newPaletteChunk(palette)}
+	first.Layers = append([]AsepriteLayerChunk2004(nil), builder.layers...)
+	if len(builder.tags) > 0 {
+		first.Tags = AsepriteTagsChunk2018{
+			NumTags: uint16(len(builder.tags)),
+			Tags:    append([]AsepriteTagsChunk2018Tag(nil), builder.tags...),
+		}
+	}
+	first.Slices = make([]AsepriteSliceChunk2022, len(builder.slices))
+	for x, sliceChunk := range builder.slices {
+		first.Slices[x] = sliceChunk
+		first.Slices[x].SliceKeysData = append([]SliceKey(nil), sliceChunk.SliceKeysData...)
+		for y := range first.Slices[x].SliceKeysData {
+			first.Slices[x].SliceKeysData[y].parentChunk = &first.Slices[x]
+		}
+	}
+
+	aseFile.fillCounts()
+	return aseFile, nil
+}
+
+func newPaletteChunk(palette color.Palette) AsepritePaletteChunk2019 {
+	paletteChunk := AsepritePaletteChunk2019{
+		PaletteSize:           uint32(len(palette)),
+		FirstColIndexToChange: 0,
+		LastColIndexToChange:  uint32(len(palette) - 1),
+		PaletteEntries:        make([]AsepritePaletteChunk2019Entry, len(palette)),
+	}
+	for x, col := range palette {
+		rgba := color.NRGBAModel.Convert(col).(color.NRGBA)
+		paletteChunk.PaletteEntries[x] = AsepritePaletteChunk2019Entry{
+			R: rgba.R, G: rgba.G, B: rgba.B, A: rgba.A,
+		}
+	}
+	return paletteChunk
+}
@@ -0,0 +1,170 @@
+package asefile
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// solidImage is a w x h image filled with col
+func solidImage(w, h int, col color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < len(img.Pix); x += 4 {
+		img.Pix[x], img.Pix[x+1], img.Pix[x+2], img.Pix[x+3] = col.R, col.G, col.B, col.A
+	}
+	return img
+}
+
+// encodeDecode encodes aseFile with opts and decodes the result
+func encodeDecode(t *testing.T, aseFile *AsepriteFile, opts EncodeOptions) *AsepriteFile {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := aseFile.EncodeWithOptions(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	var decoded AsepriteFile
+	if err := decoded.Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return &decoded
+}
+
+func TestSpriteBuilder(t *testing.T) {
+	builder := NewSprite(8, 4, ColorModeRGBA)
+	group := builder.AddGroup("Body", -1)
+	arm := builder.AddLayer("Arm", group)
+	top := builder.AddLayer("Top", -1)
+	idle := builder.AddFrame(100)
+	walk := builder.AddFrame(150)
+	builder.SetCel(idle, arm, solidImage(2, 2, color.NRGBA{255, 0, 0, 255}), image.Pt(1, 1))
+	builder.SetCel(walk, top, solidImage(3, 1, color.NRGBA{0, 0, 255, 128}), image.Pt(-1, 2))
+	builder.SetCel(walk, arm, solidImage(1, 1, color.NRGBA{0, 255, 0, 255}), image.Pt(0, 0))
+	builder.AddTag("walk", idle, walk, LoopPingPong)
+	builder.AddSlice("hit", SliceKey{FrameNumber: 0, SliceWidth: 4, SliceHeight: 4, PivotX: 2, PivotY: 3})
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := aseFile.Validate(); problems != nil {
+		t.Errorf("built sprite has problems %v", problems)
+	}
+	if aseFile.Header.Frames != 2 || aseFile.Header.MagicNumber != 0xA5E0 {
+		t.Errorf("header frames %d, magic number %04X", aseFile.Header.Frames, aseFile.Header.MagicNumber)
+	}
+	// Color profile, palette, tags, three layers, one cel and one slice
+	if chunks := aseFile.Frames[0].ChunksThisFrameExt; chunks != 8 {
+		t.Errorf("frame 0 counts %d chunks, want 8", chunks)
+	}
+	if cels := aseFile.Frames[1].Cels; cels[0].LayerIndex != uint16(arm) || cels[1].LayerIndex != uint16(top) {
+		t.Errorf("cels of frame 1 are not in layer order: %d, %d", cels[0].LayerIndex, cels[1].LayerIndex)
+	}
+
+	decoded := encodeDecode(t, aseFile, EncodeOptions{})
+	if diff := Diff(aseFile, decoded, EqualOptions{}); diff != "" {
+		t.Errorf("sprite changed through encoding:\n%s", diff)
+	}
+	if decoded.Header.FileSize == 0 || decoded.Frames[1].BytesThisFrame == 0 {
+		t.Errorf("encoding left the file size %d and frame size %d", decoded.Header.FileSize, decoded.Frames[1].BytesThisFrame)
+	}
+	layers := decoded.Frames[0].Layers
+	if layers[arm].LayerChildLevel != 1 || layers[top].LayerChildLevel != 0 {
+		t.Errorf("layer child levels %d and %d", layers[arm].LayerChildLevel, layers[top].LayerChildLevel)
+	}
+	slice := decoded.Frames[0].Slices[0]
+	if slice.Flags != SliceFlagHasPivot || slice.SliceKeysData[0].PivotY != 3 {
+		t.Errorf("slice flags %v, pivot %d,%d", slice.Flags, slice.SliceKeysData[0].PivotX, slice.SliceKeysData[0].PivotY)
+	}
+}
+
+func TestSpriteBuilderIndexed(t *testing.T) {
+	palette := color.Palette{color.NRGBA{}, color.NRGBA{10, 20, 30, 255}, color.NRGBA{40, 50, 60, 255}}
+	img := image.NewPaletted(image.Rect(0, 0, 2, 1), palette)
+	img.Pix = []byte{1, 2}
+	builder := NewSprite(2, 1, ColorModeIndexed).SetPalette(palette)
+	layer := builder.AddLayer("Layer", -1)
+	builder.SetCel(builder.AddFrame(100), layer, img, image.Pt(0, 0))
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := encodeDecode(t, aseFile, EncodeOptions{})
+	if pixels, _ := decoded.Frames[0].Cels[0].Pixels(); !bytes.Equal(pixels, []byte{1, 2}) {
+		t.Errorf("indexed pixels %v", pixels)
+	}
+	if entries := decoded.Frames[0].Palettes[0].PaletteEntries; len(entries) != 3 || entries[2].B != 60 {
+		t.Errorf("palette entries %v", entries)
+	}
+}
+
+func TestSpriteBuilderErrors(t *testing.T) {
+	img := solidImage(1, 1, color.NRGBA{})
+	for _, test := range []struct {
+		name  string
+		build func() *SpriteBuilder
+		want  string
+	}{
+		{"empty size", func() *SpriteBuilder { return NewSprite(0, 4, ColorModeRGBA) }, "out of range"},
+		{"color mode", func() *SpriteBuilder { return NewSprite(4, 4, ColorMode(24)) }, "unknown color mode"},
+		{"no frames", func() *SpriteBuilder { return NewSprite(4, 4, ColorModeRGBA) }, "no frames"},
+		{"indexed without palette", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeIndexed)
+			builder.AddFrame(100)
+			return builder
+		}, "need a palette"},
+		{"parent not a group", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeRGBA)
+			builder.AddLayer("child", builder.AddLayer("layer", -1))
+			return builder
+		}, "is not a group"},
+		{"closed group", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeRGBA)
+			group := builder.AddGroup("group", -1)
+			builder.AddLayer("top", -1)
+			builder.AddLayer("late", group)
+			return builder
+		}, "already closed"},
+		{"cel on group", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeRGBA)
+			builder.SetCel(builder.AddFrame(100), builder.AddGroup("group", -1), img, image.Pt(0, 0))
+			return builder
+		}, "not an image layer"},
+		{"cel frame", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeRGBA)
+			builder.SetCel(3, builder.AddLayer("layer", -1), img, image.Pt(0, 0))
+			return builder
+		}, "frame 3 out of range"},
+		{"indexed cel not paletted", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeIndexed).SetPalette(color.Palette{color.Black})
+			builder.SetCel(builder.AddFrame(100), builder.AddLayer("layer", -1), img, image.Pt(0, 0))
+			return builder
+		}, "need *image.Paletted"},
+		{"tag range", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeRGBA)
+			builder.AddTag("tag", 1, 0, LoopForward)
+			return builder
+		}, "frame range 1..0"},
+		{"tag direction", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeRGBA)
+			builder.AddTag("tag", 0, 0, LoopDirection(9))
+			return builder
+		}, "unknown loop direction"},
+		{"slice key order", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeRGBA)
+			builder.AddSlice("slice", SliceKey{FrameNumber: 2}, SliceKey{FrameNumber: 1})
+			return builder
+		}, "not in frame order"},
+		{"first error wins", func() *SpriteBuilder {
+			builder := NewSprite(4, 4, ColorModeRGBA)
+			builder.AddFrame(-1)
+			builder.AddSlice("slice")
+			return builder
+		}, "duration -1"},
+	} {
+		_, err := test.build().Build()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package asefile
+
+import (
+	"bytes"
+)
+
+// keepCompressed stores a copy of the zlib data, to be inflated into at most
+// size bytes by Pixels and counted against budget
+func (aseCelChunk *AsepriteCelChunk2005) keepCompressed(compressed []byte, size int64, budget *inflateBudget) {
+	aseCelChunk.compressed = append([]byte(nil), compressed...)
+	aseCelChunk.inflateSize = size
+	aseCelChunk.budget = budget
+}
+
+// Pixels returns the pixel data of an image cel, or the tile data of a
+// tilemap cel, and nil for a linked cel. A cel decoded with
+// DecodeOptions.LazyCels is inflated on the first call and the result cached
+// in RawCelData or Tiles; if the zlib data is damaged, what inflated before
+// the damage is returned along with the error and nothing is cached. Pixels
+// is not safe for concurrent use on one cel.
+func (aseCelChunk *AsepriteCelChunk2005) Pixels() ([]byte, error) {
+	switch aseCelChunk.CelType {
+	case CelTypeRaw:
+		return aseCelChunk.RawPixData, nil
+	case CelTypeCompressedImage:
+		if aseCelChunk.RawCelData == nil && aseCelChunk.compressed != nil {
+			data, err := aseCelChunk.inflateCompressed()
+			if err != nil {
+				return data, err
+			}
+			aseCelChunk.RawCelData = data
+		}
+		return aseCelChunk.RawCelData, nil
+	case CelTypeCompressedTilemap:
+		if aseCelChunk.Tiles == nil && aseCelChunk.compressed != nil {
+			data, err := aseCelChunk.inflateCompressed()
+			if err != nil {
+				return data, err
+			}
+			aseCelChunk.Tiles = data
+		}
+		return aseCelChunk.Tiles, nil
+	}
+	return nil, nil
+}
+
+// inflateCompressed inflates the kept zlib data, counting it against the
+// decompressed bytes limit of its decode the first time
+func (aseCelChunk *AsepriteCelChunk2005) inflateCompressed() ([]byte, error) {
+	if !aseCelChunk.budgeted && aseCelChunk.budget != nil {
+		if err := aseCelChunk.budget.reserve(aseCelChunk.inflateSize); err != nil {
+			return nil, err
+		}
+		aseCelChunk.budgeted = true
+	}
+	return inflateData(bytes.NewReader(aseCelChunk.compressed), aseCelChunk.inflateSize)
+}
+
+// DropPixels frees the pixels or tiles cached by Pixels, which inflates them
+// again on the next call, discarding any changes made to them. Cels decoded
+// without DecodeOptions.LazyCels have no compressed data to go back to and
+// are left untouched.
+func (aseCelChunk *AsepriteCelChunk2005) DropPixels() {
+	if aseCelChunk.compressed == nil {
+		return
+	}
+	switch aseCelChunk.CelType {
+	case CelTypeCompressedImage:
+		aseCelChunk.RawCelData = nil
+	case CelTypeCompressedTilemap:
+		aseCelChunk.Tiles = nil
+	}
+}
@@ -0,0 +1,141 @@
+package asefile
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// inflateAll calls Pixels on every cel of the sprite, each on its own goroutine
+func inflateAll(aseFile *AsepriteFile) []error {
+	var cels []*AsepriteCelChunk2005
+	for x := range aseFile.Frames {
+		for y := range aseFile.Frames[x].Cels {
+			cels = append(cels, &aseFile.Frames[x].Cels[y])
+		}
+	}
+	errs := make([]error, len(cels))
+	var wg sync.WaitGroup
+	for x := range cels {
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			_, errs[x] = cels[x].Pixels()
+		}(x)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestPixelsConcurrent(t *testing.T) {
+	want := decodeChica(t, DecodeOptions{})
+	// Chica's cels inflate to 22580 bytes, which fit the limit exactly
+	aseFile := decodeChica(t, DecodeOptions{LazyCels: true, MaxDecompressedBytes: 22580})
+	for _, err := range inflateAll(aseFile) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for x, aseFrame := range aseFile.Frames {
+		for y, cel := range aseFrame.Cels {
+			if !bytes.Equal(cel.RawCelData, want.Frames[x].Cels[y].RawCelData) {
+				t.Errorf("frame %d: cel %d differs from the eagerly decoded one", x, y)
+			}
+		}
+	}
+
+	// One byte less and exactly one cel goes over the shared budget
+	aseFile = decodeChica(t, DecodeOptions{LazyCels: true, MaxDecompressedBytes: 22579})
+	exceeded := 0
+	for _, err := range inflateAll(aseFile) {
+		if errors.Is(err, ErrLimitExceeded) {
+			exceeded += 1
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if exceeded != 1 {
+		t.Errorf("%d cels exceeded the decompressed limit, want 1", exceeded)
+	}
+
+	// A clone's cels count against no budget
+	aseFile = decodeChica(t, DecodeOptions{LazyCels: true, MaxDecompressedBytes: 1})
+	clone, err := aseFile.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range inflateAll(clone) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCelExtra(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	extra := &AsepriteCelExtraChunk2006{
+		Flags:             1,
+		PreciseX:          FixedFromFloat64(1.5),
+		PreciseY:          FixedFromFloat64(-2.25),
+		WidthCelInSprite:  FixedFromInt(20),
+		HeightCelInSprite: FixedFromFloat64(10.5),
+	}
+	cel := &aseFile.Frames[3].Cels[1]
+	cel.Extra = extra
+	var buf bytes.Buffer
+	if err := aseFile.EncodeWithOptions(&buf, EncodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	var decoded AsepriteFile
+	if err := decoded.DecodeWithOptions(bytes.NewReader(encoded), DecodeOptions{Strict: true}); err != nil {
+		t.Fatal(err)
+	}
+	for x, aseFrame := range decoded.Frames {
+		for y, decodedCel := range aseFrame.Cels {
+			if x == 3 && y == 1 {
+				if decodedCel.Extra == nil || *decodedCel.Extra != *extra {
+					t.Errorf("cel extra decoded as %+v, want %+v", decodedCel.Extra, extra)
+				}
+			} else if decodedCel.Extra != nil {
+				t.Errorf("frame %d: cel %d got a cel extra", x, y)
+			}
+		}
+	}
+	if diff := Diff(aseFile, &decoded, EqualOptions{}); diff != "" {
+		t.Errorf("cel extra changed the sprite:\n%s", diff)
+	}
+	buf.Reset()
+	if err := decoded.EncodeWithOptions(&buf, EncodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), encoded) {
+		t.Errorf("cel extra did not encode back to the same bytes")
+	}
+
+	// A cel filtered out takes its cel extra with it
+	layer := int(cel.LayerIndex)
+	opts := DecodeOptions{LayerIndices: []int{1 - layer}}
+	if err := decoded.DecodeWithOptions(bytes.NewReader(encoded), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, decodedCel := range decoded.Frames[3].Cels {
+		if decodedCel.Extra != nil {
+			t.Errorf("cel on layer %d got the cel extra of the filtered out cel", decodedCel.LayerIndex)
+		}
+	}
+
+	sprite, err := OpenReaderAt(bytes.NewReader(encoded), int64(len(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readCel, err := sprite.Cel(3, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readCel.Extra == nil || *readCel.Extra != *extra {
+		t.Errorf("cel extra read on its own as %+v, want %+v", readCel.Extra, extra)
+	}
+}
@@ -0,0 +1,222 @@
+package asefile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ChunkType is the type stored in the header of every chunk
+type ChunkType uint16
+
+const (
+	ChunkOldPalette0004 ChunkType = 0x0004
+	ChunkOldPalette0011 ChunkType = 0x0011
+	ChunkLayer          ChunkType = 0x2004
+	ChunkCel            ChunkType = 0x2005
+	ChunkCelExtra       ChunkType = 0x2006
+	ChunkColorProfile   ChunkType = 0x2007
+	ChunkExternalFiles  ChunkType = 0x2008
+	// Deprecated by Aseprite, never written by it
+	ChunkMask ChunkType = 0x2016
+	// Never used by Aseprite
+	ChunkPath     ChunkType = 0x2017
+	ChunkTags     ChunkType = 0x2018
+	ChunkPalette  ChunkType = 0x2019
+	ChunkUserData ChunkType = 0x2020
+	ChunkSlice    ChunkType = 0x2022
+	ChunkTileset  ChunkType = 0x2023
+)
+
+func (chunkType ChunkType) String() string {
+	switch chunkType {
+	case ChunkOldPalette0004:
+		return "old palette (0x0004)"
+	case ChunkOldPalette0011:
+		return "old palette (0x0011)"
+	case ChunkLayer:
+		return "layer"
+	case ChunkCel:
+		return "cel"
+	case ChunkCelExtra:
+		return "cel extra"
+	case ChunkColorProfile:
+		return "color profile"
+	case ChunkExternalFiles:
+		return "external files"
+	case ChunkMask:
+		return "mask"
+	case ChunkPath:
+		return "path"
+	case ChunkTags:
+		return "tags"
+	case ChunkPalette:
+		return "palette"
+	case ChunkUserData:
+		return "user data"
+	case ChunkSlice:
+		return "slice"
+	case ChunkTileset:
+		return "tileset"
+	}
+	return fmt.Sprintf("ChunkType(%04X)", uint16(chunkType))
+}
+
+// RawFrame is the header of a frame found by a ChunkScanner. Offset is from
+// the start of the file and Chunks is the count stored in the header.
+type RawFrame struct {
+	Index    int
+	Offset   int64
+	Size     uint32
+	Chunks   int
+	Duration uint16
+}
+
+// RawChunk is a chunk found by a ChunkScanner. Offset and Size include the 6
+// byte size and type prefix, which Data leaves out.
+type RawChunk struct {
+	FrameIndex int
+	Offset     int64
+	Size       uint32
+	Type       ChunkType
+	Data       []byte
+}
+
+/**
+ * ChunkScanner walks the frames and chunks of a sprite without interpreting
+ * the chunks, for looking into files this package fails to decode or decodes
+ * differently than expected:
+ *
+ *	for scanner.NextFrame() {
+ *		for scanner.NextChunk() {
+ *			chunk := scanner.Chunk()
+ *		}
+ *	}
+ *	if err := scanner.Err(); err != nil {
+ *
+ * The chunks of a frame are found by the frame size rather than the chunk
+ * count in its header. The Data of a chunk is only valid until the next call
+ * to NextChunk or NextFrame.
+ */
+type ChunkScanner struct {
+	Header AsepriteHeader
+	r      io.Reader
+	offset int64
+	frame  RawFrame
+	// Bytes of the current frame not scanned yet
+	frameLeft int64
+	chunk     RawChunk
+	chunkBuf  bytes.Buffer
+	err       error
+}
+
+// NewChunkScanner reads the sprite header from r and returns a scanner for
+// the frames that follow it
+func NewChunkScanner(r io.Reader) (*ChunkScanner, error) {
+	scanner := &ChunkScanner{r: r, offset: 128, frame: RawFrame{Index: -1}}
+	if err := scanner.Header.Decode(r); err != nil {
+		return nil, err
+	}
+	return scanner, nil
+}
+
+// NextFrame advances to the next frame, skipping the chunks of the current
+// one that were not scanned. It returns false after the last frame in the
+// header or on an error.
+func (scanner *ChunkScanner) NextFrame() bool {
+	if scanner.err != nil || scanner.frame.Index+1 >= int(scanner.Header.Frames) {
+		return false
+	}
+	if scanner.frameLeft > 0 {
+		if _, err := io.CopyN(io.Discard, scanner.r, scanner.frameLeft); err != nil {
+			scanner.err = truncated(err)
+			return false
+		}
+		scanner.offset += scanner.frameLeft
+	}
+	var frameHeader [16]byte
+	if _, err := io.ReadFull(scanner.r, frameHeader[:]); err != nil {
+		scanner.err = truncated(err)
+		return false
+	}
+	frame := RawFrame{
+		Index:    scanner.frame.Index + 1,
+		Offset:   scanner.offset,
+		Size:     ble.Uint32(frameHeader[0:]),
+		Chunks:   int(ble.Uint16(frameHeader[6:])),
+		Duration: ble.Uint16(frameHeader[8:]),
+	}
+	if ble.Uint16(frameHeader[4:]) != 0xF1FA {
+		scanner.err = FormatError(fmt.Sprintf("frame %d at offset %d: frame magic number incorrect", frame.Index, frame.Offset))
+		return false
+	}
+	if frame.Size < 16 {
+		scanner.err = FormatError(fmt.Sprintf("frame %d at offset %d: frame size %d is too small", frame.Index, frame.Offset, frame.Size))
+		return false
+	}
+	if chunksExt := ble.Uint32(frameHeader[12:]); chunksExt != 0 {
+		frame.Chunks = int(chunksExt)
+	}
+	scanner.frame = frame
+	scanner.frameLeft = int64(frame.Size) - 16
+	scanner.offset += 16
+	return true
+}
+
+// Frame returns the frame found by the last call to NextFrame
+func (scanner *ChunkScanner) Frame() RawFrame {
+	return scanner.frame
+}
+
+// NextChunk reads the next chunk of the current frame. It returns false at
+// the end of the frame or on an error.
+func (scanner *ChunkScanner) NextChunk() bool {
+	if scanner.err != nil || scanner.frameLeft == 0 {
+		return false
+	}
+	chunkOffset := scanner.offset
+	fail := func(format string, args ...interface{}) bool {
+		message := fmt.Sprintf(format, args...)
+		scanner.err = FormatError(fmt.Sprintf("frame %d, chunk at offset %d: %s", scanner.frame.Index, chunkOffset, message))
+		return false
+	}
+	if scanner.frameLeft < 6 {
+		return fail("%d bytes left in frame, too few for a chunk", scanner.frameLeft)
+	}
+	var chunkHeader [6]byte
+	if _, err := io.ReadFull(scanner.r, chunkHeader[:]); err != nil {
+		scanner.err = truncated(err)
+		return false
+	}
+	chunkSize := ble.Uint32(chunkHeader[0:])
+	if chunkSize < 6 || int64(chunkSize) > scanner.frameLeft {
+		return fail("chunk size %d does not fit in the %d bytes left in frame", chunkSize, scanner.frameLeft)
+	}
+	// The buffer grows as data arrives, so a damaged size in a short file
+	// does not allocate it all up front
+	scanner.chunkBuf.Reset()
+	if _, err := io.CopyN(&scanner.chunkBuf, scanner.r, int64(chunkSize)-6); err != nil {
+		scanner.err = truncated(err)
+		return false
+	}
+	scanner.chunk = RawChunk{
+		FrameIndex: scanner.frame.Index,
+		Offset:     chunkOffset,
+		Size:       chunkSize,
+		Type:       ChunkType(ble.Uint16(chunkHeader[4:])),
+		Data:       scanner.chunkBuf.Bytes(),
+	}
+	scanner.offset += int64(chunkSize)
+	scanner.frameLeft -= int64(chunkSize)
+	return true
+}
+
+// Chunk returns the chunk read by the last call to NextChunk
+func (scanner *ChunkScanner) Chunk() RawChunk {
+	return scanner.chunk
+}
+
+// Err returns the error that stopped the scan, if any
+func (scanner *ChunkScanner) Err() error {
+	return scanner.err
+}
@@ -0,0 +1,106 @@
+package asefile
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkScanner(t *testing.T) {
+	chica := readChica(t)
+	index, err := NewFileIndex(bytes.NewReader(chica), int64(len(chica)), DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner, err := NewChunkScanner(bytes.NewReader(chica))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanner.Header.Frames != 13 || scanner.Header.WidthInPixels != 32 {
+		t.Errorf("scanned header %+v", scanner.Header)
+	}
+	frames := 0
+	for scanner.NextFrame() {
+		frame := scanner.Frame()
+		want := index.Frames[frame.Index]
+		if frame.Index != frames || frame.Offset != want.Offset || frame.Size != want.BytesThisFrame ||
+			frame.Chunks != len(want.Chunks) {
+			t.Errorf("frame %d: scanned %+v, want offset %d, size %d and %d chunks",
+				frames, frame, want.Offset, want.BytesThisFrame, len(want.Chunks))
+		}
+		chunks := 0
+		for scanner.NextChunk() {
+			chunk := scanner.Chunk()
+			wantChunk := want.Chunks[chunks]
+			if chunk.FrameIndex != frames || chunk.Offset != wantChunk.Offset || chunk.Size != wantChunk.Size ||
+				chunk.Type != wantChunk.Type || !bytes.Equal(chunk.Data, chica[chunk.Offset+6:chunk.Offset+int64(chunk.Size)]) {
+				t.Errorf("frame %d: chunk %d scanned at %d with size %d and type %v, want %+v",
+					frames, chunks, chunk.Offset, chunk.Size, chunk.Type, wantChunk)
+			}
+			chunks += 1
+		}
+		if chunks != len(want.Chunks) {
+			t.Errorf("frame %d: scanned %d chunks, want %d", frames, chunks, len(want.Chunks))
+		}
+		frames += 1
+	}
+	if scanner.Err() != nil || frames != 13 {
+		t.Errorf("scanned %d frames and stopped with %v", frames, scanner.Err())
+	}
+	if scanner.NextFrame() || scanner.NextChunk() {
+		t.Errorf("scanner went on after the last frame")
+	}
+
+	// Frames whose chunks are not read are skipped whole
+	scanner, _ = NewChunkScanner(bytes.NewReader(chica))
+	for frames = 0; scanner.NextFrame(); frames += 1 {
+		if frames%2 == 0 {
+			scanner.NextChunk()
+		}
+		if scanner.Frame().Offset != index.Frames[frames].Offset {
+			t.Errorf("frame %d at offset %d, want %d", frames, scanner.Frame().Offset, index.Frames[frames].Offset)
+		}
+	}
+	if scanner.Err() != nil || frames != 13 {
+		t.Errorf("skipping chunks scanned %d frames and stopped with %v", frames, scanner.Err())
+	}
+}
+
+func TestChunkScannerErrors(t *testing.T) {
+	chica := readChica(t)
+	frames := chicaFrameIndex(t, chica)
+	damage := func(edit func(data []byte)) []byte {
+		data := append([]byte(nil), chica...)
+		edit(data)
+		return data
+	}
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		frames int
+	}{
+		{"frame magic number", damage(func(data []byte) { data[frames[3].Offset+4] = 0 }), 3},
+		{"frame size", damage(func(data []byte) { ble.PutUint32(data[frames[3].Offset:], 15) }), 3},
+		{"chunk size", damage(func(data []byte) { ble.PutUint32(data[frames[3].Chunks[0].Offset:], 0xFFFF) }), 4},
+		{"small chunk size", damage(func(data []byte) { ble.PutUint32(data[frames[3].Chunks[0].Offset:], 5) }), 4},
+		{"truncated frame", chica[:frames[5].Offset+10], 5},
+		{"truncated chunk", chica[:frames[5].Chunks[0].Offset+10], 6},
+	} {
+		scanner, err := NewChunkScanner(bytes.NewReader(test.data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		scanned := 0
+		for scanner.NextFrame() {
+			scanned += 1
+			for scanner.NextChunk() {
+			}
+		}
+		if scanner.Err() == nil || scanned != test.frames {
+			t.Errorf("%s: scanned %d frames and stopped with %v, want an error after %d frames",
+				test.name, scanned, scanner.Err(), test.frames)
+		}
+	}
+	if _, err := NewChunkScanner(bytes.NewReader(chica[:100])); err == nil {
+		t.Errorf("short header: no error")
+	}
+}
@@ -0,0 +1,354 @@
+package asefile
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+/**
+ * Clone returns a deep copy of the sprite. Copying an AsepriteFile by value
+ * shares its pixel buffers and leaves the cels, frames and slice keys of the
+ * copy pointing at the header and slices of the original; the clone has its
+ * own buffers and its pointers rebuilt.
+ *
+ * Cels decoded with DecodeOptions.LazyCels stay lazy in the clone, without
+ * counting against the decompressed bytes limit of the original decode.
+ * Custom chunks are copied by encoding them and decoding the result with
+ * their registered factory, and shared if the type is no longer registered.
+ * A custom chunk that fails to decode its own encoding fails the clone.
+ */
+func (aseFile *AsepriteFile) Clone() (*AsepriteFile, error) {
+	clone := &AsepriteFile{
+		Header:   aseFile.Header,
+		Frames:   make([]AsepriteFrame, len(aseFile.Frames)),
+		warnings: append([]Warning(nil), aseFile.warnings...),
+	}
+	if aseFile.recovery != nil {
+		recovery := *aseFile.recovery
+		recovery.LostFrames = append([]int(nil), recovery.LostFrames...)
+		recovery.Skipped = append([]SkippedBytes(nil), recovery.Skipped...)
+		recovery.DamagedChunks = append([]DamagedChunk(nil), recovery.DamagedChunks...)
+		clone.recovery = &recovery
+	}
+	for x := range aseFile.Frames {
+		if err := aseFile.Frames[x].cloneInto(&clone.Frames[x], &clone.Header); err != nil {
+			return nil, fmt.Errorf("frame %d: %w", x, err)
+		}
+	}
+	return clone, nil
+}
+
+func cloneBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	return append([]byte{}, data...)
+}
+
+// cloneInto deep copies the frame into clone, whose chunks point at header
+func (aseFrame *AsepriteFrame) cloneInto(clone *AsepriteFrame, header *AsepriteHeader) error {
+	*clone = *aseFrame
+	clone.parentHeader = header
+
+	clone.OldPalettes0004 = append([]AsepriteOldPaletteChunk0004(nil), aseFrame.OldPalettes0004...)
+	for x := range clone.OldPalettes0004 {
+		packets := append([]AsepriteOldPaletteChunk0004Packet(nil), clone.OldPalettes0004[x].Packets...)
+		for y := range packets {
+			packets[y].Colors = append([]AsepriteRGB24(nil), packets[y].Colors...)
+		}
+		clone.OldPalettes0004[x].Packets = packets
+	}
+	clone.OldPalettes0011 = append([]AsepritePaletteChunk0011(nil), aseFrame.OldPalettes0011...)
+	for x := range clone.OldPalettes0011 {
+		packets := append([]AsepritePaletteChunk0011Packet(nil), clone.OldPalettes0011[x].Packets...)
+		for y := range packets {
+			packets[y].Colors = append([]AsepriteRGB24(nil), packets[y].Colors...)
+		}
+		clone.OldPalettes0011[x].Packets = packets
+	}
+	clone.SpriteUserData.Properties = cloneProperties(aseFrame.SpriteUserData.Properties)
+	clone.Layers = append([]AsepriteLayerChunk2004(nil), aseFrame.Layers...)
+	for x := range clone.Layers {
+		clone.Layers[x].UserData.Properties = cloneProperties(clone.Layers[x].UserData.Properties)
+	}
+
+	clone.Cels = append([]AsepriteCelChunk2005(nil), aseFrame.Cels...)
+	for x := range clone.Cels {
+		cel := &clone.Cels[x]
+		cel.parentHeader = header
+		cel.budget = nil
+		cel.RawPixData = cloneBytes(cel.RawPixData)
+		cel.RawCelData = cloneBytes(cel.RawCelData)
+		cel.Tiles = cloneBytes(cel.Tiles)
+		cel.compressed = cloneBytes(cel.compressed)
+		if cel.Extra != nil {
+			extra := *cel.Extra
+			cel.Extra = &extra
+		}
+	}
+
+	clone.ColorProfiles = append([]AsepriteColorProfileChunk2007(nil), aseFrame.ColorProfiles...)
+	for x := range clone.ColorProfiles {
+		clone.ColorProfiles[x].ICCProfileDat = cloneBytes(clone.ColorProfiles[x].ICCProfileDat)
+	}
+	clone.ExternalFiles = append([]AsepriteExternalFilesChunk2008(nil), aseFrame.ExternalFiles...)
+	for x := range clone.ExternalFiles {
+		clone.ExternalFiles[x].ExternalFile = append([]AsepriteExternalFilesChunk2008Entry(nil), clone.ExternalFiles[x].ExternalFile...)
+	}
+	clone.Tags.Tags = append([]AsepriteTagsChunk2018Tag(nil), aseFrame.Tags.Tags...)
+	clone.Tags.UserData = append([]AsepriteUserDataChunk2020(nil), aseFrame.Tags.UserData...)
+	for x := range clone.Tags.UserData {
+		clone.Tags.UserData[x].Properties = cloneProperties(clone.Tags.UserData[x].Properties)
+	}
+	clone.Palettes = append([]AsepritePaletteChunk2019(nil), aseFrame.Palettes...)
+	for x := range clone.Palettes {
+		clone.Palettes[x].PaletteEntries = append([]AsepritePaletteChunk2019Entry(nil), clone.Palettes[x].PaletteEntries...)
+	}
+	clone.Slices = append([]AsepriteSliceChunk2022(nil), aseFrame.Slices...)
+	for x := range clone.Slices {
+		keys := append([]AsepriteSliceChunk2022Data(nil), clone.Slices[x].SliceKeysData...)
+		for y := range keys {
+			keys[y].parentChunk = &clone.Slices[x]
+		}
+		clone.Slices[x].SliceKeysData = keys
+		clone.Slices[x].UserData.Properties = cloneProperties(clone.Slices[x].UserData.Properties)
+	}
+	clone.Tilesets = append([]AsepriteTilesetChunk2023(nil), aseFrame.Tilesets...)
+	for x := range clone.Tilesets {
+		clone.Tilesets[x].CompressedTilesetImg = cloneBytes(clone.Tilesets[x].CompressedTilesetImg)
+		clone.Tilesets[x].UserData.Properties = cloneProperties(clone.Tilesets[x].UserData.Properties)
+	}
+
+	clone.CustomChunks = append([]Chunk(nil), aseFrame.CustomChunks...)
+	for x, custom := range clone.CustomChunks {
+		factory := registeredChunk(custom.ChunkType())
+		if factory == nil {
+			continue
+		}
+		var chunkDat bytes.Buffer
+		custom.Encode(&chunkDat)
+		copied := factory()
+		if err := copied.Decode(&chunkDat); err != nil {
+			return fmt.Errorf("copying %v chunk: %w", custom.ChunkType(), err)
+		}
+		clone.CustomChunks[x] = copied
+	}
+	return nil
+}
+
+// EqualOptions relax what Equal considers a difference
+type EqualOptions struct {
+	// IgnoreCompression compares cels by their pixels, so that raw and
+	// compressed cels, and linked cels and copies of the cel they link to,
+	// are equal
+	IgnoreCompression bool
+}
+
+// Equal reports whether two sprites have the same content. Reserved bytes
+// and the sizes and counts the encoder fills in are not compared, and the
+// cels of a frame are matched by layer rather than by their order.
+func Equal(a, b *AsepriteFile, opts EqualOptions) bool {
+	return Diff(a, b, opts) == ""
+}
+
+// Diff describes the first difference Equal finds between two sprites, or
+// returns "" if they are equal. Neither sprite is changed: cels decoded with
+// DecodeOptions.LazyCels are inflated into buffers dropped after comparing.
+func Diff(a, b *AsepriteFile, opts EqualOptions) string {
+	cmp := &comparer{a: a, b: b, opts: opts}
+	cmp.file()
+	return cmp.diff
+}
+
+// comparer walks two sprites until the first difference
+type comparer struct {
+	a, b *AsepriteFile
+	opts EqualOptions
+	diff string
+}
+
+// Fields filled in by the encoder, which differ with compression and chunk
+// order without the content changing
+var derivedFields = map[reflect.Type]map[string]bool{
+	reflect.TypeOf(AsepriteHeader{}): {"FileSize": true, "MagicNumber": true, "Frames": true},
+	reflect.TypeOf(AsepriteFrame{}): {"BytesThisFrame": true, "MagicNumber": true, "ChunksThisFrame": true,
+		"ChunksThisFrameExt": true, "Cels": true},
+}
+
+func (cmp *comparer) differ(path string, format string, args ...interface{}) bool {
+	if cmp.diff == "" {
+		cmp.diff = path + ": " + fmt.Sprintf(format, args...)
+	}
+	return false
+}
+
+func (cmp *comparer) file() bool {
+	if !cmp.value("Header", reflect.ValueOf(cmp.a.Header), reflect.ValueOf(cmp.b.Header)) {
+		return false
+	}
+	if len(cmp.a.Frames) != len(cmp.b.Frames) {
+		return cmp.differ("Frames", "%d frames != %d frames", len(cmp.a.Frames), len(cmp.b.Frames))
+	}
+	for x := range cmp.a.Frames {
+		path := fmt.Sprintf("Frames[%d]", x)
+		if !cmp.value(path, reflect.ValueOf(cmp.a.Frames[x]), reflect.ValueOf(cmp.b.Frames[x])) ||
+			!cmp.cels(path, x) {
+			return false
+		}
+	}
+	return true
+}
+
+// value compares the exported fields of a and b, which hold every field with
+// content; the unexported ones are reserved bytes and internal pointers
+func (cmp *comparer) value(path string, a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Struct:
+		skip := derivedFields[a.Type()]
+		for x := 0; x < a.NumField(); x += 1 {
+			field := a.Type().Field(x)
+			if field.PkgPath != "" || skip[field.Name] {
+				continue
+			}
+			if !cmp.value(path+"."+field.Name, a.Field(x), b.Field(x)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if a.Type().Elem().Kind() == reflect.Uint8 {
+			if !bytes.Equal(a.Bytes(), b.Bytes()) {
+				return cmp.differ(path, "%d bytes differ from %d bytes", a.Len(), b.Len())
+			}
+			return true
+		}
+		if a.Len() != b.Len() {
+			return cmp.differ(path, "length %d != %d", a.Len(), b.Len())
+		}
+		for x := 0; x < a.Len(); x += 1 {
+			if !cmp.value(fmt.Sprintf("%s[%d]", path, x), a.Index(x), b.Index(x)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for x := 0; x < a.Len(); x += 1 {
+			if !cmp.value(fmt.Sprintf("%s[%d]", path, x), a.Index(x), b.Index(x)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				return cmp.differ(path, "only one is nil")
+			}
+			return true
+		}
+		return cmp.value(path, a.Elem(), b.Elem())
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() || a.Elem().Type() != b.Elem().Type() {
+			if a.IsNil() && b.IsNil() {
+				return true
+			}
+			return cmp.differ(path, "%T != %T", a.Interface(), b.Interface())
+		}
+		// Custom chunks, compared by their encoding, and property values
+		customA, okA := a.Interface().(Chunk)
+		customB, okB := b.Interface().(Chunk)
+		if !okA || !okB {
+			return cmp.value(path, a.Elem(), b.Elem())
+		}
+		if customA.ChunkType() != customB.ChunkType() {
+			return cmp.differ(path, "chunks of different types")
+		}
+		var encodedA, encodedB bytes.Buffer
+		customA.Encode(&encodedA)
+		customB.Encode(&encodedB)
+		if !bytes.Equal(encodedA.Bytes(), encodedB.Bytes()) {
+			return cmp.differ(path, "encoded chunks differ")
+		}
+		return true
+	}
+	if a.Interface() != b.Interface() {
+		return cmp.differ(path, "%v != %v", a.Interface(), b.Interface())
+	}
+	return true
+}
+
+// cels compares the cels of a frame layer by layer, lazily decoded cels by
+// the content of their pixels
+func (cmp *comparer) cels(path string, frame int) bool {
+	celsA, celsB := celsByLayer(cmp.a.Frames[frame].Cels), celsByLayer(cmp.b.Frames[frame].Cels)
+	if len(celsA) != len(celsB) {
+		return cmp.differ(path+".Cels", "%d cels != %d cels", len(celsA), len(celsB))
+	}
+	for x := range celsA {
+		celPath := fmt.Sprintf("%s.Cels[layer %d]", path, celsA[x].LayerIndex)
+		if celsA[x].LayerIndex != celsB[x].LayerIndex {
+			return cmp.differ(celPath, "no cel on layer %d in the second sprite", celsA[x].LayerIndex)
+		}
+		normalA, pixelsA, errA := cmp.normalCel(cmp.a, frame, celsA[x])
+		normalB, pixelsB, errB := cmp.normalCel(cmp.b, frame, celsB[x])
+		if errA != nil || errB != nil {
+			return cmp.differ(celPath, "pixels: %v, %v", errA, errB)
+		}
+		if !cmp.value(celPath, reflect.ValueOf(normalA), reflect.ValueOf(normalB)) {
+			return false
+		}
+		if !bytes.Equal(pixelsA, pixelsB) {
+			return cmp.differ(celPath+".Pixels", "%d bytes differ from %d bytes", len(pixelsA), len(pixelsB))
+		}
+	}
+	return true
+}
+
+func celsByLayer(cels []AsepriteCelChunk2005) []*AsepriteCelChunk2005 {
+	sorted := make([]*AsepriteCelChunk2005, len(cels))
+	for x := range cels {
+		sorted[x] = &cels[x]
+	}
+	sort.SliceStable(sorted, func(x, y int) bool { return sorted[x].LayerIndex < sorted[y].LayerIndex })
+	return sorted
+}
+
+// normalCel returns the fields of a cel to compare, without its pixel
+// buffers, and its pixels. Ignoring compression, a linked cel is replaced by
+// the cel it links to at its own position and raw cels count as compressed.
+func (cmp *comparer) normalCel(aseFile *AsepriteFile, frame int, cel *AsepriteCelChunk2005) (AsepriteCelChunk2005, []byte, error) {
+	source := cel
+	if cmp.opts.IgnoreCompression {
+		visited := make(map[int]bool)
+		for source.CelType == CelTypeLinked && !visited[frame] {
+			visited[frame] = true
+			frame = int(source.FramePosToLinkWith)
+			if frame >= len(aseFile.Frames) {
+				break
+			}
+			var linked *AsepriteCelChunk2005
+			for x := range aseFile.Frames[frame].Cels {
+				if aseFile.Frames[frame].Cels[x].LayerIndex == cel.LayerIndex {
+					linked = &aseFile.Frames[frame].Cels[x]
+				}
+			}
+			if linked == nil {
+				break
+			}
+			source = linked
+		}
+	}
+	// Pixels on a copy inflates a lazy cel without caching the result in the
+	// sprite or counting it against the limit of its decode
+	inflated := *source
+	inflated.budget = nil
+	pixels, err := inflated.Pixels()
+	normal := *source
+	normal.X, normal.Y, normal.OpacityLevel, normal.Extra = cel.X, cel.Y, cel.OpacityLevel, cel.Extra
+	normal.RawPixData, normal.RawCelData, normal.Tiles = nil, nil, nil
+	if cmp.opts.IgnoreCompression && normal.CelType == CelTypeRaw {
+		normal.CelType = CelTypeCompressedImage
+	}
+	return normal, pixels, err
+}
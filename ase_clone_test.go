@@ -0,0 +1,205 @@
+package asefile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	aseFile.Frames[0].Slices = append(aseFile.Frames[0].Slices, AsepriteSliceChunk2022{
+		NumSliceKeys:  1,
+		Name:          "Slice",
+		SliceKeysData: []SliceKey{{SliceWidth: 4, SliceHeight: 4}},
+	})
+	aseFile.Frames[0].Slices[0].SliceKeysData[0].parentChunk = &aseFile.Frames[0].Slices[0]
+	clone, err := aseFile.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := Diff(aseFile, clone, EqualOptions{}); diff != "" {
+		t.Fatalf("clone differs from the sprite:\n%s", diff)
+	}
+	for x := range clone.Frames {
+		if clone.Frames[x].parentHeader != &clone.Header {
+			t.Fatalf("frame %d of the clone points at another header", x)
+		}
+		for _, cel := range clone.Frames[x].Cels {
+			if cel.parentHeader != &clone.Header {
+				t.Fatalf("frame %d: cel on layer %d points at another header", x, cel.LayerIndex)
+			}
+		}
+	}
+	if key := clone.Frames[0].Slices[0].SliceKeysData[0]; key.parentChunk != &clone.Frames[0].Slices[0] {
+		t.Errorf("slice key of the clone points at another slice")
+	}
+
+	// Changing the clone leaves the original as it was
+	original, err := aseFile.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone.Header.Speed += 1
+	clone.Frames[1].Cels[0].RawCelData[0] ^= 0xFF
+	clone.Frames[0].Layers[0].LayerName = "Renamed"
+	clone.Frames[0].Slices[0].SliceKeysData[0].SliceWidth = 8
+	clone.Frames[0].Palettes[0].PaletteEntries[0].R ^= 0xFF
+	if diff := Diff(original, aseFile, EqualOptions{}); diff != "" {
+		t.Errorf("changing the clone changed the sprite:\n%s", diff)
+	}
+}
+
+func TestCloneLazyCels(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{LazyCels: true})
+	clone, err := aseFile.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cel := &clone.Frames[2].Cels[0]
+	if cel.RawCelData != nil || cel.compressed == nil {
+		t.Fatalf("lazy cel inflated by cloning")
+	}
+	cel.compressed[0] ^= 0xFF
+	if _, err := aseFile.Frames[2].Cels[0].Pixels(); err != nil {
+		t.Errorf("damaging the clone's zlib data damaged the sprite: %v", err)
+	}
+}
+
+func TestDiffLazyCels(t *testing.T) {
+	lazy := decodeChica(t, DecodeOptions{LazyCels: true})
+	other := decodeChica(t, DecodeOptions{LazyCels: true})
+	// Diffs running at once only read the sprites
+	done := make(chan string)
+	for x := 0; x < 2; x += 1 {
+		go func() { done <- Diff(lazy, other, EqualOptions{IgnoreCompression: true}) }()
+	}
+	for x := 0; x < 2; x += 1 {
+		if diff := <-done; diff != "" {
+			t.Errorf("lazy sprites differ:\n%s", diff)
+		}
+	}
+	for _, aseFile := range []*AsepriteFile{lazy, other} {
+		for x := range aseFile.Frames {
+			for _, cel := range aseFile.Frames[x].Cels {
+				if cel.CelType == CelTypeCompressedImage && (cel.RawCelData != nil || cel.budgeted) {
+					t.Fatalf("frame %d: cel on layer %d inflated by comparing", x, cel.LayerIndex)
+				}
+			}
+		}
+	}
+
+	cel := &lazy.Frames[2].Cels[0]
+	cel.compressed = compressData(t, make([]byte, cel.inflateSize))
+	if diff := Diff(lazy, other, EqualOptions{}); !strings.Contains(diff, "Frames[2].Cels[layer ") ||
+		!strings.HasSuffix(strings.SplitN(diff, ":", 2)[0], ".Pixels") {
+		t.Errorf("diff %q, want it to mention the changed pixels", diff)
+	}
+}
+
+func TestCloneCustomChunks(t *testing.T) {
+	registerTestChunk(t, 0x7C01, func() Chunk { return &plainChunk{chunkType: 0x7C01} })
+	registerTestChunk(t, 0x7C02, func() Chunk { return &plainChunk{chunkType: 0x7C02} })
+	aseFile := decodeChica(t, DecodeOptions{})
+	registered := &plainChunk{chunkType: 0x7C01, Data: []byte("copied")}
+	unregistered := &plainChunk{chunkType: 0x7C03, Data: []byte("shared")}
+	aseFile.Frames[1].CustomChunks = []Chunk{registered, unregistered}
+	clone, err := aseFile.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := clone.Frames[1].CustomChunks
+	if copied, ok := custom[0].(*plainChunk); !ok || copied == registered || string(copied.Data) != "copied" {
+		t.Errorf("registered chunk cloned as %+v", custom[0])
+	}
+	if custom[1] != unregistered {
+		t.Errorf("unregistered chunk was not shared")
+	}
+
+	// A chunk whose encoding does not decode fails the clone
+	aseFile.Frames[3].CustomChunks = []Chunk{&plainChunk{chunkType: 0x7C02, Data: []byte{0xFF, 1}}}
+	if clone, err := aseFile.Clone(); err == nil || clone != nil || !strings.Contains(err.Error(), "frame 3") {
+		t.Errorf("cloning an undecodable custom chunk gave %v, %v", clone, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		edit func(aseFile *AsepriteFile)
+		want string
+	}{
+		{"header", func(aseFile *AsepriteFile) { aseFile.Header.Speed += 1 }, "Header.Speed"},
+		{"frame duration", func(aseFile *AsepriteFile) { aseFile.Frames[4].FrameDurationMilliseconds += 1 },
+			"Frames[4].FrameDurationMilliseconds"},
+		{"layer name", func(aseFile *AsepriteFile) { aseFile.Frames[0].Layers[1].LayerName = "Other" },
+			"Frames[0].Layers[1].LayerName"},
+		{"palette entry", func(aseFile *AsepriteFile) { aseFile.Frames[0].Palettes[0].PaletteEntries[3].G ^= 1 },
+			"Frames[0].Palettes[0].PaletteEntries[3].G"},
+		{"cel position", func(aseFile *AsepriteFile) { aseFile.Frames[2].Cels[0].X += 1 }, "Frames[2].Cels[layer "},
+		{"cel pixels", func(aseFile *AsepriteFile) { aseFile.Frames[2].Cels[0].RawCelData[0] ^= 1 }, ".Pixels"},
+		{"missing cel", func(aseFile *AsepriteFile) { aseFile.Frames[2].Cels = aseFile.Frames[2].Cels[1:] },
+			"Frames[2].Cels"},
+		{"frame count", func(aseFile *AsepriteFile) { aseFile.Frames = aseFile.Frames[:5] }, "Frames: 13 frames != 5 frames"},
+		{"custom chunk", func(aseFile *AsepriteFile) {
+			aseFile.Frames[1].CustomChunks = []Chunk{&plainChunk{chunkType: 0x7C01, Data: []byte{1}}}
+		}, "Frames[1].CustomChunks"},
+	} {
+		aseFile := decodeChica(t, DecodeOptions{})
+		edited, err := aseFile.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.edit(edited)
+		diff := Diff(aseFile, edited, EqualOptions{})
+		if !strings.Contains(diff, test.want) {
+			t.Errorf("%s: diff %q, want it to mention %q", test.name, diff, test.want)
+		}
+		if Equal(aseFile, edited, EqualOptions{}) || Equal(aseFile, edited, EqualOptions{IgnoreCompression: true}) {
+			t.Errorf("%s: edited sprite is equal", test.name)
+		}
+	}
+}
+
+func TestEqualIgnores(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	for _, test := range []struct {
+		name string
+		edit func(aseFile *AsepriteFile)
+	}{
+		{"reserved bytes", func(aseFile *AsepriteFile) {
+			aseFile.Header.reserved[0] = 1
+			aseFile.Frames[0].Layers[0].forFuture[0] = 1
+			aseFile.Frames[2].Cels[0].future[0] = 1
+		}},
+		{"sizes and counts", func(aseFile *AsepriteFile) {
+			aseFile.Header.FileSize = 1
+			aseFile.Frames[3].BytesThisFrame = 1
+			aseFile.Frames[3].ChunksThisFrame = 0
+			aseFile.Frames[3].ChunksThisFrameExt = 1
+		}},
+		{"cel order", func(aseFile *AsepriteFile) {
+			cels := aseFile.Frames[2].Cels
+			cels[0], cels[1] = cels[1], cels[0]
+		}},
+	} {
+		edited, err := aseFile.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.edit(edited)
+		if diff := Diff(aseFile, edited, EqualOptions{}); diff != "" {
+			t.Errorf("%s: sprites differ:\n%s", test.name, diff)
+		}
+	}
+
+	// Ignoring compression, a raw cel equals the compressed one
+	raw, err := aseFile.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cel := &raw.Frames[2].Cels[0]
+	cel.CelType, cel.RawPixData, cel.RawCelData = CelTypeRaw, cel.RawCelData, nil
+	if Equal(aseFile, raw, EqualOptions{}) || !Equal(aseFile, raw, EqualOptions{IgnoreCompression: true}) {
+		t.Errorf("raw cel should only be equal when ignoring compression")
+	}
+}
@@ -0,0 +1,370 @@
+package asefile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ErrUnsupportedColorProfile is returned, wrapped with the reason, for color
+// profiles whose pixels can not be converted to sRGB
+var ErrUnsupportedColorProfile = errors.New("unsupported color profile")
+
+// linearToSRGBSteps is the number of entries in the table encoding linear
+// values back to sRGB, enough that every 8 bit output is reachable
+const linearToSRGBSteps = 4096
+
+// xyzD50ToLinearSRGB converts the D50 adapted XYZ of ICC profiles to linear
+// sRGB, with the Bradford adaptation from D50 to the D65 white of sRGB
+var xyzD50ToLinearSRGB = [9]float64{
+	3.1338561, -1.6168667, -0.4906146,
+	-0.9787684, 1.9161415, 0.0334540,
+	0.0719453, -0.2289914, 1.4052427,
+}
+
+/**
+ * ColorConverter turns the colors of a sprite into sRGB, the color space
+ * images are shown in when they carry no profile. Each channel is decoded to
+ * linear light with the transfer curve of the profile, the primaries are
+ * mapped onto those of sRGB and the result is encoded with the sRGB curve.
+ * Alpha is left as is.
+ *
+ * Sprites without a profile or with the sRGB profile get the identity
+ * converter, which returns colors unchanged.
+ */
+type ColorConverter struct {
+	identity bool
+	// Linear value of each 8 bit channel value, per channel
+	toLinear [3][256]float64
+	// Row major 3x3 matrix from the linear primaries of the profile to
+	// linear sRGB
+	matrix   [9]float64
+	toSRGB   [linearToSRGBSteps]byte
+	matrixed bool
+}
+
+// Converter returns the converter from the color space of the profile to
+// sRGB. Profiles using the sRGB curves are converted as is, fixed gamma
+// profiles with the sRGB primaries and a pure power curve, and ICC profiles
+// when they are RGB matrix/TRC profiles. Other profiles, such as LUT based
+// ICC profiles, give an error wrapping ErrUnsupportedColorProfile.
+func (aseColProfile *AsepriteColorProfileChunk2007) Converter() (*ColorConverter, error) {
+	switch aseColProfile.Type {
+	case ColorProfileNone, ColorProfileSRGB:
+		if !aseColProfile.Flags.FixedGamma() {
+			return &ColorConverter{identity: true}, nil
+		}
+		gamma := aseColProfile.FixedGamma.Float64()
+		if gamma <= 0 {
+			return nil, fmt.Errorf("%w: fixed gamma %v", ErrUnsupportedColorProfile, aseColProfile.FixedGamma)
+		}
+		curve := func(v float64) float64 { return math.Pow(v, gamma) }
+		return newColorConverter([3]func(float64) float64{curve, curve, curve}, nil), nil
+	case ColorProfileICC:
+		return iccConverter(aseColProfile.ICCProfileDat)
+	}
+	return nil, fmt.Errorf("%w: profile type %v", ErrUnsupportedColorProfile, aseColProfile.Type)
+}
+
+// ColorConverter returns the converter for the color profile of the sprite,
+// the last one in the first frame. Sprites without a profile are taken as
+// sRGB.
+func (aseFile *AsepriteFile) ColorConverter() (*ColorConverter, error) {
+	if len(aseFile.Frames) == 0 {
+		return &ColorConverter{identity: true}, nil
+	}
+	return colorProfilesConverter(aseFile.Frames[0].ColorProfiles)
+}
+
+// ColorConverter returns the converter for the color profile of the sprite
+func (sprite *Sprite) ColorConverter() (*ColorConverter, error) {
+	return colorProfilesConverter(sprite.colorProfiles)
+}
+
+func colorProfilesConverter(profiles []AsepriteColorProfileChunk2007) (*ColorConverter, error) {
+	if len(profiles) == 0 {
+		return &ColorConverter{identity: true}, nil
+	}
+	return profiles[len(profiles)-1].Converter()
+}
+
+// newColorConverter tabulates the transfer curves of the channels, with a nil
+// matrix for profiles sharing the primaries of sRGB
+func newColorConverter(curves [3]func(float64) float64, matrix *[9]float64) *ColorConverter {
+	converter := &ColorConverter{}
+	for c := 0; c < 3; c += 1 {
+		for v := 0; v < 256; v += 1 {
+			converter.toLinear[c][v] = curves[c](float64(v) / 255)
+		}
+	}
+	if matrix != nil {
+		converter.matrix = *matrix
+		converter.matrixed = true
+	}
+	for x := 0; x < linearToSRGBSteps; x += 1 {
+		converter.toSRGB[x] = byte(math.Round(linearToSRGB(float64(x)/(linearToSRGBSteps-1)) * 255))
+	}
+	return converter
+}
+
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// Identity reports whether the converter leaves colors unchanged
+func (converter *ColorConverter) Identity() bool {
+	return converter.identity
+}
+
+// Convert returns the sRGB value of a color of the sprite
+func (converter *ColorConverter) Convert(c color.NRGBA) color.NRGBA {
+	if converter.identity {
+		return c
+	}
+	r, g, b := converter.fromLinear(converter.toLinear[0][c.R], converter.toLinear[1][c.G], converter.toLinear[2][c.B])
+	return color.NRGBA{r, g, b, c.A}
+}
+
+// linear returns the linear light value of an 8 bit value of channel c
+func (converter *ColorConverter) linear(c int, v byte) float64 {
+	if converter.identity {
+		return srgbToLinear(float64(v) / 255)
+	}
+	return converter.toLinear[c][v]
+}
+
+// fromLinear returns the sRGB value of a color in linear light, given in the
+// primaries of the profile
+func (converter *ColorConverter) fromLinear(r, g, b float64) (byte, byte, byte) {
+	if converter.matrixed {
+		m := &converter.matrix
+		r, g, b = m[0]*r+m[1]*g+m[2]*b, m[3]*r+m[4]*g+m[5]*b, m[6]*r+m[7]*g+m[8]*b
+	}
+	return converter.encode(r), converter.encode(g), converter.encode(b)
+}
+
+func (converter *ColorConverter) encode(v float64) byte {
+	if !(v > 0) {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	if converter.identity {
+		return byte(math.Round(linearToSRGB(v) * 255))
+	}
+	return converter.toSRGB[int(v*(linearToSRGBSteps-1)+0.5)]
+}
+
+// ConvertPixels converts RGBA pixels, 4 bytes each and not premultiplied,
+// in place
+func (converter *ColorConverter) ConvertPixels(pix []byte) {
+	if converter.identity {
+		return
+	}
+	for x := 0; x+4 <= len(pix); x += 4 {
+		c := converter.Convert(color.NRGBA{pix[x], pix[x+1], pix[x+2], pix[x+3]})
+		pix[x], pix[x+1], pix[x+2] = c.R, c.G, c.B
+	}
+}
+
+// ConvertPalette returns a converted copy of palette
+func (converter *ColorConverter) ConvertPalette(palette color.Palette) color.Palette {
+	converted := make(color.Palette, len(palette))
+	for x, c := range palette {
+		converted[x] = converter.Convert(color.NRGBAModel.Convert(c).(color.NRGBA))
+	}
+	return converted
+}
+
+// ConvertImage returns a converted copy of img: an *image.Paletted with the
+// palette converted for paletted images, an *image.NRGBA for the others
+func (converter *ColorConverter) ConvertImage(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if paletted, ok := img.(*image.Paletted); ok {
+		converted := image.NewPaletted(bounds, converter.ConvertPalette(paletted.Palette))
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+			copy(converted.Pix[converted.PixOffset(bounds.Min.X, y):], paletted.Pix[paletted.PixOffset(bounds.Min.X, y):][:bounds.Dx()])
+		}
+		return converted
+	}
+	converted := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+			converted.SetNRGBA(x, y, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+	converter.ConvertPixels(converted.Pix)
+	return converted
+}
+
+// ImageSRGB returns the pixels of an image cel like Image, converted from the
+// color profile of the sprite to sRGB
+func (cel *Cel) ImageSRGB() (image.Image, error) {
+	converter, err := cel.sprite.ColorConverter()
+	if err != nil {
+		return nil, err
+	}
+	img, err := cel.Image()
+	if err != nil || converter.Identity() {
+		return img, err
+	}
+	return converter.ConvertImage(img), nil
+}
+
+/**
+ * iccConverter builds the converter of an embedded ICC profile. Only RGB
+ * matrix/TRC profiles are handled, the kind Aseprite embeds: the profile
+ * header is followed by a tag table whose rXYZ, gXYZ and bXYZ tags hold the
+ * D50 adapted primaries and whose rTRC, gTRC and bTRC tags hold the transfer
+ * curve of each channel, as a 'curv' gamma or table or as a 'para'
+ * parametric curve. All numbers are big endian.
+ *
+ * Offset  Field
+ * 0       DWORD   Profile size
+ * 16      DWORD   Color space, 'RGB '
+ * 20      DWORD   Profile connection space, 'XYZ '
+ * 128     DWORD   Tag count
+ * 132     + For each tag
+ *           DWORD   Signature
+ *           DWORD   Offset from the start of the profile
+ *           DWORD   Size
+ */
+func iccConverter(profile []byte) (*ColorConverter, error) {
+	if len(profile) < 132 {
+		return nil, FormatError(fmt.Sprintf("ICC profile of %d bytes is shorter than its header", len(profile)))
+	}
+	if colorSpace := string(profile[16:20]); colorSpace != "RGB " {
+		return nil, fmt.Errorf("%w: ICC profile for color space %q", ErrUnsupportedColorProfile, colorSpace)
+	}
+	if pcs := string(profile[20:24]); pcs != "XYZ " {
+		return nil, fmt.Errorf("%w: ICC profile connection space %q", ErrUnsupportedColorProfile, pcs)
+	}
+	numTags := binary.BigEndian.Uint32(profile[128:])
+	if uint64(numTags)*12 > uint64(len(profile)-132) {
+		return nil, FormatError(fmt.Sprintf("ICC profile of %d bytes has %d tags", len(profile), numTags))
+	}
+	tags := make(map[string][]byte)
+	for x := uint32(0); x < numTags; x += 1 {
+		entry := profile[132+x*12:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if uint64(offset)+uint64(size) > uint64(len(profile)) {
+			return nil, FormatError(fmt.Sprintf("ICC tag %q at %d, %d bytes, ends past the profile of %d bytes",
+				entry[:4], offset, size, len(profile)))
+		}
+		tags[string(entry[:4])] = profile[offset : offset+size]
+	}
+	var columns [3][3]float64
+	var curves [3]func(float64) float64
+	for c, channel := range []string{"r", "g", "b"} {
+		xyzTag, trcTag := tags[channel+"XYZ"], tags[channel+"TRC"]
+		if xyzTag == nil || trcTag == nil {
+			return nil, fmt.Errorf("%w: ICC profile is not a matrix/TRC profile", ErrUnsupportedColorProfile)
+		}
+		if len(xyzTag) < 20 || string(xyzTag[:4]) != "XYZ " {
+			return nil, FormatError(fmt.Sprintf("ICC %sXYZ tag is not an XYZ value", channel))
+		}
+		for y := 0; y < 3; y += 1 {
+			columns[c][y] = s15Fixed16(xyzTag[8+y*4:])
+		}
+		curve, err := iccCurve(trcTag)
+		if err != nil {
+			return nil, fmt.Errorf("ICC %sTRC tag: %w", channel, err)
+		}
+		curves[c] = curve
+	}
+	// The primaries are the columns of the matrix to D50 XYZ
+	var toXYZ, matrix [9]float64
+	for row := 0; row < 3; row += 1 {
+		for c := 0; c < 3; c += 1 {
+			toXYZ[row*3+c] = columns[c][row]
+		}
+	}
+	for row := 0; row < 3; row += 1 {
+		for c := 0; c < 3; c += 1 {
+			for k := 0; k < 3; k += 1 {
+				matrix[row*3+c] += xyzD50ToLinearSRGB[row*3+k] * toXYZ[k*3+c]
+			}
+		}
+	}
+	return newColorConverter(curves, &matrix), nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// iccCurve returns the transfer curve of a 'curv' or 'para' tag
+func iccCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, FormatError(fmt.Sprintf("curve of %d bytes", len(tag)))
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		count := binary.BigEndian.Uint32(tag[8:])
+		if uint64(count)*2 > uint64(len(tag)-12) {
+			return nil, FormatError(fmt.Sprintf("curve of %d entries in %d bytes", count, len(tag)))
+		}
+		switch count {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, count)
+		for x := range table {
+			table[x] = float64(binary.BigEndian.Uint16(tag[12+x*2:])) / 65535
+		}
+		return func(v float64) float64 {
+			pos := v * float64(len(table)-1)
+			x := int(pos)
+			if x >= len(table)-1 {
+				return table[len(table)-1]
+			}
+			return table[x] + (table[x+1]-table[x])*(pos-float64(x))
+		}, nil
+	case "para":
+		// Number of parameters of each function type
+		numParams := []int{1, 3, 4, 5, 7}
+		function := int(binary.BigEndian.Uint16(tag[8:]))
+		if function >= len(numParams) {
+			return nil, fmt.Errorf("%w: parametric curve type %d", ErrUnsupportedColorProfile, function)
+		}
+		if len(tag) < 12+numParams[function]*4 {
+			return nil, FormatError(fmt.Sprintf("parametric curve type %d in %d bytes", function, len(tag)))
+		}
+		// g, a, b, c, d, e, f with Y = (aX+b)^g+e for X >= d and Y = cX+f below
+		p := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for x := 0; x < numParams[function]; x += 1 {
+			p[x] = s15Fixed16(tag[12+x*4:])
+		}
+		switch function {
+		case 1, 2:
+			// Zero, or c for type 2, below -b/a
+			if p[1] != 0 {
+				p[4] = -p[2] / p[1]
+			}
+			p[3], p[5], p[6] = 0, p[3], p[3]
+		case 3:
+			p[5], p[6] = 0, 0
+		}
+		return func(v float64) float64 {
+			if v < p[4] {
+				return p[3]*v + p[6]
+			}
+			base := p[1]*v + p[2]
+			if base <= 0 {
+				return p[5]
+			}
+			return math.Pow(base, p[0]) + p[5]
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: curve type %q", ErrUnsupportedColorProfile, tag[:4])
+}
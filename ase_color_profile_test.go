@@ -0,0 +1,192 @@
+package asefile
+
+import (
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// D50 adapted primaries of sRGB and Display P3, as the columns of the matrix
+// from linear RGB to XYZ
+var (
+	srgbPrimaries = [3][3]float64{{0.4361, 0.2225, 0.0139}, {0.3851, 0.7169, 0.0971}, {0.1431, 0.0606, 0.7141}}
+	p3Primaries   = [3][3]float64{{0.5151, 0.2412, -0.0011}, {0.2919, 0.6922, 0.0419}, {0.1572, 0.0666, 0.7841}}
+)
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendS15Fixed16(b []byte, v float64) []byte {
+	return appendUint32(b, uint32(int32(math.Round(v*65536))))
+}
+
+// paraTag encodes a parametric curve of the given function type
+func paraTag(function int, params ...float64) []byte {
+	tag := append([]byte("para"), 0, 0, 0, 0, 0, byte(function), 0, 0)
+	for _, param := range params {
+		tag = appendS15Fixed16(tag, param)
+	}
+	return tag
+}
+
+// curvTag encodes a curve table sampling curve at count points
+func curvTag(count int, curve func(float64) float64) []byte {
+	tag := appendUint32(append([]byte("curv"), 0, 0, 0, 0), uint32(count))
+	for x := 0; x < count; x += 1 {
+		tag = appendUint16(tag, uint16(math.Round(curve(float64(x)/float64(count-1))*65535)))
+	}
+	return tag
+}
+
+// iccProfile builds an RGB matrix/TRC profile with the given primaries and
+// the same curve for the three channels
+func iccProfile(primaries [3][3]float64, trc []byte) []byte {
+	var tags [][2]interface{}
+	for c, channel := range []string{"r", "g", "b"} {
+		xyz := append([]byte("XYZ "), 0, 0, 0, 0)
+		for _, v := range primaries[c] {
+			xyz = appendS15Fixed16(xyz, v)
+		}
+		tags = append(tags, [2]interface{}{channel + "XYZ", xyz}, [2]interface{}{channel + "TRC", trc})
+	}
+	profile := make([]byte, 128)
+	copy(profile[16:], "RGB XYZ ")
+	profile = appendUint32(profile, uint32(len(tags)))
+	offset := len(profile) + len(tags)*12
+	for _, tag := range tags {
+		data := tag[1].([]byte)
+		profile = append(profile, tag[0].(string)...)
+		profile = appendUint32(profile, uint32(offset))
+		profile = appendUint32(profile, uint32(len(data)))
+		offset += len(data)
+	}
+	for _, tag := range tags {
+		profile = append(profile, tag[1].([]byte)...)
+	}
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+func srgbToLinearCurve(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func TestColorConverter(t *testing.T) {
+	srgbPara := paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	for _, test := range []struct {
+		name    string
+		profile AsepriteColorProfileChunk2007
+		in, out []color.NRGBA
+	}{
+		{"fixed gamma 1.0", AsepriteColorProfileChunk2007{Type: ColorProfileSRGB, Flags: ColorProfileFlagFixedGamma,
+			FixedGamma: FixedFromFloat64(1)},
+			[]color.NRGBA{{128, 0, 255, 7}}, []color.NRGBA{{188, 0, 255, 7}}},
+		{"para type 0", AsepriteColorProfileChunk2007{Type: ColorProfileICC,
+			ICCProfileDat: iccProfile(srgbPrimaries, paraTag(0, 2.2))},
+			[]color.NRGBA{{128, 128, 128, 255}}, []color.NRGBA{{129, 129, 129, 255}}},
+		// (2X-1)^1, zero below X = 0.5
+		{"para type 1", AsepriteColorProfileChunk2007{Type: ColorProfileICC,
+			ICCProfileDat: iccProfile(srgbPrimaries, paraTag(1, 1, 2, -1))},
+			[]color.NRGBA{{64, 191, 255, 255}}, []color.NRGBA{{0, 187, 255, 255}}},
+		// X+0.25, 0.25 at X = 0
+		{"para type 2", AsepriteColorProfileChunk2007{Type: ColorProfileICC,
+			ICCProfileDat: iccProfile(srgbPrimaries, paraTag(2, 1, 1, 0, 0.25))},
+			[]color.NRGBA{{0, 128, 0, 255}}, []color.NRGBA{{137, 225, 137, 255}}},
+		// The sRGB curve gives back the input
+		{"para type 3", AsepriteColorProfileChunk2007{Type: ColorProfileICC, ICCProfileDat: iccProfile(srgbPrimaries, srgbPara)},
+			[]color.NRGBA{{234, 51, 35, 255}, {10, 100, 200, 255}, {255, 255, 255, 255}},
+			[]color.NRGBA{{234, 51, 35, 255}, {10, 100, 200, 255}, {255, 255, 255, 255}}},
+		// 0.5X below X = 0.2, X^2.2+0.1 above
+		{"para type 4", AsepriteColorProfileChunk2007{Type: ColorProfileICC,
+			ICCProfileDat: iccProfile(srgbPrimaries, paraTag(4, 2.2, 1, 0, 0.5, 0.2, 0.1, 0))},
+			[]color.NRGBA{{25, 128, 25, 255}}, []color.NRGBA{{63, 153, 63, 255}}},
+		{"curv table", AsepriteColorProfileChunk2007{Type: ColorProfileICC,
+			ICCProfileDat: iccProfile(srgbPrimaries, curvTag(1024, srgbToLinearCurve))},
+			[]color.NRGBA{{234, 51, 35, 255}, {10, 100, 200, 255}},
+			[]color.NRGBA{{234, 51, 35, 255}, {10, 100, 200, 255}}},
+		// The Bradford adapted matrix maps the P3 primaries onto sRGB
+		{"P3 primaries", AsepriteColorProfileChunk2007{Type: ColorProfileICC, ICCProfileDat: iccProfile(p3Primaries, srgbPara)},
+			[]color.NRGBA{{234, 51, 35, 255}, {10, 100, 200, 255}, {128, 128, 128, 255}},
+			[]color.NRGBA{{255, 0, 0, 255}, {0, 102, 207, 255}, {128, 128, 128, 255}}},
+	} {
+		converter, err := test.profile.Converter()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if converter.Identity() {
+			t.Errorf("%s: identity converter", test.name)
+		}
+		for x, in := range test.in {
+			got, want := converter.Convert(in), test.out[x]
+			if absDiff(got.R, want.R) > 1 || absDiff(got.G, want.G) > 1 || absDiff(got.B, want.B) > 1 || got.A != want.A {
+				t.Errorf("%s: %v converted to %v, want %v", test.name, in, got, want)
+			}
+		}
+	}
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestColorConverterIdentity(t *testing.T) {
+	for _, profile := range []AsepriteColorProfileChunk2007{{Type: ColorProfileNone}, {Type: ColorProfileSRGB}} {
+		converter, err := profile.Converter()
+		if err != nil || !converter.Identity() {
+			t.Errorf("%v profile: identity %v, %v", profile.Type, converter != nil && converter.Identity(), err)
+		}
+	}
+	converter, err := (&AsepriteFile{}).ColorConverter()
+	if err != nil || !converter.Identity() {
+		t.Errorf("sprite without a profile: %v", err)
+	}
+	c := color.NRGBA{1, 2, 3, 4}
+	if got := converter.Convert(c); got != c {
+		t.Errorf("identity converted %v to %v", c, got)
+	}
+}
+
+func TestColorConverterErrors(t *testing.T) {
+	srgbPara := paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	gray := iccProfile(srgbPrimaries, srgbPara)
+	copy(gray[16:], "GRAY")
+	noTRC := iccProfile(srgbPrimaries, srgbPara)
+	copy(noTRC[132+12:], "xTRC")
+	for _, test := range []struct {
+		name        string
+		profile     AsepriteColorProfileChunk2007
+		unsupported bool
+	}{
+		{"zero gamma", AsepriteColorProfileChunk2007{Type: ColorProfileSRGB, Flags: ColorProfileFlagFixedGamma}, true},
+		{"unknown type", AsepriteColorProfileChunk2007{Type: 9}, true},
+		{"gray ICC profile", AsepriteColorProfileChunk2007{Type: ColorProfileICC, ICCProfileDat: gray}, true},
+		{"no TRC tags", AsepriteColorProfileChunk2007{Type: ColorProfileICC, ICCProfileDat: noTRC}, true},
+		{"para type 5", AsepriteColorProfileChunk2007{Type: ColorProfileICC,
+			ICCProfileDat: iccProfile(srgbPrimaries, paraTag(5, 1))}, true},
+		{"short para", AsepriteColorProfileChunk2007{Type: ColorProfileICC,
+			ICCProfileDat: iccProfile(srgbPrimaries, paraTag(4, 1, 1))}, false},
+		{"short profile", AsepriteColorProfileChunk2007{Type: ColorProfileICC, ICCProfileDat: gray[:100]}, false},
+	} {
+		_, err := test.profile.Converter()
+		var formatErr FormatError
+		if test.unsupported && !errors.Is(err, ErrUnsupportedColorProfile) {
+			t.Errorf("%s: got %v, want ErrUnsupportedColorProfile", test.name, err)
+		} else if !test.unsupported && !errors.As(err, &formatErr) {
+			t.Errorf("%s: got %v, want a FormatError", test.name, err)
+		}
+	}
+}
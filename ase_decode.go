@@ -0,0 +1,426 @@
+package asefile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// FormatError reports that the input is not a valid Aseprite file
+type FormatError string
+
+func (e FormatError) Error() string {
+	return "invalid aseprite file: " + string(e)
+}
+
+// truncated turns the io.EOF of a short read into io.ErrUnexpectedEOF, as the
+// file ended in the middle of a header or chunk
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ErrLimitExceeded is returned, wrapped with the details, when a sprite goes
+// over one of the limits set in DecodeOptions
+var ErrLimitExceeded = errors.New("decode limit exceeded")
+
+/**
+ * DecodeOptions control how AsepriteFile.DecodeWithOptions reads a sprite.
+ *
+ * The limits protect against hostile or corrupt files that would otherwise
+ * make the decoder allocate huge buffers or inflate zip bombs. A limit of zero
+ * means no limit, so the zero value decodes anything.
+ */
+type DecodeOptions struct {
+	// Largest canvas width and height, also applied to each cel
+	MaxWidth, MaxHeight int
+	MaxFrames           int
+	// Largest number of layer chunks in the whole sprite
+	MaxLayers int
+	// Largest chunk, including its 6 byte size and type prefix
+	MaxChunkSize int
+	// Largest total of pixel and tile data inflated from all cels
+	MaxDecompressedBytes int64
+	// Longest layer, tag, slice, color name or user data text
+	MaxStringLength int
+	// Layers decodes only the cels of the layers named here, by name or by
+	// a slash separated group path such as "Character/Portrait", with any
+	// slash or backslash inside a name escaped by a backslash as LayerPath
+	// does. A group selects every layer inside it. LayerIndices selects layers by index
+	// in the same way. With both empty the cels of every layer are decoded.
+	Layers       []string
+	LayerIndices []int
+	// Frames decodes only the cels of a range of frames, every frame when
+	// nil. Cels of the selected layers in earlier frames are kept without
+	// being inflated, as linked cels in the range may point at them.
+	//
+	// Cels that are filtered out are skipped by their chunk size without
+	// being read into memory. Layers, tags, palettes and slices are always
+	// decoded. The filters don't apply to OpenReaderAt.
+	Frames *FrameRange
+	// LazyCels keeps the zlib data of compressed image and tilemap cels and
+	// inflates it on the first call to AsepriteCelChunk2005.Pixels, so reading
+	// only the tags, slices or other metadata of a sprite stays cheap
+	LazyCels bool
+	// Concurrency above 1 reads the compressed cels of the sprite first and
+	// then inflates them on that many goroutines. The decoded sprite and its
+	// warnings are the same as with serial decoding, and in strict mode the
+	// error is that of the first damaged cel in file order. It has no effect
+	// together with LazyCels.
+	Concurrency int
+	// Progress, if set, is called after the header and after each chunk
+	// with the number of bytes decoded so far and the file size given in
+	// the header. It is not called by the readers of OpenReaderAt.
+	Progress func(done, total int)
+	// Recover decodes as much as possible of a damaged sprite instead of
+	// stopping at the first error. After a damaged frame header the file is
+	// scanned for the next frame magic number with a plausible frame size,
+	// damaged chunks are skipped by their size, and the frames salvaged are
+	// returned in AsepriteFile.Frames with what was lost described by
+	// AsepriteFile.RecoveryReport. The header itself must be intact, and
+	// limit errors and cancellation still stop decoding. Recover only applies
+	// to AsepriteFile decoding.
+	Recover bool
+	// Logger, if set, receives every warning as it is found
+	Logger Logger
+	// Strict returns the first warning as the error instead of carrying on
+	Strict bool
+}
+
+// decoder holds the options and running totals of one decoding pass
+type decoder struct {
+	opts DecodeOptions
+	// Context checked between frames and chunks, nil when decoding without one
+	ctx context.Context
+	// Bytes decoded so far and the file size, for Progress
+	done, total int
+	layers      int
+	// Decompressed bytes counted so far, shared with the lazy cels decoded
+	budget *inflateBudget
+	// Cels waiting to be inflated by the worker pool
+	queued []queuedCel
+	// Non-fatal issues found so far
+	warnings []Warning
+	// Scratch space for frame and chunk headers, and the data of the chunk
+	// being decoded, reused from one chunk to the next
+	scratch  [16]byte
+	chunkBuf bytes.Buffer
+	// Decompressor for cel data, reset for each cel
+	zreader io.ReadCloser
+	// Index of the frame being decoded and what to do with the current cel
+	frame int
+	cel   celAction
+	// Index in the frame of the cel read from the chunk before, to which a
+	// cel extra chunk applies, or -1
+	extraCel int
+	// What was lost, in recovery mode
+	report *RecoveryReport
+	// State of the layer and frame filters
+	groupPath          []string
+	layerPaths         [][]string
+	tags               []AsepriteTagsChunk2018Tag
+	filterResolved     bool
+	selectedLayers     map[int]bool
+	fromFrame, toFrame int
+}
+
+func limitError(what string, value, max int64) error {
+	return fmt.Errorf("%w: %s %d is over the limit of %d", ErrLimitExceeded, what, value, max)
+}
+
+func (dec *decoder) checkHeader(aseHeader *AsepriteHeader) error {
+	dec.total = int(aseHeader.FileSize)
+	dec.advance(128)
+	if err := dec.checkSize("canvas", int(aseHeader.WidthInPixels), int(aseHeader.HeightInPixels)); err != nil {
+		return err
+	}
+	if dec.opts.MaxFrames > 0 && int(aseHeader.Frames) > dec.opts.MaxFrames {
+		return limitError("frame count", int64(aseHeader.Frames), int64(dec.opts.MaxFrames))
+	}
+	return nil
+}
+
+// checkContext returns the error of the context once it is done
+func (dec *decoder) checkContext() error {
+	if dec.ctx == nil {
+		return nil
+	}
+	return dec.ctx.Err()
+}
+
+// advance counts n more bytes as decoded and reports the progress
+func (dec *decoder) advance(n int) {
+	dec.done += n
+	if dec.opts.Progress != nil {
+		total := dec.total
+		if dec.done > total {
+			total = dec.done
+		}
+		dec.opts.Progress(dec.done, total)
+	}
+}
+
+func (dec *decoder) checkSize(what string, width, height int) error {
+	if dec.opts.MaxWidth > 0 && width > dec.opts.MaxWidth {
+		return limitError(what+" width", int64(width), int64(dec.opts.MaxWidth))
+	}
+	if dec.opts.MaxHeight > 0 && height > dec.opts.MaxHeight {
+		return limitError(what+" height", int64(height), int64(dec.opts.MaxHeight))
+	}
+	return nil
+}
+
+func (dec *decoder) addLayer(layer *AsepriteLayerChunk2004) error {
+	dec.trackLayer(layer)
+	dec.layers += 1
+	if dec.opts.MaxLayers > 0 && dec.layers > dec.opts.MaxLayers {
+		return limitError("layer count", int64(dec.layers), int64(dec.opts.MaxLayers))
+	}
+	return nil
+}
+
+func (dec *decoder) checkString(str string) error {
+	if dec.opts.MaxStringLength > 0 && len(str) > dec.opts.MaxStringLength {
+		return limitError("string length", int64(len(str)), int64(dec.opts.MaxStringLength))
+	}
+	return nil
+}
+
+// checkChunkSize checks the size of a chunk, including its size and type
+func (dec *decoder) checkChunkSize(chunkSize uint32) error {
+	if chunkSize < 6 {
+		return FormatError(fmt.Sprintf("chunk size %d is too small", chunkSize))
+	}
+	if dec.opts.MaxChunkSize > 0 && int64(chunkSize) > int64(dec.opts.MaxChunkSize) {
+		return limitError("chunk size", int64(chunkSize), int64(dec.opts.MaxChunkSize))
+	}
+	return nil
+}
+
+// readChunk reads the data of a chunk of chunkSize bytes, whose size and
+// type have already been read, into the chunk buffer after any bytes of it
+// already peeked there. The buffer only grows as data arrives, so a corrupt
+// size cannot allocate more than the input holds. The data is only valid
+// until the buffer is reset for the next chunk.
+func (dec *decoder) readChunk(r io.Reader, chunkSize uint32) ([]byte, error) {
+	if err := dec.checkChunkSize(chunkSize); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(&dec.chunkBuf, r, int64(chunkSize)-6-int64(dec.chunkBuf.Len())); err != nil {
+		return nil, truncated(err)
+	}
+	return dec.chunkBuf.Bytes(), nil
+}
+
+// inflateBudget counts decompressed bytes against a limit. Lazy cels keep the
+// budget of the decode that read them and may be inflated on several
+// goroutines at once, so it is guarded by a mutex.
+type inflateBudget struct {
+	mu   sync.Mutex
+	used int64
+	max  int64
+}
+
+// reserve counts size bytes against the limit of the budget
+func (budget *inflateBudget) reserve(size int64) error {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.used += size
+	if budget.max > 0 && budget.used > budget.max {
+		return limitError("decompressed size", budget.used, budget.max)
+	}
+	return nil
+}
+
+// inflateBudget returns the budget of the decoder, created on first use
+func (dec *decoder) inflateBudget() *inflateBudget {
+	if dec.budget == nil {
+		dec.budget = &inflateBudget{max: dec.opts.MaxDecompressedBytes}
+	}
+	return dec.budget
+}
+
+// reserve counts size bytes against the decompressed bytes limit
+func (dec *decoder) reserve(size int64) error {
+	return dec.inflateBudget().reserve(size)
+}
+
+// inflate decompresses the zlib stream in r into at most size bytes, counting
+// them against the decompressed bytes limit before inflating anything. A
+// corrupt stream is a warning and whatever inflated before the damage is kept.
+func (dec *decoder) inflate(r io.Reader, size int64) ([]byte, error) {
+	if err := dec.reserve(size); err != nil {
+		return nil, err
+	}
+	var err error
+	if dec.zreader == nil {
+		dec.zreader, err = zlib.NewReader(r)
+	} else {
+		err = dec.zreader.(zlib.Resetter).Reset(r, nil)
+	}
+	if err != nil {
+		dec.zreader = nil
+		return nil, dec.warn(WarningZlib, ChunkCel, "cel data: %v", err)
+	}
+	data, err := inflateFrom(dec.zreader, size)
+	if err != nil {
+		return data, dec.warn(WarningZlib, ChunkCel, "cel data: %v", err)
+	}
+	return data, nil
+}
+
+// inflateData decompresses the zlib stream in r into at most size bytes,
+// returning what inflated before any error in the stream
+func inflateData(r io.Reader, size int64) ([]byte, error) {
+	zreader, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zreader.Close()
+	return inflateFrom(zreader, size)
+}
+
+// inflateFrom reads at most size bytes from zreader. The buffer grows as
+// data inflates, as size comes from the untrusted cel dimensions.
+func inflateFrom(zreader io.Reader, size int64) ([]byte, error) {
+	var byteBuff bytes.Buffer
+	_, err := io.Copy(&byteBuff, io.LimitReader(zreader, size))
+	return byteBuff.Bytes(), err
+}
+
+// concurrent reports whether cels are inflated on the worker pool
+func (dec *decoder) concurrent() bool {
+	return dec.opts.Concurrency > 1 && !dec.opts.LazyCels
+}
+
+// lazy reports whether the cel being decoded keeps its compressed data
+func (dec *decoder) lazy() bool {
+	return dec.opts.LazyCels || dec.concurrent() || dec.cel == celKeep
+}
+
+// queue adds a cel with compressed data to those inflated by inflateQueued,
+// counting it against the decompressed bytes limit in file order
+func (dec *decoder) queue(aseCelChunk *AsepriteCelChunk2005) error {
+	if aseCelChunk.compressed == nil {
+		return nil
+	}
+	if err := dec.reserve(aseCelChunk.inflateSize); err != nil {
+		return err
+	}
+	aseCelChunk.budgeted = true
+	dec.queued = append(dec.queued, queuedCel{aseCelChunk, dec.frame})
+	return nil
+}
+
+type queuedCel struct {
+	cel   *AsepriteCelChunk2005
+	frame int
+}
+
+// inflateQueued inflates the queued cels on Concurrency goroutines, then
+// records a warning for each cel that failed in queue order
+func (dec *decoder) inflateQueued() error {
+	queued := dec.queued
+	dec.queued = nil
+	errs := make([]error, len(queued))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for x := 0; x < dec.opts.Concurrency && x < len(queued); x += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range next {
+				cel := queued[y].cel
+				data, err := cel.inflateCompressed()
+				if cel.CelType == CelTypeCompressedTilemap {
+					cel.Tiles = data
+				} else {
+					cel.RawCelData = data
+				}
+				cel.compressed = nil
+				errs[y] = err
+			}
+		}()
+	}
+	for y := range queued {
+		next <- y
+	}
+	close(next)
+	wg.Wait()
+	for y, err := range errs {
+		if err != nil {
+			warning := Warning{WarningZlib, queued[y].frame, ChunkCel, fmt.Sprintf("cel data: %v", err)}
+			if err := dec.addWarning(warning); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkCount returns an error when count entries of at least minSize bytes
+// each cannot fit in what is left of fr, so that a corrupt count cannot
+// trigger a huge allocation
+func checkCount(fr *fieldReader, count uint64, minSize uint64) error {
+	if count*minSize > uint64(fr.Len()) {
+		return FormatError(fmt.Sprintf("%d entries do not fit in the remaining %d bytes of the chunk", count, fr.Len()))
+	}
+	return nil
+}
+
+// bytesPerPixel returns the size of a pixel in the color depth of the header
+func bytesPerPixel(aseHeader *AsepriteHeader) int {
+	if aseHeader == nil {
+		return 4
+	}
+	switch aseHeader.ColorDepth {
+	case ColorModeRGBA:
+		return 4
+	case ColorModeGrayscale:
+		return 2
+	}
+	return 1
+}
+
+// DecodeWithOptions reads a sprite from r, enforcing the limits in opts
+func (aseFile *AsepriteFile) DecodeWithOptions(r io.Reader, opts DecodeOptions) error {
+	return aseFile.DecodeContext(context.Background(), r, opts)
+}
+
+// DecodeContext reads a sprite from r like DecodeWithOptions, and stops with
+// the error of ctx once it is done, checking between frames and chunks
+func (aseFile *AsepriteFile) DecodeContext(ctx context.Context, r io.Reader, opts DecodeOptions) error {
+	dec := &decoder{opts: opts, ctx: ctx}
+	defer func() {
+		aseFile.warnings = dec.warnings
+	}()
+	if err := aseFile.Header.Decode(r); err != nil {
+		return err
+	}
+	if err := dec.checkHeader(&aseFile.Header); err != nil {
+		return err
+	}
+	aseFile.recovery = nil
+	if opts.Recover {
+		return aseFile.decodeRecover(dec, r)
+	}
+	aseFile.Frames = make([]AsepriteFrame, aseFile.Header.Frames)
+	for x := range aseFile.Frames {
+		if err := dec.checkContext(); err != nil {
+			return err
+		}
+		aseFile.Frames[x].parentHeader = &aseFile.Header
+		dec.frame = x
+		err := aseFile.Frames[x].decode(dec, r)
+		if err != nil {
+			return err
+		}
+	}
+	return dec.inflateQueued()
+}
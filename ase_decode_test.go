@@ -0,0 +1,251 @@
+package asefile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// edgeCaseSprite builds a sprite whose first frame holds a single chunk
+func edgeCaseSprite(chunkSize uint32, chunkType uint16, chunkDat []byte) []byte {
+	var sprite bytes.Buffer
+	header := AsepriteHeader{MagicNumber: 0xA5E0, Frames: 1, WidthInPixels: 8, HeightInPixels: 8, ColorDepth: 32}
+	header.Encode(&sprite)
+	frame := []interface{}{uint32(16 + 6 + len(chunkDat)), uint16(0xF1FA), uint16(1), uint16(100), [2]byte{}, uint32(1)}
+	for _, field := range frame {
+		binary.Write(&sprite, ble, field)
+	}
+	binary.Write(&sprite, ble, chunkSize)
+	binary.Write(&sprite, ble, chunkType)
+	sprite.Write(chunkDat)
+	return sprite.Bytes()
+}
+
+// insertChunk returns a copy of an encoded sprite with a chunk appended to
+// the given frame, the frame and file sizes and chunk counts updated
+func insertChunk(sprite []byte, frame int, chunkType ChunkType, chunkDat []byte) []byte {
+	chunk := make([]byte, 6, 6+len(chunkDat))
+	ble.PutUint32(chunk, uint32(6+len(chunkDat)))
+	ble.PutUint16(chunk[4:], uint16(chunkType))
+	chunk = append(chunk, chunkDat...)
+	offset := 128
+	for x := 0; x < frame; x += 1 {
+		offset += int(ble.Uint32(sprite[offset:]))
+	}
+	frameEnd := offset + int(ble.Uint32(sprite[offset:]))
+	result := append(append(append([]byte(nil), sprite[:frameEnd]...), chunk...), sprite[frameEnd:]...)
+	ble.PutUint32(result, ble.Uint32(result)+uint32(len(chunk)))
+	ble.PutUint32(result[offset:], ble.Uint32(result[offset:])+uint32(len(chunk)))
+	numChunks := ble.Uint32(result[offset+12:])
+	if numChunks == 0 {
+		numChunks = uint32(ble.Uint16(result[offset+6:]))
+	}
+	ble.PutUint16(result[offset+6:], uint16(numChunks+1))
+	ble.PutUint32(result[offset+12:], numChunks+1)
+	return result
+}
+
+// damageCels returns a copy of an encoded sprite with the end of the zlib
+// data of the first cel in each of the given frames flipped
+func damageCels(t *testing.T, sprite []byte, frames ...int) []byte {
+	t.Helper()
+	index, err := NewFileIndex(bytes.NewReader(sprite), int64(len(sprite)), DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := append([]byte(nil), sprite...)
+	for _, frame := range frames {
+		for _, chunk := range index.Frames[frame].Chunks {
+			if chunk.Type != ChunkCel {
+				continue
+			}
+			for x := chunk.Offset + int64(chunk.Size) - 8; x < chunk.Offset+int64(chunk.Size); x += 1 {
+				damaged[x] ^= 0xFF
+			}
+			break
+		}
+	}
+	return damaged
+}
+
+func TestDecodeConcurrency(t *testing.T) {
+	chica := readChica(t)
+	damaged := damageCels(t, chica, 6, 3)
+	serial := decodeChica(t, DecodeOptions{})
+	var damagedSerial AsepriteFile
+	if err := damagedSerial.DecodeWithOptions(bytes.NewReader(damaged), DecodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(damagedSerial.Warnings()) != 2 {
+		t.Fatalf("damaged sprite gave warnings %v, want 2", damagedSerial.Warnings())
+	}
+	for _, concurrency := range []int{2, 3, 64} {
+		opts := DecodeOptions{Concurrency: concurrency}
+		if diff := Diff(serial, decodeChica(t, opts), EqualOptions{}); diff != "" {
+			t.Errorf("concurrency %d: sprite differs from serial decoding:\n%s", concurrency, diff)
+		}
+
+		// Warnings come in file order whichever cel fails first
+		var aseFile AsepriteFile
+		if err := aseFile.DecodeWithOptions(bytes.NewReader(damaged), opts); err != nil {
+			t.Fatalf("concurrency %d: %v", concurrency, err)
+		}
+		if diff := Diff(&damagedSerial, &aseFile, EqualOptions{}); diff != "" {
+			t.Errorf("concurrency %d: damaged sprite differs from serial decoding:\n%s", concurrency, diff)
+		}
+		warnings := aseFile.Warnings()
+		if len(warnings) != 2 || warnings[0] != damagedSerial.Warnings()[0] || warnings[1] != damagedSerial.Warnings()[1] {
+			t.Errorf("concurrency %d: warnings %v, want %v", concurrency, warnings, damagedSerial.Warnings())
+		}
+
+		// Queued cels count against the decompressed limit as they are read
+		limited := DecodeOptions{Concurrency: concurrency, MaxDecompressedBytes: 22579}
+		if err := aseFile.DecodeWithOptions(bytes.NewReader(chica), limited); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("concurrency %d: decompressed limit gave %v, want ErrLimitExceeded", concurrency, err)
+		}
+
+		// Strict mode fails with the first damaged cel in the file
+		opts.Strict = true
+		err := aseFile.DecodeWithOptions(bytes.NewReader(damaged), opts)
+		var warning Warning
+		if !errors.As(err, &warning) || warning.Kind != WarningZlib || warning.Frame != 3 {
+			t.Errorf("concurrency %d: strict decode gave %v, want a zlib warning in frame 3", concurrency, err)
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	chica := readChica(t)
+	// Chica is 32x32 with 13 frames and 2 layers, its largest chunk is 389
+	// bytes, its longest name 9 bytes and its cels inflate to 22580 bytes
+	for _, test := range []struct {
+		name string
+		opts DecodeOptions
+		want string
+	}{
+		{"width", DecodeOptions{MaxWidth: 31}, "canvas width 32"},
+		{"height", DecodeOptions{MaxHeight: 16}, "canvas height 32"},
+		{"frames", DecodeOptions{MaxFrames: 12}, "frame count 13"},
+		{"layers", DecodeOptions{MaxLayers: 1}, "layer count 2"},
+		{"chunk size", DecodeOptions{MaxChunkSize: 388}, "chunk size 389"},
+		{"decompressed", DecodeOptions{MaxDecompressedBytes: 22579}, "decompressed size"},
+		{"string length", DecodeOptions{MaxStringLength: 8}, "string length 9"},
+	} {
+		var aseFile AsepriteFile
+		err := aseFile.DecodeWithOptions(bytes.NewReader(chica), test.opts)
+		if !errors.Is(err, ErrLimitExceeded) || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want ErrLimitExceeded for %q", test.name, err, test.want)
+		}
+	}
+
+	atLimits := DecodeOptions{MaxWidth: 32, MaxHeight: 32, MaxFrames: 13, MaxLayers: 2, MaxChunkSize: 389,
+		MaxDecompressedBytes: 22580, MaxStringLength: 9}
+	var aseFile AsepriteFile
+	if err := aseFile.DecodeWithOptions(bytes.NewReader(chica), atLimits); err != nil {
+		t.Errorf("decoding at the limits: %v", err)
+	}
+}
+
+func TestDecodeLimitsLazyCels(t *testing.T) {
+	// Lazy cels count against the decompressed limit when they are inflated
+	aseFile := decodeChica(t, DecodeOptions{LazyCels: true, MaxDecompressedBytes: 5000})
+	var err error
+	for x := 0; x < len(aseFile.Frames) && err == nil; x += 1 {
+		for y := range aseFile.Frames[x].Cels {
+			if _, err = aseFile.Frames[x].Cels[y].Pixels(); err != nil {
+				break
+			}
+		}
+	}
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("inflating every lazy cel gave %v, want ErrLimitExceeded", err)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	chica := readChica(t)
+	badMagic := append([]byte(nil), chica...)
+	badMagic[4] = 0
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", chica[:100]},
+		{"bad magic number", badMagic},
+		{"truncated frame", chica[:len(chica)/2]},
+		{"chunk too small", edgeCaseSprite(2, 0x2004, nil)},
+		{"chunk past frame", edgeCaseSprite(0xFFFFFFFF, 0x2005, nil)},
+	} {
+		var aseFile AsepriteFile
+		if err := aseFile.Decode(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: decoded without error", test.name)
+		}
+	}
+}
+
+func TestDecodeContext(t *testing.T) {
+	chica := readChica(t)
+	var done []int
+	opts := DecodeOptions{Progress: func(n, total int) {
+		if total != len(chica) {
+			t.Errorf("progress total %d, want the file size %d", total, len(chica))
+		}
+		done = append(done, n)
+	}}
+	var aseFile AsepriteFile
+	if err := aseFile.DecodeContext(context.Background(), bytes.NewReader(chica), opts); err != nil {
+		t.Fatal(err)
+	}
+	for x := 1; x < len(done); x += 1 {
+		if done[x] <= done[x-1] {
+			t.Fatalf("progress went from %d to %d", done[x-1], done[x])
+		}
+	}
+	if len(done) == 0 || done[0] != 128 || done[len(done)-1] != len(chica) {
+		t.Errorf("progress %v, want 128 up to %d", done, len(chica))
+	}
+
+	for _, test := range []struct {
+		name     string
+		cancelAt int
+		opts     DecodeOptions
+	}{
+		{"before decoding", 0, DecodeOptions{}},
+		{"after the header", 128, DecodeOptions{}},
+		{"mid frame", len(chica) / 2, DecodeOptions{}},
+		{"recovering", len(chica) / 2, DecodeOptions{Recover: true}},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if test.cancelAt == 0 {
+			cancel()
+		}
+		calls := 0
+		test.opts.Progress = func(n, total int) {
+			if ctx.Err() != nil {
+				calls += 1
+			}
+			if n >= test.cancelAt {
+				cancel()
+			}
+		}
+		err := aseFile.DecodeContext(ctx, bytes.NewReader(chica), test.opts)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want context.Canceled", test.name, err)
+		}
+		// Decoding stops at the next chunk once cancelled
+		if calls > 1 {
+			t.Errorf("%s: progress reported %d times after cancelling", test.name, calls)
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), -1)
+	defer cancel()
+	if err := aseFile.DecodeContext(ctx, bytes.NewReader(chica), DecodeOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expired deadline gave %v, want context.DeadlineExceeded", err)
+	}
+}
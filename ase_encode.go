@@ -0,0 +1,304 @@
+package asefile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+)
+
+// CompressionLevel is the zlib compression level used for cel data. Levels 1
+// (fastest) to 9 (smallest), zlib.DefaultCompression and zlib.HuffmanOnly
+// mean what they do in compress/zlib. Zero is the default level rather than
+// zlib.NoCompression, so that the zero EncodeOptions compresses; use
+// CompressionNone for zlib streams of stored blocks.
+type CompressionLevel int
+
+const (
+	CompressionDefault   CompressionLevel = 0
+	CompressionBestSpeed CompressionLevel = zlib.BestSpeed
+	CompressionBest      CompressionLevel = zlib.BestCompression
+	CompressionNone      CompressionLevel = -3
+)
+
+func (level CompressionLevel) zlibLevel() (int, error) {
+	switch {
+	case level == CompressionDefault:
+		return zlib.DefaultCompression, nil
+	case level == CompressionNone:
+		return zlib.NoCompression, nil
+	case level == zlib.DefaultCompression || level == zlib.HuffmanOnly:
+		return int(level), nil
+	case level >= 1 && level <= 9:
+		return int(level), nil
+	}
+	return 0, fmt.Errorf("invalid compression level %d", level)
+}
+
+/**
+ * EncodeOptions control how AsepriteFile.EncodeWithOptions writes a sprite.
+ * The zero value writes every image cel zlib compressed at the default level.
+ */
+type EncodeOptions struct {
+	CompressionLevel CompressionLevel
+	// RawCels writes image cels uncompressed (cel type 0) instead of
+	// compressed (cel type 2)
+	RawCels bool
+	// LinkIdenticalCels writes a cel that has the same position, opacity and
+	// pixels as a cel on the same layer in an earlier frame as a linked cel
+	// (cel type 1) pointing at that frame
+	LinkIdenticalCels bool
+	// Deterministic writes zeroes in every reserved field and writes the cels
+	// of each frame sorted by layer index, so the same logical sprite always
+	// encodes to the same bytes. The other chunks are always written in a
+	// fixed order by type.
+	Deterministic bool
+	// Progress, if set, is called after each frame with the number of frames
+	// encoded so far and the number of frames in the sprite
+	Progress func(done, total int)
+}
+
+// encoder holds the state shared by the frames of one encoding pass
+type encoder struct {
+	opts EncodeOptions
+	// Context checked between frames and chunks, nil when encoding without one
+	ctx context.Context
+	// First error of the context or of a cel, which stops the writing of chunks
+	err error
+	// Compressor for cel data, reused across cels
+	zwriter *zlib.Writer
+	// Index of the frame being encoded
+	frame int
+	// Cels written so far that later identical cels can link to
+	linkable map[celLinkKey][]celLink
+	// countOnly counts the chunks of frames without encoding them
+	countOnly bool
+}
+
+type celLinkKey struct {
+	layer         uint16
+	x, y          int16
+	opacity       byte
+	width, height uint16
+	sum           uint64
+}
+
+type celLink struct {
+	frame  int
+	pixels []byte
+}
+
+func newEncoder(opts EncodeOptions) (*encoder, error) {
+	level, err := opts.CompressionLevel.zlibLevel()
+	if err != nil {
+		return nil, err
+	}
+	zwriter, err := zlib.NewWriterLevel(nil, level)
+	if err != nil {
+		return nil, err
+	}
+	return &encoder{
+		opts:     opts,
+		zwriter:  zwriter,
+		linkable: make(map[celLinkKey][]celLink),
+	}, nil
+}
+
+func newDefaultEncoder() *encoder {
+	enc, _ := newEncoder(EncodeOptions{})
+	return enc
+}
+
+// checkContext records the error of the context once it is done
+func (enc *encoder) checkContext() error {
+	if enc.err == nil && enc.ctx != nil {
+		enc.err = enc.ctx.Err()
+	}
+	return enc.err
+}
+
+// fillCounts sets the magic numbers and the frame and chunk counts of the
+// header and frames, as Encode does, without compressing any cel. The file
+// and frame sizes depend on the compressed cels and are left to Encode.
+func (aseFile *AsepriteFile) fillCounts() {
+	enc := &encoder{countOnly: true}
+	aseFile.Header.MagicNumber = 0xA5E0
+	aseFile.Header.Frames = uint16(len(aseFile.Frames))
+	for x := range aseFile.Frames {
+		aseFrame := &aseFile.Frames[x]
+		aseFrame.parentHeader = &aseFile.Header
+		aseFrame.setChunkCounts(aseFrame.encodeChunks(io.Discard, enc))
+	}
+}
+
+// celOrder returns the order in which the cels of a frame are written
+func (enc *encoder) celOrder(cels []AsepriteCelChunk2005) []int {
+	order := make([]int, len(cels))
+	for x := range order {
+		order[x] = x
+	}
+	if enc.opts.Deterministic {
+		sort.SliceStable(order, func(i, j int) bool {
+			return cels[order[i]].LayerIndex < cels[order[j]].LayerIndex
+		})
+	}
+	return order
+}
+
+// cel returns the cel as it should be written: image cels are converted to
+// the raw or compressed cel type, or to a linked cel when an identical one
+// was already written. The pixels or tiles of lazily decoded cels are
+// inflated here, so damaged zlib data fails the encode instead of being
+// written as transparent pixels.
+func (enc *encoder) cel(cel AsepriteCelChunk2005, pixelSize int) (AsepriteCelChunk2005, error) {
+	if enc.opts.Deterministic {
+		cel.future = [7]byte{}
+		cel.reserved = [10]byte{}
+	}
+	switch cel.CelType {
+	case CelTypeRaw, CelTypeCompressedImage:
+	case CelTypeCompressedTilemap:
+		tiles, err := cel.Pixels()
+		if err != nil {
+			return cel, fmt.Errorf("frame %d, cel on layer %d: %w", enc.frame, cel.LayerIndex, err)
+		}
+		cel.Tiles, cel.compressed = tiles, nil
+		return cel, nil
+	default:
+		return cel, nil
+	}
+	pixels, err := cel.Pixels()
+	if err != nil {
+		return cel, fmt.Errorf("frame %d, cel on layer %d: %w", enc.frame, cel.LayerIndex, err)
+	}
+	cel.compressed = nil
+	pixels = fitPixels(pixels, int(cel.WidthInPix)*int(cel.HeightInPix)*pixelSize)
+	if enc.opts.LinkIdenticalCels && cel.Extra == nil {
+		sum := fnv.New64a()
+		sum.Write(pixels)
+		key := celLinkKey{
+			layer:   cel.LayerIndex,
+			x:       cel.X,
+			y:       cel.Y,
+			opacity: cel.OpacityLevel,
+			width:   cel.WidthInPix,
+			height:  cel.HeightInPix,
+			sum:     sum.Sum64(),
+		}
+		for _, link := range enc.linkable[key] {
+			if link.frame != enc.frame && bytes.Equal(link.pixels, pixels) {
+				linked := cel
+				linked.CelType = CelTypeLinked
+				linked.FramePosToLinkWith = uint16(link.frame)
+				linked.WidthInPix, linked.HeightInPix = 0, 0
+				linked.RawPixData, linked.RawCelData = nil, nil
+				return linked, nil
+			}
+		}
+		enc.linkable[key] = append(enc.linkable[key], celLink{enc.frame, pixels})
+	}
+	if enc.opts.RawCels {
+		cel.CelType = CelTypeRaw
+		cel.RawPixData, cel.RawCelData = pixels, nil
+	} else {
+		cel.CelType = CelTypeCompressedImage
+		cel.RawPixData, cel.RawCelData = nil, pixels
+	}
+	return cel, nil
+}
+
+// fitPixels pads pixel data that is short of size bytes with transparent
+// pixels, or cuts it to size, as a damaged cel may hold fewer pixels than
+// its width and height call for
+func fitPixels(pixels []byte, size int) []byte {
+	if len(pixels) == size {
+		return pixels
+	}
+	if len(pixels) > size {
+		return pixels[:size]
+	}
+	fitted := make([]byte, size)
+	copy(fitted, pixels)
+	return fitted
+}
+
+// encoderCel encodes a cel chunk with the compressor of the encoder
+type encoderCel struct {
+	cel     *AsepriteCelChunk2005
+	zwriter *zlib.Writer
+}
+
+func (celCodec encoderCel) Encode(w io.Writer) {
+	celCodec.cel.encode(w, celCodec.zwriter)
+}
+
+// errWriter remembers the first write error so a sequence of writes can be
+// checked once at the end
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(p)
+	ew.err = err
+	return n, err
+}
+
+// EncodeWithOptions writes the sprite to w, filling in the magic numbers,
+// chunk counts and sizes of the header and frames as it goes
+func (aseFile *AsepriteFile) EncodeWithOptions(w io.Writer, opts EncodeOptions) error {
+	return aseFile.EncodeContext(context.Background(), w, opts)
+}
+
+// EncodeContext writes the sprite to w like EncodeWithOptions, and stops
+// with the error of ctx once it is done, checking between frames and chunks.
+// Nothing is written to w when encoding is stopped.
+func (aseFile *AsepriteFile) EncodeContext(ctx context.Context, w io.Writer, opts EncodeOptions) error {
+	enc, err := newEncoder(opts)
+	if err != nil {
+		return err
+	}
+	enc.ctx = ctx
+	if len(aseFile.Frames) > 0xFFFF {
+		return fmt.Errorf("sprite has %d frames, at most 65535 are allowed", len(aseFile.Frames))
+	}
+	var frames bytes.Buffer
+	for x := range aseFile.Frames {
+		if err := enc.checkContext(); err != nil {
+			return err
+		}
+		aseFile.Frames[x].parentHeader = &aseFile.Header
+		enc.frame = x
+		aseFile.Frames[x].encode(&frames, enc)
+		if enc.err != nil {
+			return enc.err
+		}
+		if opts.Progress != nil {
+			opts.Progress(x+1, len(aseFile.Frames))
+		}
+	}
+	if int64(frames.Len())+128 > 0xFFFFFFFF {
+		return fmt.Errorf("encoded sprite exceeds 4 GiB")
+	}
+	aseFile.Header.MagicNumber = 0xA5E0
+	aseFile.Header.Frames = uint16(len(aseFile.Frames))
+	aseFile.Header.FileSize = uint32(128 + frames.Len())
+
+	header := aseFile.Header
+	if opts.Deterministic {
+		header.ignore1, header.ignore2 = 0, 0
+		header.ignore3 = [3]byte{}
+		header.reserved = [84]byte{}
+	}
+	ew := &errWriter{w: w}
+	header.Encode(ew)
+	ew.Write(frames.Bytes())
+	return ew.err
+}
@@ -0,0 +1,237 @@
+package asefile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func decodeChica(t *testing.T, opts DecodeOptions) *AsepriteFile {
+	t.Helper()
+	var aseFile AsepriteFile
+	if err := aseFile.DecodeWithOptions(bytes.NewReader(readChica(t)), opts); err != nil {
+		t.Fatal(err)
+	}
+	return &aseFile
+}
+
+func TestEncodeCompressionLevel(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	sizes := make(map[CompressionLevel]int)
+	for _, level := range []CompressionLevel{CompressionDefault, CompressionNone, CompressionBestSpeed,
+		CompressionBest, zlib.DefaultCompression, zlib.HuffmanOnly, 5} {
+		var buf bytes.Buffer
+		if err := aseFile.EncodeWithOptions(&buf, EncodeOptions{CompressionLevel: level}); err != nil {
+			t.Errorf("level %d: %v", level, err)
+			continue
+		}
+		sizes[level] = buf.Len()
+		var decoded AsepriteFile
+		if err := decoded.Decode(&buf); err != nil {
+			t.Errorf("level %d: %v", level, err)
+		} else if diff := Diff(aseFile, &decoded, EqualOptions{}); diff != "" {
+			t.Errorf("level %d changed the sprite:\n%s", level, diff)
+		}
+	}
+	if sizes[zlib.DefaultCompression] != sizes[CompressionDefault] || sizes[CompressionNone] <= sizes[CompressionDefault] {
+		t.Errorf("default level gave %d bytes, zlib.DefaultCompression %d and no compression %d",
+			sizes[CompressionDefault], sizes[zlib.DefaultCompression], sizes[CompressionNone])
+	}
+	for _, level := range []CompressionLevel{10, -4} {
+		if err := aseFile.EncodeWithOptions(&bytes.Buffer{}, EncodeOptions{CompressionLevel: level}); err == nil {
+			t.Errorf("level %d: no error", level)
+		}
+	}
+}
+
+func TestEncodeRawCels(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	decoded := encodeDecode(t, aseFile, EncodeOptions{RawCels: true})
+	for x, aseFrame := range decoded.Frames {
+		for _, cel := range aseFrame.Cels {
+			if cel.CelType != CelTypeRaw {
+				t.Fatalf("frame %d: cel on layer %d is a %v cel", x, cel.LayerIndex, cel.CelType)
+			}
+		}
+	}
+	if diff := Diff(aseFile, decoded, EqualOptions{IgnoreCompression: true}); diff != "" {
+		t.Errorf("raw cels changed the sprite:\n%s", diff)
+	}
+}
+
+func TestEncodeLinkIdenticalCels(t *testing.T) {
+	red := solidImage(2, 2, color.NRGBA{255, 0, 0, 255})
+	builder := NewSprite(4, 4, ColorModeRGBA)
+	layer := builder.AddLayer("Layer", -1)
+	for x := 0; x < 4; x += 1 {
+		builder.AddFrame(100)
+	}
+	builder.SetCel(0, layer, red, image.Pt(1, 1))
+	builder.SetCel(1, layer, red, image.Pt(1, 1))
+	// Moved, so it can not link to frame 0
+	builder.SetCel(2, layer, red, image.Pt(0, 0))
+	builder.SetCel(3, layer, red, image.Pt(0, 0))
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := encodeDecode(t, aseFile, EncodeOptions{LinkIdenticalCels: true})
+	want := []struct {
+		celType CelType
+		link    uint16
+	}{{CelTypeCompressedImage, 0}, {CelTypeLinked, 0}, {CelTypeCompressedImage, 0}, {CelTypeLinked, 2}}
+	for x, aseFrame := range decoded.Frames {
+		cel := aseFrame.Cels[0]
+		if cel.CelType != want[x].celType || cel.FramePosToLinkWith != want[x].link {
+			t.Errorf("frame %d: %v cel linking to %d, want %v linking to %d",
+				x, cel.CelType, cel.FramePosToLinkWith, want[x].celType, want[x].link)
+		}
+	}
+	if diff := Diff(aseFile, decoded, EqualOptions{IgnoreCompression: true}); diff != "" {
+		t.Errorf("linking cels changed the sprite:\n%s", diff)
+	}
+	if !Equal(aseFile, decoded, EqualOptions{IgnoreCompression: true}) || Equal(aseFile, decoded, EqualOptions{}) {
+		t.Errorf("linked cels should only be equal when ignoring compression")
+	}
+}
+
+func TestEncodeDeterministic(t *testing.T) {
+	build := func() *AsepriteFile {
+		builder := NewSprite(4, 4, ColorModeRGBA)
+		bottom := builder.AddLayer("Bottom", -1)
+		top := builder.AddLayer("Top", -1)
+		frame := builder.AddFrame(100)
+		builder.SetCel(frame, bottom, solidImage(1, 1, color.NRGBA{255, 0, 0, 255}), image.Pt(0, 0))
+		builder.SetCel(frame, top, solidImage(1, 1, color.NRGBA{0, 255, 0, 255}), image.Pt(1, 1))
+		aseFile, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return aseFile
+	}
+	clean := build()
+	// The same sprite with junk in reserved fields and its cels out of order
+	messy := build()
+	messy.Header.reserved[3] = 7
+	messy.Frames[0].reserved = [2]byte{1, 2}
+	messy.Frames[0].Layers[0].forFuture = [3]byte{4}
+	cels := messy.Frames[0].Cels
+	cels[0], cels[1] = cels[1], cels[0]
+	cels[0].future[0] = 9
+
+	encode := func(aseFile *AsepriteFile, opts EncodeOptions) []byte {
+		var buf bytes.Buffer
+		if err := aseFile.EncodeWithOptions(&buf, opts); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	deterministic := EncodeOptions{Deterministic: true}
+	if !bytes.Equal(encode(clean, deterministic), encode(messy, deterministic)) {
+		t.Errorf("deterministic encodings of the same sprite differ")
+	}
+	if bytes.Equal(encode(clean, EncodeOptions{}), encode(messy, EncodeOptions{})) {
+		t.Errorf("encodings without Deterministic should keep the reserved fields and cel order")
+	}
+}
+
+func TestEncodeDamagedLazyCel(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{LazyCels: true})
+	cel := &aseFile.Frames[2].Cels[0]
+	cel.compressed = append([]byte(nil), cel.compressed[:len(cel.compressed)/2]...)
+	var buf bytes.Buffer
+	if err := aseFile.EncodeWithOptions(&buf, EncodeOptions{}); err == nil {
+		t.Errorf("encoding a cel with damaged zlib data succeeded")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("failed encode wrote %d bytes", buf.Len())
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	var done []int
+	opts := EncodeOptions{Progress: func(n, total int) {
+		if total != len(aseFile.Frames) {
+			t.Errorf("progress total %d, want %d frames", total, len(aseFile.Frames))
+		}
+		done = append(done, n)
+	}}
+	var buf bytes.Buffer
+	if err := aseFile.EncodeContext(context.Background(), &buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	for x, n := range done {
+		if n != x+1 {
+			t.Fatalf("progress %v, want one call per frame", done)
+		}
+	}
+	if len(done) != len(aseFile.Frames) {
+		t.Errorf("progress called %d times for %d frames", len(done), len(aseFile.Frames))
+	}
+
+	for _, cancelAt := range []int{0, 1, 5, 12} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if cancelAt == 0 {
+			cancel()
+		}
+		frames := 0
+		opts := EncodeOptions{Progress: func(n, total int) {
+			frames = n
+			if n == cancelAt {
+				cancel()
+			}
+		}}
+		var buf bytes.Buffer
+		err := aseFile.EncodeContext(ctx, &buf, opts)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled after %d frames: got %v, want context.Canceled", cancelAt, err)
+		}
+		if frames != cancelAt || buf.Len() != 0 {
+			t.Errorf("cancelled after %d frames: encoded %d frames and wrote %d bytes", cancelAt, frames, buf.Len())
+		}
+		cancel()
+	}
+}
+
+func TestEncodeChunkOrder(t *testing.T) {
+	builder := NewSprite(4, 4, ColorModeRGBA)
+	layer := builder.AddLayer("Layer", -1)
+	frame := builder.AddFrame(100)
+	builder.SetCel(frame, layer, solidImage(1, 1, color.NRGBA{255, 0, 0, 255}), image.Pt(0, 0))
+	builder.AddTag("Tag", frame, frame, 0)
+	builder.AddSlice("Slice", SliceKey{SliceWidth: 2, SliceHeight: 2})
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := aseFile.EncodeWithOptions(&buf, EncodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	scanner, err := NewChunkScanner(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []ChunkType
+	for scanner.NextFrame() {
+		for scanner.NextChunk() {
+			got = append(got, scanner.Chunk().Type)
+		}
+	}
+	// Layers come before the tags and slices, and the cels last, as Aseprite
+	// saves them
+	want := []ChunkType{ChunkColorProfile, ChunkPalette, ChunkLayer, ChunkTags, ChunkSlice, ChunkCel}
+	if len(got) != len(want) {
+		t.Fatalf("chunks %v, want %v", got, want)
+	}
+	for x := range want {
+		if got[x] != want[x] {
+			t.Fatalf("chunks %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package asefile
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// SheetOptions control how Sprite.RenderSheet lays out frames
+type SheetOptions struct {
+	// How each frame is flattened. With CorrectPixelRatio the cells of the
+	// sheet are the scaled frame size.
+	RenderOptions
+	// Columns is the number of frames in a row, all of them in one row when
+	// zero
+	Columns int
+	// Frames are the frames to place, every frame in order when nil
+	Frames []int
+}
+
+// GIFOptions control how Sprite.GIF flattens frames. Premultiplied is
+// ignored, GIF colors are not premultiplied.
+type GIFOptions struct {
+	RenderOptions
+	// Frames are the frames to play, every frame in order when nil
+	Frames []int
+}
+
+// exportFrames returns the frames to export, every frame when frames is nil
+func (sprite *Sprite) exportFrames(frames []int) ([]int, error) {
+	if frames == nil {
+		frames = make([]int, len(sprite.frames))
+		for x := range frames {
+			frames[x] = x
+		}
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames to export")
+	}
+	for _, frame := range frames {
+		if frame < 0 || frame >= len(sprite.frames) {
+			return nil, fmt.Errorf("frame %d out of range, the sprite has %d frames", frame, len(sprite.frames))
+		}
+	}
+	return frames, nil
+}
+
+// RenderSheet flattens frames with Render and lays them out left to right
+// and top to bottom in a grid of cells of the frame size. Sheets of more
+// than 2^26 pixels give an error wrapping ErrImageTooLarge.
+func (sprite *Sprite) RenderSheet(opts SheetOptions) (image.Image, error) {
+	frames, err := sprite.exportFrames(opts.Frames)
+	if err != nil {
+		return nil, err
+	}
+	columns := opts.Columns
+	if columns <= 0 || columns > len(frames) {
+		columns = len(frames)
+	}
+	rows := (len(frames) + columns - 1) / columns
+	cellWidth, cellHeight := sprite.frameSize(opts.RenderOptions)
+	if err := checkImageSize(int64(cellWidth)*int64(columns), int64(cellHeight)*int64(rows)); err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, cellWidth*columns, cellHeight*rows)
+	var sheet draw.Image = image.NewNRGBA(bounds)
+	if opts.Premultiplied {
+		sheet = image.NewRGBA(bounds)
+	}
+	for x, frame := range frames {
+		img, err := sprite.Render(frame, opts.RenderOptions)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", frame, err)
+		}
+		at := image.Pt(x%columns*cellWidth, x/columns*cellHeight)
+		draw.Draw(sheet, img.Bounds().Add(at), img, image.Point{}, draw.Src)
+	}
+	return sheet, nil
+}
+
+/**
+ * GIF flattens frames with Render into an animation looping forever, each
+ * frame shown for its duration. Pixels less than half opaque are
+ * transparent and the others opaque. The colors of the frames make up the
+ * palette when there are at most 255 of them, with the transparent color at
+ * index 0; otherwise each color is replaced by the nearest of the Plan 9
+ * palette.
+ */
+func (sprite *Sprite) GIF(opts GIFOptions) (*gif.GIF, error) {
+	frames, err := sprite.exportFrames(opts.Frames)
+	if err != nil {
+		return nil, err
+	}
+	renderOpts := opts.RenderOptions
+	renderOpts.Premultiplied = false
+	images := make([]*image.NRGBA, len(frames))
+	colors := color.Palette{color.NRGBA{}}
+	indices := map[color.NRGBA]uint8{{}: 0}
+	for x, frame := range frames {
+		img, err := sprite.Render(frame, renderOpts)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", frame, err)
+		}
+		images[x] = img.(*image.NRGBA)
+		// Collecting stops one color past what fits, falling back to Plan 9
+		for y := 0; y < len(images[x].Pix); y += 4 {
+			c := gifColor(images[x].Pix[y:])
+			if _, ok := indices[c]; !ok && len(colors) <= 256 {
+				indices[c] = uint8(len(colors))
+				colors = append(colors, c)
+			}
+		}
+	}
+	if len(colors) > 256 {
+		colors = append(color.Palette{color.NRGBA{}}, palette.Plan9[:255]...)
+		indices = nil
+	}
+
+	anim := &gif.GIF{}
+	for x, frame := range frames {
+		img := images[x]
+		paletted := image.NewPaletted(img.Bounds(), colors)
+		for y := 0; y < len(paletted.Pix); y += 1 {
+			c := gifColor(img.Pix[y*4:])
+			switch {
+			case c.A == 0:
+				paletted.Pix[y] = 0
+			case indices != nil:
+				paletted.Pix[y] = indices[c]
+			default:
+				paletted.Pix[y] = uint8(colors[1:].Index(c) + 1)
+			}
+		}
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, int((sprite.frames[frame].Duration+5*time.Millisecond)/(10*time.Millisecond)))
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+	width, height := sprite.frameSize(opts.RenderOptions)
+	anim.Config = image.Config{ColorModel: colors, Width: width, Height: height}
+	return anim, nil
+}
+
+// gifColor returns the NRGBA pixel at the start of pix as it is shown in a
+// GIF, opaque or fully transparent
+func gifColor(pix []byte) color.NRGBA {
+	if pix[3] < 128 {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{pix[0], pix[1], pix[2], 255}
+}
+
+// EncodeGIF writes the animation built by GIF to w
+func (sprite *Sprite) EncodeGIF(w io.Writer, opts GIFOptions) error {
+	anim, err := sprite.GIF(opts)
+	if err != nil {
+		return err
+	}
+	return gif.EncodeAll(w, anim)
+}
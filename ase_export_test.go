@@ -0,0 +1,106 @@
+package asefile
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestRenderSheet(t *testing.T) {
+	red, green, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	sprite := pixelRatioSprite(t, [2]color.NRGBA{red, blue}, [2]color.NRGBA{green, red})
+	for _, test := range []struct {
+		name   string
+		opts   SheetOptions
+		bounds image.Rectangle
+		pixels map[image.Point]color.NRGBA
+	}{
+		{"one row", SheetOptions{}, image.Rect(0, 0, 4, 1),
+			map[image.Point]color.NRGBA{{1, 0}: blue, {2, 0}: green}},
+		{"one column with the pixel ratio", SheetOptions{RenderOptions: RenderOptions{CorrectPixelRatio: true}, Columns: 1},
+			image.Rect(0, 0, 4, 2), map[image.Point]color.NRGBA{{1, 0}: red, {2, 0}: blue, {0, 1}: green, {3, 1}: red}},
+		{"frames in another order", SheetOptions{Frames: []int{1, 1, 0}, Columns: 2}, image.Rect(0, 0, 4, 2),
+			map[image.Point]color.NRGBA{{0, 0}: green, {2, 0}: green, {0, 1}: red, {3, 1}: {}}},
+	} {
+		img, err := sprite.RenderSheet(test.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		sheet := img.(*image.NRGBA)
+		if sheet.Bounds() != test.bounds {
+			t.Errorf("%s: sheet of %v, want %v", test.name, sheet.Bounds(), test.bounds)
+		}
+		for at, want := range test.pixels {
+			if got := sheet.NRGBAAt(at.X, at.Y); got != want {
+				t.Errorf("%s: pixel %v is %v, want %v", test.name, at, got, want)
+			}
+		}
+	}
+
+	if _, err := sprite.RenderSheet(SheetOptions{Frames: []int{2}}); err == nil {
+		t.Errorf("missing frame: no error")
+	}
+	if _, err := sprite.RenderSheet(SheetOptions{Frames: []int{}}); err == nil {
+		t.Errorf("no frames: no error")
+	}
+	sprite.Width, sprite.Height = 1<<13, 1<<13
+	if _, err := sprite.RenderSheet(SheetOptions{}); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("sheet past the size limit gave %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestGIF(t *testing.T) {
+	red, clear := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 100}
+	sprite := pixelRatioSprite(t, [2]color.NRGBA{red, clear}, [2]color.NRGBA{{0, 0, 255, 200}, red})
+	var buf bytes.Buffer
+	if err := sprite.EncodeGIF(&buf, GIFOptions{RenderOptions: RenderOptions{CorrectPixelRatio: true}}); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 2 || anim.Delay[0] != 10 || anim.Delay[1] != 5 || anim.Config.Width != 4 || anim.Config.Height != 1 {
+		t.Fatalf("%d frames with delays %v of %dx%d", len(anim.Image), anim.Delay, anim.Config.Width, anim.Config.Height)
+	}
+	for _, test := range []struct {
+		frame, x int
+		want     color.NRGBA
+	}{{0, 1, red}, {0, 2, color.NRGBA{}}, {1, 0, color.NRGBA{0, 0, 255, 255}}, {1, 3, red}} {
+		got := color.NRGBAModel.Convert(anim.Image[test.frame].At(test.x, 0)).(color.NRGBA)
+		if got.A == 0 {
+			got = color.NRGBA{}
+		}
+		if got != test.want {
+			t.Errorf("frame %d: pixel %d is %v, want %v", test.frame, test.x, got, test.want)
+		}
+	}
+
+	// Too many colors for the palette fall back to the nearest Plan 9 color
+	builder := NewSprite(300, 1, ColorModeRGBA)
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 1))
+	for x := 0; x < 300; x += 1 {
+		img.SetNRGBA(x, 0, color.NRGBA{byte(x), byte(x >> 8), 0, 255})
+	}
+	builder.SetCel(builder.AddFrame(100), builder.AddLayer("Layer", -1), img, image.Pt(0, 0))
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sprite, err = SpriteFromFile(aseFile); err != nil {
+		t.Fatal(err)
+	}
+	anim, err = sprite.GIF(GIFOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if colors := anim.Image[0].Palette; len(colors) != 256 {
+		t.Errorf("palette of %d colors", len(colors))
+	}
+	if got := color.NRGBAModel.Convert(anim.Image[0].At(255, 0)).(color.NRGBA); got.R < 0xE0 || got.A != 255 {
+		t.Errorf("pixel 255 quantized to %v", got)
+	}
+}
@@ -0,0 +1,109 @@
+package asefile
+
+import (
+	"io"
+)
+
+/**
+ * fieldReader parses little-endian fields from the bytes of a header or
+ * chunk that has already been read in full, without the reflection and
+ * per-field allocations of binary.Read. Like binary.Read with its error
+ * ignored, a field that runs past the end of the data reads as zero.
+ */
+type fieldReader struct {
+	data []byte
+}
+
+// readFields reads the rest of r for the Decode methods of chunks, which are
+// handed a reader over the data of a single chunk
+func readFields(r io.Reader) (*fieldReader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &fieldReader{data}, nil
+}
+
+// Len returns the number of bytes left, which lets checkCount bound counts
+func (fr *fieldReader) Len() int {
+	return len(fr.data)
+}
+
+// next returns the next n bytes, or nil when fewer than n are left
+func (fr *fieldReader) next(n int) []byte {
+	if n > len(fr.data) {
+		fr.data = nil
+		return nil
+	}
+	field := fr.data[:n]
+	fr.data = fr.data[n:]
+	return field
+}
+
+func (fr *fieldReader) uint8() byte {
+	if field := fr.next(1); field != nil {
+		return field[0]
+	}
+	return 0
+}
+
+func (fr *fieldReader) uint16() uint16 {
+	if field := fr.next(2); field != nil {
+		return ble.Uint16(field)
+	}
+	return 0
+}
+
+func (fr *fieldReader) int16() int16 {
+	return int16(fr.uint16())
+}
+
+func (fr *fieldReader) uint32() uint32 {
+	if field := fr.next(4); field != nil {
+		return ble.Uint32(field)
+	}
+	return 0
+}
+
+func (fr *fieldReader) int32() int32 {
+	return int32(fr.uint32())
+}
+
+func (fr *fieldReader) uint64() uint64 {
+	if field := fr.next(8); field != nil {
+		return ble.Uint64(field)
+	}
+	return 0
+}
+
+func (fr *fieldReader) fixed() Fixed {
+	return Fixed(fr.uint32())
+}
+
+// skip fills a reserved field, so that it still round trips
+func (fr *fieldReader) skip(reserved []byte) {
+	if field := fr.next(len(reserved)); field != nil {
+		copy(reserved, field)
+	}
+}
+
+// bytes returns a copy of the next n bytes, all zero when fewer are left
+func (fr *fieldReader) bytes(n int) []byte {
+	data := make([]byte, n)
+	if field := fr.next(n); field != nil {
+		copy(data, field)
+	}
+	return data
+}
+
+// string reads a STRING: a WORD length followed by that many bytes of UTF-8
+func (fr *fieldReader) string() string {
+	return string(fr.next(int(fr.uint16())))
+}
+
+// rest returns the bytes that are left without copying them
+func (fr *fieldReader) rest() []byte {
+	rest := fr.data
+	fr.data = nil
+	return rest
+}
@@ -1,36 +1,31 @@
 package asefile
 
 import (
+	"bufio"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 type AsepriteFile struct {
 	Header AsepriteHeader
 	Frames []AsepriteFrame
+	// What was lost when decoding with DecodeOptions.Recover
+	recovery *RecoveryReport
+	// Non-fatal issues found while decoding
+	warnings []Warning
 }
 
+// Decode reads a sprite from r without any DecodeOptions limits
 func (aseFile *AsepriteFile) Decode(r io.Reader) error {
-	aseFile.Header.Decode(r)
-	aseFile.Frames = make([]AsepriteFrame, aseFile.Header.Frames)
-	for x := range aseFile.Frames {
-		aseFile.Frames[x].parentHeader = &aseFile.Header
-		err := aseFile.Frames[x].Decode(r)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return aseFile.DecodeWithOptions(r, DecodeOptions{})
 }
 
+// Encode writes the sprite with the default EncodeOptions. It does not
+// report errors, such as a failed write or a damaged lazy cel, which then
+// look like success; use EncodeWithOptions to get them.
 func (aseFile *AsepriteFile) Encode(w io.Writer) {
-	aseFile.Header.Encode(w)
-
-	aseFile.Frames = make([]AsepriteFrame, aseFile.Header.Frames)
-
-	for _, frame := range aseFile.Frames {
-		frame.Encode(w)
-	}
+	aseFile.EncodeWithOptions(w, EncodeOptions{})
 }
 
 func (aseFile *AsepriteFile) DecodeFile(fName string) error {
@@ -38,5 +33,44 @@ func (aseFile *AsepriteFile) DecodeFile(fName string) error {
 	if err != nil {
 		return err
 	}
-	return aseFile.Decode(spriteFile)
+	defer spriteFile.Close()
+	return aseFile.Decode(bufio.NewReader(spriteFile))
+}
+
+// EncodeFile writes the sprite to fName atomically: it is encoded into a
+// temporary file in the same directory, synced to disk and then renamed over
+// fName, so a crash or failed encode never leaves a partially written sprite.
+// An existing file keeps its permissions.
+func (aseFile *AsepriteFile) EncodeFile(fName string, opts EncodeOptions) (err error) {
+	perm := os.FileMode(0644)
+	if info, statErr := os.Stat(fName); statErr == nil {
+		perm = info.Mode().Perm()
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(fName), "."+filepath.Base(fName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+		}
+	}()
+	spriteWriter := bufio.NewWriter(tmpFile)
+	if err = aseFile.EncodeWithOptions(spriteWriter, opts); err != nil {
+		return err
+	}
+	if err = spriteWriter.Flush(); err != nil {
+		return err
+	}
+	if err = tmpFile.Chmod(perm); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fName)
 }
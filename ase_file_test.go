@@ -0,0 +1,125 @@
+package asefile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// dirNames lists the names of the files in dir
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestEncodeFile(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	var want bytes.Buffer
+	if err := aseFile.EncodeWithOptions(&want, EncodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	fName := filepath.Join(dir, "chica.aseprite")
+	if err := aseFile.EncodeFile(fName, EncodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(fName); err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("file holds %d bytes, want the %d encoded bytes (%v)", len(got), want.Len(), err)
+	}
+	if names := dirNames(t, dir); len(names) != 1 {
+		t.Errorf("directory holds %v after encoding", names)
+	}
+
+	// Overwriting keeps the permissions of the file
+	if err := os.Chmod(fName, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := aseFile.EncodeFile(fName, EncodeOptions{CompressionLevel: CompressionBest}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(fName); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("overwritten file has mode %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestEncodeFileFailure(t *testing.T) {
+	damaged := decodeChica(t, DecodeOptions{LazyCels: true})
+	cel := &damaged.Frames[2].Cels[0]
+	cel.compressed = append([]byte(nil), cel.compressed[:len(cel.compressed)/2]...)
+	for _, test := range []struct {
+		name    string
+		aseFile *AsepriteFile
+		opts    EncodeOptions
+	}{
+		{"compression level", decodeChica(t, DecodeOptions{}), EncodeOptions{CompressionLevel: 10}},
+		{"damaged lazy cel", damaged, EncodeOptions{}},
+	} {
+		dir := t.TempDir()
+		fName := filepath.Join(dir, "sprite.aseprite")
+		original := []byte("original sprite")
+		if err := os.WriteFile(fName, original, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := test.aseFile.EncodeFile(fName, test.opts); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+		if got, err := os.ReadFile(fName); err != nil || !bytes.Equal(got, original) {
+			t.Errorf("%s: failed encode changed the file to %d bytes (%v)", test.name, len(got), err)
+		}
+		if names := dirNames(t, dir); len(names) != 1 {
+			t.Errorf("%s: directory holds %v after a failed encode", test.name, names)
+		}
+	}
+}
+
+// openFiles counts the file descriptors of the process open on fName
+func openFiles(t *testing.T, fName string) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd to list open files")
+	}
+	open := 0
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil && target == fName {
+			open += 1
+		}
+	}
+	return open
+}
+
+func TestDecodeFileCloses(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open files are listed through /proc")
+	}
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "good.aseprite"), filepath.Join(dir, "bad.aseprite")
+	if err := os.WriteFile(good, readChica(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, readChica(t)[:200], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var aseFile AsepriteFile
+	if err := aseFile.DecodeFile(good); err != nil {
+		t.Fatal(err)
+	}
+	if err := aseFile.DecodeFile(bad); err == nil {
+		t.Errorf("truncated file decoded without error")
+	}
+	for _, fName := range []string{good, bad} {
+		if open := openFiles(t, fName); open != 0 {
+			t.Errorf("%s left open %d times", filepath.Base(fName), open)
+		}
+	}
+}
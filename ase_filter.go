@@ -0,0 +1,200 @@
+package asefile
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FrameRange selects the frames From to To, both included, or the frames of
+// the tag named Tag when it is not empty. The zero value selects frame 0
+// only; DecodeOptions.Frames is left nil to select every frame.
+type FrameRange struct {
+	From, To int
+	Tag      string
+}
+
+var layerNameEscaper = strings.NewReplacer(`\`, `\\`, "/", `\/`)
+
+// LayerPath joins the names of nested groups and a layer into a path for
+// DecodeOptions.Layers, escaping slashes and backslashes in the names with a
+// backslash so that a layer named "Base/Hair" is told apart from a layer
+// "Hair" in a group "Base"
+func LayerPath(names ...string) string {
+	escaped := make([]string, len(names))
+	for x, name := range names {
+		escaped[x] = layerNameEscaper.Replace(name)
+	}
+	return strings.Join(escaped, "/")
+}
+
+// splitLayerPath splits a path made by LayerPath back into the names
+func splitLayerPath(path string) []string {
+	var names []string
+	var name strings.Builder
+	for x := 0; x < len(path); x += 1 {
+		switch {
+		case path[x] == '\\' && x+1 < len(path):
+			x += 1
+			name.WriteByte(path[x])
+		case path[x] == '/':
+			names = append(names, name.String())
+			name.Reset()
+		default:
+			name.WriteByte(path[x])
+		}
+	}
+	return append(names, name.String())
+}
+
+// equalPaths reports whether two paths have the same names
+func equalPaths(a, b []string) bool {
+	return len(a) == len(b) && hasPathPrefix(a, b)
+}
+
+// hasPathPrefix reports whether the names of prefix start path
+func hasPathPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for x := range prefix {
+		if path[x] != prefix[x] {
+			return false
+		}
+	}
+	return true
+}
+
+// celAction is what the decoder does with a cel chunk
+type celAction int
+
+const (
+	celDecode celAction = iota
+	// Kept compressed and not inflated, as a linked cel may point at it
+	celKeep
+	celSkip
+)
+
+// filtering reports whether DecodeOptions select only some of the cels
+func (dec *decoder) filtering() bool {
+	return len(dec.opts.Layers) > 0 || len(dec.opts.LayerIndices) > 0 || dec.opts.Frames != nil
+}
+
+// trackLayer records the group path of a layer chunk for the layer filter
+func (dec *decoder) trackLayer(layer *AsepriteLayerChunk2004) {
+	level := int(layer.LayerChildLevel)
+	if level > len(dec.groupPath) {
+		level = len(dec.groupPath)
+	}
+	dec.groupPath = append(dec.groupPath[:level], layer.LayerName)
+	dec.layerPaths = append(dec.layerPaths, append([]string(nil), dec.groupPath...))
+}
+
+// resolveFilter turns the layer names, paths and indices and the frame range
+// of the options into layer indices and frame numbers. It runs once the
+// layers and tags, which Aseprite writes before the first cel, are known.
+func (dec *decoder) resolveFilter() error {
+	if dec.filterResolved {
+		return nil
+	}
+	dec.filterResolved = true
+	dec.fromFrame, dec.toFrame = 0, int(^uint(0)>>1)
+	if frames := dec.opts.Frames; frames != nil {
+		dec.fromFrame, dec.toFrame = frames.From, frames.To
+		if frames.Tag == "" && (frames.From < 0 || frames.To < frames.From) {
+			return fmt.Errorf("frame range %d to %d is empty", frames.From, frames.To)
+		}
+		if frames.Tag != "" {
+			found := false
+			for _, tag := range dec.tags {
+				if tag.TagName == frames.Tag {
+					dec.fromFrame, dec.toFrame = int(tag.FromFrame), int(tag.ToFrame)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("tag %q not found", frames.Tag)
+			}
+		}
+	}
+	if len(dec.opts.Layers) == 0 && len(dec.opts.LayerIndices) == 0 {
+		return nil
+	}
+	// A selected group selects every layer whose path it prefixes. A single
+	// name also selects the layers of that name at any depth.
+	var selectedPaths [][]string
+	for _, name := range dec.opts.Layers {
+		names := splitLayerPath(name)
+		found := false
+		for _, path := range dec.layerPaths {
+			if equalPaths(path, names) || len(names) == 1 && path[len(path)-1] == names[0] {
+				selectedPaths = append(selectedPaths, path)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("layer %q not found", name)
+		}
+	}
+	for _, index := range dec.opts.LayerIndices {
+		if index < 0 || index >= len(dec.layerPaths) {
+			return fmt.Errorf("layer index %d out of range, the sprite has %d layers", index, len(dec.layerPaths))
+		}
+		selectedPaths = append(selectedPaths, dec.layerPaths[index])
+	}
+	dec.selectedLayers = make(map[int]bool)
+	for index, path := range dec.layerPaths {
+		for _, selected := range selectedPaths {
+			if hasPathPrefix(path, selected) {
+				dec.selectedLayers[index] = true
+			}
+		}
+	}
+	return nil
+}
+
+// celAction decides what to do with a cel on layer in the frame being decoded
+func (dec *decoder) celAction(layer int) (celAction, error) {
+	if !dec.filtering() {
+		return celDecode, nil
+	}
+	if err := dec.resolveFilter(); err != nil {
+		return celSkip, err
+	}
+	if dec.selectedLayers != nil && !dec.selectedLayers[layer] {
+		return celSkip, nil
+	}
+	switch {
+	case dec.frame > dec.toFrame:
+		return celSkip, nil
+	case dec.frame < dec.fromFrame:
+		return celKeep, nil
+	}
+	return celDecode, nil
+}
+
+// filterCel reads the layer index at the start of a cel chunk and discards
+// the rest of the chunk if the cel is filtered out. Otherwise the layer index
+// is left in the chunk buffer for readChunk and the action is returned.
+func (dec *decoder) filterCel(r io.Reader, chunkSize uint32) (celAction, error) {
+	if !dec.filtering() || chunkSize < 8 {
+		return celDecode, nil
+	}
+	if err := dec.checkChunkSize(chunkSize); err != nil {
+		return celSkip, err
+	}
+	layerIndex := dec.scratch[:2]
+	if _, err := io.ReadFull(r, layerIndex); err != nil {
+		return celSkip, truncated(err)
+	}
+	dec.chunkBuf.Write(layerIndex)
+	action, err := dec.celAction(int(ble.Uint16(layerIndex)))
+	if err != nil || action != celSkip {
+		return action, err
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(chunkSize)-8); err != nil {
+		return celSkip, truncated(err)
+	}
+	return celSkip, nil
+}
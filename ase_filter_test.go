@@ -0,0 +1,134 @@
+package asefile
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// filterSprite encodes a sprite of 4 frames with the layers
+//
+//	0 Base        group
+//	1 Base/Hair   in Base
+//	2 Base/Face   in Base
+//	3 "Base/Hair" at the top level
+//	4 "a\b"       at the top level
+//
+// a cel on every image layer in every frame and a tag Middle on frames 1-2
+func filterSprite(t *testing.T) []byte {
+	t.Helper()
+	builder := NewSprite(4, 4, ColorModeRGBA)
+	base := builder.AddGroup("Base", -1)
+	layers := []int{builder.AddLayer("Hair", base), builder.AddLayer("Face", base),
+		builder.AddLayer("Base/Hair", -1), builder.AddLayer(`a\b`, -1)}
+	for x := 0; x < 4; x += 1 {
+		frame := builder.AddFrame(100)
+		for _, layer := range layers {
+			builder.SetCel(frame, layer, solidImage(1, 1, color.NRGBA{uint8(x), uint8(layer), 0, 255}), image.Pt(0, 0))
+		}
+	}
+	builder.AddTag("Middle", 1, 2, LoopForward)
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := aseFile.EncodeWithOptions(&buf, EncodeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// inflatedCels lists the frame and layer of each cel decoded with its pixels
+func inflatedCels(aseFile *AsepriteFile) []string {
+	var cels []string
+	for x, aseFrame := range aseFile.Frames {
+		for _, cel := range aseFrame.Cels {
+			if cel.RawCelData != nil {
+				cels = append(cels, fmt.Sprintf("%d:%d", x, cel.LayerIndex))
+			}
+		}
+	}
+	return cels
+}
+
+func TestLayerPath(t *testing.T) {
+	for _, names := range [][]string{{"Base", "Hair"}, {"Base/Hair"}, {`a\b`}, {`a\`, "/b/"}, {""}} {
+		path := LayerPath(names...)
+		if got := splitLayerPath(path); !reflect.DeepEqual(got, names) {
+			t.Errorf("LayerPath(%q) = %q splits into %q", names, path, got)
+		}
+	}
+	if LayerPath("Base", "Hair") == LayerPath("Base/Hair") {
+		t.Errorf("a layer in a group and a layer with a slash in its name have the same path")
+	}
+
+	var aseFile AsepriteFile
+	if err := aseFile.Decode(bytes.NewReader(filterSprite(t))); err != nil {
+		t.Fatal(err)
+	}
+	sprite, err := SpriteFromFile(&aseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, want := range []string{"Base", "Base/Hair", "Base/Face", `Base\/Hair`, `a\\b`} {
+		if path := sprite.Layer(index).Path(); path != want {
+			t.Errorf("layer %d has path %q, want %q", index, path, want)
+		}
+	}
+}
+
+func TestDecodeFilters(t *testing.T) {
+	data := filterSprite(t)
+	for _, test := range []struct {
+		name string
+		opts DecodeOptions
+		want []string
+	}{
+		{"name at any depth", DecodeOptions{Layers: []string{"Hair"}}, []string{"0:1", "1:1", "2:1", "3:1"}},
+		{"group path", DecodeOptions{Layers: []string{LayerPath("Base", "Hair")}}, []string{"0:1", "1:1", "2:1", "3:1"}},
+		{"escaped slash", DecodeOptions{Layers: []string{LayerPath("Base/Hair")}}, []string{"0:3", "1:3", "2:3", "3:3"}},
+		{"escaped backslash", DecodeOptions{Layers: []string{LayerPath(`a\b`)}}, []string{"0:4", "1:4", "2:4", "3:4"}},
+		{"group", DecodeOptions{Layers: []string{"Base"}, Frames: &FrameRange{From: 3, To: 3}}, []string{"3:1", "3:2"}},
+		{"index", DecodeOptions{LayerIndices: []int{0}, Frames: &FrameRange{From: 3, To: 3}}, []string{"3:1", "3:2"}},
+		{"names and indices", DecodeOptions{Layers: []string{"Face"}, LayerIndices: []int{4}, Frames: &FrameRange{From: 1, To: 1}},
+			[]string{"1:2", "1:4"}},
+		{"frame range", DecodeOptions{Layers: []string{"Face"}, Frames: &FrameRange{From: 1, To: 2}}, []string{"1:2", "2:2"}},
+		{"tag", DecodeOptions{Layers: []string{"Face"}, Frames: &FrameRange{Tag: "Middle"}}, []string{"1:2", "2:2"}},
+		{"zero frame range", DecodeOptions{Layers: []string{"Face"}, Frames: &FrameRange{}}, []string{"0:2"}},
+	} {
+		var aseFile AsepriteFile
+		if err := aseFile.DecodeWithOptions(bytes.NewReader(data), test.opts); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := inflatedCels(&aseFile); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: decoded cels %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecodeFiltersErrors(t *testing.T) {
+	data := filterSprite(t)
+	for _, test := range []struct {
+		name string
+		opts DecodeOptions
+	}{
+		{"missing layer", DecodeOptions{Layers: []string{"Nose"}}},
+		{"unescaped slash", DecodeOptions{Layers: []string{"Base/Hair/"}}},
+		{"missing group path", DecodeOptions{Layers: []string{"Hair/Base"}}},
+		{"layer index", DecodeOptions{LayerIndices: []int{5}}},
+		{"negative layer index", DecodeOptions{LayerIndices: []int{-1}}},
+		{"missing tag", DecodeOptions{Frames: &FrameRange{Tag: "Start"}}},
+		{"reversed frame range", DecodeOptions{Frames: &FrameRange{From: 2, To: 1}}},
+		{"negative frame range", DecodeOptions{Frames: &FrameRange{From: -1, To: 1}}},
+	} {
+		var aseFile AsepriteFile
+		if err := aseFile.DecodeWithOptions(bytes.NewReader(data), test.opts); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package asefile
+
+import (
+	"math"
+	"strconv"
+)
+
+// Fixed is a signed 16.16 fixed point number, the FIXED type of the spec
+type Fixed int32
+
+// FixedOne is 1.0
+const FixedOne Fixed = 1 << 16
+
+// FixedFromFloat64 rounds f to the nearest Fixed, saturating at the limits of
+// the type
+func FixedFromFloat64(f float64) Fixed {
+	scaled := math.Round(f * float64(FixedOne))
+	switch {
+	case math.IsNaN(scaled):
+		return 0
+	case scaled >= math.MaxInt32:
+		return math.MaxInt32
+	case scaled <= math.MinInt32:
+		return math.MinInt32
+	}
+	return Fixed(scaled)
+}
+
+// FixedFromInt returns n as a Fixed, saturating outside -32768 to 32767
+func FixedFromInt(n int) Fixed {
+	switch {
+	case n > math.MaxInt16:
+		return math.MaxInt32
+	case n < math.MinInt16:
+		return math.MinInt32
+	}
+	return Fixed(n << 16)
+}
+
+// saturate clamps v to the limits of Fixed
+func saturate(v int64) Fixed {
+	switch {
+	case v > math.MaxInt32:
+		return math.MaxInt32
+	case v < math.MinInt32:
+		return math.MinInt32
+	}
+	return Fixed(v)
+}
+
+func (fixed Fixed) Float64() float64 {
+	return float64(fixed) / float64(FixedOne)
+}
+
+// Int rounds to the nearest integer with halves rounded up, as Aseprite does
+func (fixed Fixed) Int() int {
+	return int((int64(fixed) + int64(FixedOne)/2) >> 16)
+}
+
+// Floor returns the integer part, rounded towards negative infinity
+func (fixed Fixed) Floor() int {
+	return int(fixed >> 16)
+}
+
+// Add adds, saturating at the limits of the type rather than wrapping
+func (fixed Fixed) Add(other Fixed) Fixed {
+	return saturate(int64(fixed) + int64(other))
+}
+
+// Sub subtracts, saturating at the limits of the type rather than wrapping
+func (fixed Fixed) Sub(other Fixed) Fixed {
+	return saturate(int64(fixed) - int64(other))
+}
+
+// Mul multiplies with a 64 bit intermediate, so that the result keeps all of
+// its fraction bits, saturating at the limits of the type
+func (fixed Fixed) Mul(other Fixed) Fixed {
+	return saturate((int64(fixed) * int64(other)) >> 16)
+}
+
+// Div divides with a 64 bit intermediate, saturating at the limits of the
+// type and returning 0 for a zero divisor
+func (fixed Fixed) Div(other Fixed) Fixed {
+	if other == 0 {
+		return 0
+	}
+	return saturate((int64(fixed) << 16) / int64(other))
+}
+
+func (fixed Fixed) String() string {
+	return strconv.FormatFloat(fixed.Float64(), 'f', -1, 64)
+}
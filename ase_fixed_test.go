@@ -0,0 +1,98 @@
+package asefile
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFixedConversions(t *testing.T) {
+	for _, test := range []struct {
+		in         float64
+		fixed      Fixed
+		float      float64
+		int, floor int
+		str        string
+	}{
+		{0, 0, 0, 0, 0, "0"},
+		{1, FixedOne, 1, 1, 1, "1"},
+		{1.5, 0x18000, 1.5, 2, 1, "1.5"},
+		// Halves round up, towards positive infinity
+		{-1.5, -0x18000, -1.5, -1, -2, "-1.5"},
+		{-0.5, -0x8000, -0.5, 0, -1, "-0.5"},
+		{-0.25, -0x4000, -0.25, 0, -1, "-0.25"},
+		{2.75, 0x2C000, 2.75, 3, 2, "2.75"},
+		{1.0 / 65536, 1, 1.0 / 65536, 0, 0, "0.0000152587890625"},
+		// Saturation at the limits of the type
+		{40000, math.MaxInt32, 32768 - 1.0/65536, 32768, 32767, "32767.99998474121"},
+		{-40000, math.MinInt32, -32768, -32768, -32768, "-32768"},
+		{math.Inf(1), math.MaxInt32, 32768 - 1.0/65536, 32768, 32767, "32767.99998474121"},
+		{math.NaN(), 0, 0, 0, 0, "0"},
+	} {
+		fixed := FixedFromFloat64(test.in)
+		if fixed != test.fixed {
+			t.Errorf("FixedFromFloat64(%v) is %#x, want %#x", test.in, int32(fixed), int32(test.fixed))
+			continue
+		}
+		if got := fixed.Float64(); got != test.float {
+			t.Errorf("%v: Float64 is %v, want %v", test.in, got, test.float)
+		}
+		if got := fixed.Int(); got != test.int {
+			t.Errorf("%v: Int is %d, want %d", test.in, got, test.int)
+		}
+		if got := fixed.Floor(); got != test.floor {
+			t.Errorf("%v: Floor is %d, want %d", test.in, got, test.floor)
+		}
+		if got := fixed.String(); got != test.str {
+			t.Errorf("%v: String is %q, want %q", test.in, got, test.str)
+		}
+	}
+
+	for _, test := range []struct {
+		in   int
+		want Fixed
+	}{
+		{3, 3 * FixedOne}, {-3, -3 * FixedOne}, {32767, 32767 * FixedOne}, {-32768, math.MinInt32},
+		{32768, math.MaxInt32}, {-32769, math.MinInt32}, {1 << 40, math.MaxInt32},
+	} {
+		if got := FixedFromInt(test.in); got != test.want {
+			t.Errorf("FixedFromInt(%d) is %#x, want %#x", test.in, int32(got), int32(test.want))
+		}
+	}
+}
+
+func TestFixedArithmetic(t *testing.T) {
+	f := FixedFromFloat64
+	for _, test := range []struct {
+		name string
+		got  Fixed
+		want Fixed
+	}{
+		{"1.5 + 2.25", f(1.5).Add(f(2.25)), f(3.75)},
+		{"-1.5 + 0.25", f(-1.5).Add(f(0.25)), f(-1.25)},
+		{"1.5 - 2.25", f(1.5).Sub(f(2.25)), f(-0.75)},
+		{"1.5 * 2", f(1.5).Mul(f(2)), f(3)},
+		{"-1.5 * 2", f(-1.5).Mul(f(2)), f(-3)},
+		{"1.5 * -2", f(1.5).Mul(f(-2)), f(-3)},
+		{"-1.5 * -2", f(-1.5).Mul(f(-2)), f(3)},
+		{"0.5 * 0.5", f(0.5).Mul(f(0.5)), f(0.25)},
+		{"3 / 2", f(3).Div(f(2)), f(1.5)},
+		{"-3 / 2", f(-3).Div(f(2)), f(-1.5)},
+		{"3 / -2", f(3).Div(f(-2)), f(-1.5)},
+		{"-3 / -2", f(-3).Div(f(-2)), f(1.5)},
+		{"1 / 0", f(1).Div(0), 0},
+		// Results past the limits saturate instead of wrapping
+		{"max + 1", Fixed(math.MaxInt32).Add(FixedOne), math.MaxInt32},
+		{"min + -1", Fixed(math.MinInt32).Add(-FixedOne), math.MinInt32},
+		{"min - 1", Fixed(math.MinInt32).Sub(FixedOne), math.MinInt32},
+		{"max - -1", Fixed(math.MaxInt32).Sub(-FixedOne), math.MaxInt32},
+		{"300 * 300", f(300).Mul(f(300)), math.MaxInt32},
+		{"300 * -300", f(300).Mul(f(-300)), math.MinInt32},
+		{"300 / 0.001", f(300).Div(f(0.001)), math.MaxInt32},
+		{"-300 / 0.001", f(-300).Div(f(0.001)), math.MinInt32},
+		{"min / -1", Fixed(math.MinInt32).Div(-FixedOne), math.MaxInt32},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s is %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
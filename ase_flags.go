@@ -0,0 +1,382 @@
+package asefile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColorMode is the pixel format of a sprite, stored as its bits per pixel
+type ColorMode uint16
+
+const (
+	ColorModeIndexed   ColorMode = 8
+	ColorModeGrayscale ColorMode = 16
+	ColorModeRGBA      ColorMode = 32
+)
+
+func (colorMode ColorMode) String() string {
+	switch colorMode {
+	case ColorModeIndexed:
+		return "indexed"
+	case ColorModeGrayscale:
+		return "grayscale"
+	case ColorModeRGBA:
+		return "RGBA"
+	}
+	return fmt.Sprintf("ColorMode(%d)", uint16(colorMode))
+}
+
+// HeaderFlags are the flags of the file header
+type HeaderFlags uint32
+
+const (
+	HeaderFlagLayerOpacityValid HeaderFlags = 1
+	HeaderFlagGroupOpacityValid HeaderFlags = 2
+	HeaderFlagLayerUUIDs        HeaderFlags = 4
+)
+
+// LayerOpacityValid reports whether the opacity of layers is set, rather than
+// left at 0 by old versions of Aseprite
+func (flags HeaderFlags) LayerOpacityValid() bool {
+	return flags&HeaderFlagLayerOpacityValid != 0
+}
+
+// GroupOpacityValid reports whether the opacity and blend mode of groups are
+// set
+func (flags HeaderFlags) GroupOpacityValid() bool {
+	return flags&HeaderFlagGroupOpacityValid != 0
+}
+
+// LayerUUIDs reports whether layers have a UUID
+func (flags HeaderFlags) LayerUUIDs() bool {
+	return flags&HeaderFlagLayerUUIDs != 0
+}
+
+func (flags HeaderFlags) String() string {
+	return flagNames(uint32(flags), []string{"layer opacity valid", "group opacity valid", "layer UUIDs"})
+}
+
+// LayerFlags are the flags of a layer chunk
+type LayerFlags uint16
+
+const (
+	LayerFlagVisible          LayerFlags = 1
+	LayerFlagEditable         LayerFlags = 2
+	LayerFlagLockMovement     LayerFlags = 4
+	LayerFlagBackground       LayerFlags = 8
+	LayerFlagPreferLinkedCels LayerFlags = 16
+	// The group is shown collapsed in the timeline
+	LayerFlagCollapsed LayerFlags = 32
+	LayerFlagReference LayerFlags = 64
+)
+
+func (flags LayerFlags) Visible() bool {
+	return flags&LayerFlagVisible != 0
+}
+
+func (flags LayerFlags) Editable() bool {
+	return flags&LayerFlagEditable != 0
+}
+
+func (flags LayerFlags) LockMovement() bool {
+	return flags&LayerFlagLockMovement != 0
+}
+
+func (flags LayerFlags) Background() bool {
+	return flags&LayerFlagBackground != 0
+}
+
+func (flags LayerFlags) PreferLinkedCels() bool {
+	return flags&LayerFlagPreferLinkedCels != 0
+}
+
+func (flags LayerFlags) Collapsed() bool {
+	return flags&LayerFlagCollapsed != 0
+}
+
+func (flags LayerFlags) Reference() bool {
+	return flags&LayerFlagReference != 0
+}
+
+func (flags LayerFlags) String() string {
+	return flagNames(uint32(flags), []string{"visible", "editable", "lock movement", "background",
+		"prefer linked cels", "collapsed", "reference"})
+}
+
+// TilesetFlags are the flags of a tileset chunk
+type TilesetFlags uint32
+
+const (
+	TilesetFlagExternalFile TilesetFlags = 1
+	TilesetFlagTilesInFile  TilesetFlags = 2
+	TilesetFlagZeroIsEmpty  TilesetFlags = 4
+)
+
+// ExternalFile reports whether the tileset links to one in an external file
+func (flags TilesetFlags) ExternalFile() bool {
+	return flags&TilesetFlagExternalFile != 0
+}
+
+// TilesInFile reports whether the tiles are stored in the chunk
+func (flags TilesetFlags) TilesInFile() bool {
+	return flags&TilesetFlagTilesInFile != 0
+}
+
+// ZeroIsEmpty reports whether tile 0 is the empty tile, instead of 0xFFFFFFFF
+// in files from some internal versions of Aseprite
+func (flags TilesetFlags) ZeroIsEmpty() bool {
+	return flags&TilesetFlagZeroIsEmpty != 0
+}
+
+func (flags TilesetFlags) String() string {
+	return flagNames(uint32(flags), []string{"external file", "tiles in file", "zero is empty"})
+}
+
+// SliceFlags are the flags of a slice chunk
+type SliceFlags uint32
+
+const (
+	SliceFlagNinePatch SliceFlags = 1
+	SliceFlagHasPivot  SliceFlags = 2
+)
+
+// NinePatch reports whether the keys of the slice have a center
+func (flags SliceFlags) NinePatch() bool {
+	return flags&SliceFlagNinePatch != 0
+}
+
+// HasPivot reports whether the keys of the slice have a pivot
+func (flags SliceFlags) HasPivot() bool {
+	return flags&SliceFlagHasPivot != 0
+}
+
+func (flags SliceFlags) String() string {
+	return flagNames(uint32(flags), []string{"9-patch", "pivot"})
+}
+
+// UserDataFlags are the flags of a user data chunk
+type UserDataFlags uint32
+
+const (
+	UserDataFlagHasText       UserDataFlags = 1
+	UserDataFlagHasColor      UserDataFlags = 2
+	UserDataFlagHasProperties UserDataFlags = 4
+)
+
+func (flags UserDataFlags) HasText() bool {
+	return flags&UserDataFlagHasText != 0
+}
+
+func (flags UserDataFlags) HasColor() bool {
+	return flags&UserDataFlagHasColor != 0
+}
+
+func (flags UserDataFlags) HasProperties() bool {
+	return flags&UserDataFlagHasProperties != 0
+}
+
+func (flags UserDataFlags) String() string {
+	return flagNames(uint32(flags), []string{"text", "color", "properties"})
+}
+
+// flagNames joins the names of the bits set in flags with "|", bits without a
+// name being printed in hex
+func flagNames(flags uint32, names []string) string {
+	var set []string
+	for bit, name := range names {
+		if flags&(1<<bit) != 0 {
+			set = append(set, name)
+			flags &^= 1 << bit
+		}
+	}
+	if flags != 0 {
+		set = append(set, fmt.Sprintf("0x%X", flags))
+	}
+	if len(set) == 0 {
+		return "none"
+	}
+	return strings.Join(set, "|")
+}
+
+// PropertyType is the type of the value of a user data property
+type PropertyType uint16
+
+const (
+	PropertyTypeBool PropertyType = iota + 1
+	PropertyTypeInt8
+	PropertyTypeUint8
+	PropertyTypeInt16
+	PropertyTypeUint16
+	PropertyTypeInt32
+	PropertyTypeUint32
+	PropertyTypeInt64
+	PropertyTypeUint64
+	PropertyTypeFixed
+	PropertyTypeFloat
+	PropertyTypeDouble
+	PropertyTypeString
+	PropertyTypePoint
+	PropertyTypeSize
+	PropertyTypeRect
+	PropertyTypeVector
+	PropertyTypeMap
+	PropertyTypeUUID
+)
+
+var propertyTypeNames = []string{
+	"bool", "int8", "uint8", "int16", "uint16", "int32", "uint32", "int64", "uint64", "fixed",
+	"float", "double", "string", "point", "size", "rect", "vector", "map", "UUID",
+}
+
+func (propertyType PropertyType) String() string {
+	if propertyType >= PropertyTypeBool && int(propertyType) <= len(propertyTypeNames) {
+		return propertyTypeNames[propertyType-1]
+	}
+	return fmt.Sprintf("PropertyType(%d)", uint16(propertyType))
+}
+
+// LayerType is the kind of a layer
+type LayerType uint16
+
+const (
+	LayerTypeImage   LayerType = 0
+	LayerTypeGroup   LayerType = 1
+	LayerTypeTilemap LayerType = 2
+)
+
+func (layerType LayerType) String() string {
+	switch layerType {
+	case LayerTypeImage:
+		return "image"
+	case LayerTypeGroup:
+		return "group"
+	case LayerTypeTilemap:
+		return "tilemap"
+	}
+	return fmt.Sprintf("LayerType(%d)", uint16(layerType))
+}
+
+// CelType is how the content of a cel is stored
+type CelType uint16
+
+const (
+	// Uncompressed pixels, not written by Aseprite
+	CelTypeRaw               CelType = 0
+	CelTypeLinked            CelType = 1
+	CelTypeCompressedImage   CelType = 2
+	CelTypeCompressedTilemap CelType = 3
+)
+
+func (celType CelType) String() string {
+	switch celType {
+	case CelTypeRaw:
+		return "raw image"
+	case CelTypeLinked:
+		return "linked"
+	case CelTypeCompressedImage:
+		return "compressed image"
+	case CelTypeCompressedTilemap:
+		return "compressed tilemap"
+	}
+	return fmt.Sprintf("CelType(%d)", uint16(celType))
+}
+
+// BlendMode is how a layer is blended with the layers below it
+type BlendMode uint16
+
+const (
+	BlendNormal BlendMode = iota
+	BlendMultiply
+	BlendScreen
+	BlendOverlay
+	BlendDarken
+	BlendLighten
+	BlendColorDodge
+	BlendColorBurn
+	BlendHardLight
+	BlendSoftLight
+	BlendDifference
+	BlendExclusion
+	BlendHue
+	BlendSaturation
+	BlendColor
+	BlendLuminosity
+	BlendAddition
+	BlendSubtract
+	BlendDivide
+)
+
+var blendModeNames = []string{
+	"normal", "multiply", "screen", "overlay", "darken", "lighten", "color dodge", "color burn",
+	"hard light", "soft light", "difference", "exclusion", "hue", "saturation", "color",
+	"luminosity", "addition", "subtract", "divide",
+}
+
+func (blendMode BlendMode) String() string {
+	if int(blendMode) < len(blendModeNames) {
+		return blendModeNames[blendMode]
+	}
+	return fmt.Sprintf("BlendMode(%d)", uint16(blendMode))
+}
+
+// LoopDirection is the order the frames of a tag are played in
+type LoopDirection byte
+
+const (
+	LoopForward  LoopDirection = 0
+	LoopReverse  LoopDirection = 1
+	LoopPingPong LoopDirection = 2
+	// Ping-pong starting from the last frame, added in Aseprite 1.3
+	LoopPingPongReverse LoopDirection = 3
+)
+
+func (direction LoopDirection) String() string {
+	switch direction {
+	case LoopForward:
+		return "forward"
+	case LoopReverse:
+		return "reverse"
+	case LoopPingPong:
+		return "ping-pong"
+	case LoopPingPongReverse:
+		return "ping-pong reverse"
+	}
+	return fmt.Sprintf("LoopDirection(%d)", byte(direction))
+}
+
+// ColorProfileType is the color space the colors of a sprite are in
+type ColorProfileType uint16
+
+const (
+	// No profile, as in files from old versions of Aseprite, taken as sRGB
+	ColorProfileNone ColorProfileType = 0
+	ColorProfileSRGB ColorProfileType = 1
+	ColorProfileICC  ColorProfileType = 2
+)
+
+func (profileType ColorProfileType) String() string {
+	switch profileType {
+	case ColorProfileNone:
+		return "none"
+	case ColorProfileSRGB:
+		return "sRGB"
+	case ColorProfileICC:
+		return "ICC"
+	}
+	return fmt.Sprintf("ColorProfileType(%d)", uint16(profileType))
+}
+
+// ColorProfileFlags are the flags of a color profile chunk
+type ColorProfileFlags uint16
+
+const ColorProfileFlagFixedGamma ColorProfileFlags = 1
+
+// FixedGamma reports whether the FixedGamma of the profile replaces the sRGB
+// transfer curve
+func (flags ColorProfileFlags) FixedGamma() bool {
+	return flags&ColorProfileFlagFixedGamma != 0
+}
+
+func (flags ColorProfileFlags) String() string {
+	return flagNames(uint32(flags), []string{"fixed gamma"})
+}
@@ -0,0 +1,98 @@
+package asefile
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFlagsString(t *testing.T) {
+	for _, test := range []struct {
+		value fmt.Stringer
+		want  string
+	}{
+		{ColorModeIndexed, "indexed"},
+		{ColorModeGrayscale, "grayscale"},
+		{ColorModeRGBA, "RGBA"},
+		{ColorMode(24), "ColorMode(24)"},
+		{HeaderFlags(0), "none"},
+		{HeaderFlagLayerOpacityValid | HeaderFlagLayerUUIDs, "layer opacity valid|layer UUIDs"},
+		{HeaderFlagGroupOpacityValid | 0x30, "group opacity valid|0x30"},
+		{LayerFlagVisible | LayerFlagEditable, "visible|editable"},
+		{LayerFlagLockMovement | LayerFlagBackground | LayerFlagPreferLinkedCels, "lock movement|background|prefer linked cels"},
+		{LayerFlagCollapsed | LayerFlagReference, "collapsed|reference"},
+		{LayerFlags(0x8000), "0x8000"},
+		{TilesetFlagExternalFile | TilesetFlagTilesInFile | TilesetFlagZeroIsEmpty, "external file|tiles in file|zero is empty"},
+		{SliceFlagNinePatch | SliceFlagHasPivot, "9-patch|pivot"},
+		{SliceFlags(0), "none"},
+		{UserDataFlagHasText | UserDataFlagHasColor | UserDataFlagHasProperties, "text|color|properties"},
+		{UserDataFlagHasColor | 8, "color|0x8"},
+		{ColorProfileFlagFixedGamma, "fixed gamma"},
+		{PropertyTypeBool, "bool"},
+		{PropertyTypeFixed, "fixed"},
+		{PropertyTypeUUID, "UUID"},
+		{PropertyType(0), "PropertyType(0)"},
+		{PropertyType(0x14), "PropertyType(20)"},
+		{LayerTypeImage, "image"},
+		{LayerTypeGroup, "group"},
+		{LayerTypeTilemap, "tilemap"},
+		{LayerType(3), "LayerType(3)"},
+		{CelTypeRaw, "raw image"},
+		{CelTypeLinked, "linked"},
+		{CelTypeCompressedImage, "compressed image"},
+		{CelTypeCompressedTilemap, "compressed tilemap"},
+		{CelType(4), "CelType(4)"},
+		{BlendNormal, "normal"},
+		{BlendColorDodge, "color dodge"},
+		{BlendLuminosity, "luminosity"},
+		{BlendDivide, "divide"},
+		{BlendMode(19), "BlendMode(19)"},
+		{LoopForward, "forward"},
+		{LoopReverse, "reverse"},
+		{LoopPingPong, "ping-pong"},
+		{LoopPingPongReverse, "ping-pong reverse"},
+		{LoopDirection(4), "LoopDirection(4)"},
+		{ColorProfileNone, "none"},
+		{ColorProfileSRGB, "sRGB"},
+		{ColorProfileICC, "ICC"},
+		{ColorProfileType(3), "ColorProfileType(3)"},
+		{ChunkCelExtra, "cel extra"},
+		{ChunkType(0x7A01), "ChunkType(7A01)"},
+		{WarningSkippedChunk, "skipped chunk"},
+		{WarningKind(0), "WarningKind(0)"},
+	} {
+		if got := test.value.String(); got != test.want {
+			t.Errorf("%T(%d).String() = %q, want %q", test.value, test.value, got, test.want)
+		}
+	}
+}
+
+func TestFlagsAccessors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{"LayerOpacityValid", HeaderFlagLayerOpacityValid.LayerOpacityValid(), true},
+		{"GroupOpacityValid", HeaderFlagLayerOpacityValid.GroupOpacityValid(), false},
+		{"LayerUUIDs", HeaderFlags(7).LayerUUIDs(), true},
+		{"Visible", LayerFlagVisible.Visible(), true},
+		{"Editable", LayerFlagVisible.Editable(), false},
+		{"LockMovement", LayerFlagLockMovement.LockMovement(), true},
+		{"Background", (LayerFlagVisible | LayerFlagBackground).Background(), true},
+		{"PreferLinkedCels", LayerFlagPreferLinkedCels.PreferLinkedCels(), true},
+		{"Collapsed", LayerFlagReference.Collapsed(), false},
+		{"Reference", LayerFlagReference.Reference(), true},
+		{"ExternalFile", TilesetFlagTilesInFile.ExternalFile(), false},
+		{"TilesInFile", TilesetFlagTilesInFile.TilesInFile(), true},
+		{"ZeroIsEmpty", TilesetFlagZeroIsEmpty.ZeroIsEmpty(), true},
+		{"NinePatch", SliceFlagHasPivot.NinePatch(), false},
+		{"HasPivot", SliceFlagHasPivot.HasPivot(), true},
+		{"HasText", UserDataFlagHasText.HasText(), true},
+		{"HasColor", UserDataFlagHasText.HasColor(), false},
+		{"FixedGamma", ColorProfileFlagFixedGamma.FixedGamma(), true},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s is %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
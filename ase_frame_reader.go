@@ -0,0 +1,82 @@
+package asefile
+
+import (
+	"io"
+)
+
+/**
+ * FrameReader decodes a sprite one frame at a time, so long animations can be
+ * converted or exported with memory use bounded by a single frame rather than
+ * the whole file.
+ *
+ * Aseprite writes the layers and the palette in the first frame. The reader
+ * keeps those as shared context for the frames that follow; a palette chunk in
+ * a later frame replaces the shared palette from that frame on.
+ */
+type FrameReader struct {
+	Header AsepriteHeader
+	r      io.Reader
+	dec    *decoder
+	// Index of the frame Next returns
+	next    int
+	layers  []AsepriteLayerChunk2004
+	palette *AsepritePaletteChunk2019
+}
+
+// NewFrameReader reads the sprite header from r and returns a reader for its
+// frames, enforcing the limits in opts
+func NewFrameReader(r io.Reader, opts DecodeOptions) (*FrameReader, error) {
+	frameReader := &FrameReader{r: r, dec: &decoder{opts: opts}}
+	if err := frameReader.Header.Decode(r); err != nil {
+		return nil, err
+	}
+	if err := frameReader.dec.checkHeader(&frameReader.Header); err != nil {
+		return nil, err
+	}
+	return frameReader, nil
+}
+
+// Next decodes the next frame. It returns io.EOF once all the frames in the
+// header have been read.
+func (frameReader *FrameReader) Next() (*AsepriteFrame, error) {
+	if frameReader.next >= int(frameReader.Header.Frames) {
+		return nil, io.EOF
+	}
+	aseFrame := &AsepriteFrame{parentHeader: &frameReader.Header}
+	frameReader.dec.frame = frameReader.next
+	if err := aseFrame.decode(frameReader.dec, frameReader.r); err != nil {
+		return nil, err
+	}
+	if err := frameReader.dec.inflateQueued(); err != nil {
+		return nil, err
+	}
+	if frameReader.next == 0 {
+		frameReader.layers = aseFrame.Layers
+	}
+	if len(aseFrame.Palettes) > 0 {
+		frameReader.palette = &aseFrame.Palettes[len(aseFrame.Palettes)-1]
+	}
+	frameReader.next += 1
+	return aseFrame, nil
+}
+
+// FrameIndex returns the index of the frame the next call to Next returns
+func (frameReader *FrameReader) FrameIndex() int {
+	return frameReader.next
+}
+
+// Layers returns the layers of the sprite, known once the first frame is read
+func (frameReader *FrameReader) Layers() []AsepriteLayerChunk2004 {
+	return frameReader.layers
+}
+
+// Warnings returns the non-fatal issues found in the frames read so far
+func (frameReader *FrameReader) Warnings() []Warning {
+	return frameReader.dec.warnings
+}
+
+// Palette returns the palette in effect for the last frame read, or nil if no
+// frame so far had a palette chunk
+func (frameReader *FrameReader) Palette() *AsepritePaletteChunk2019 {
+	return frameReader.palette
+}
@@ -0,0 +1,62 @@
+package asefile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrameReader(t *testing.T) {
+	chica := readChica(t)
+	frames, err := NewFrameReader(bytes.NewReader(chica), DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames.Layers() != nil || frames.Palette() != nil {
+		t.Errorf("layers or palette known before the first frame")
+	}
+	streamed := &AsepriteFile{Header: frames.Header}
+	for {
+		index := frames.FrameIndex()
+		aseFrame, err := frames.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("frame %d: %v", index, err)
+		}
+		if index != len(streamed.Frames) {
+			t.Errorf("FrameIndex %d before frame %d", index, len(streamed.Frames))
+		}
+		if len(frames.Layers()) != 2 || frames.Palette() == nil {
+			t.Errorf("frame %d: %d layers and palette %v", index, len(frames.Layers()), frames.Palette())
+		}
+		streamed.Frames = append(streamed.Frames, *aseFrame)
+	}
+	if _, err := frames.Next(); err != io.EOF {
+		t.Errorf("Next after the last frame gave %v, want io.EOF", err)
+	}
+	if diff := Diff(decodeChica(t, DecodeOptions{}), streamed, EqualOptions{}); diff != "" {
+		t.Errorf("streamed frames differ from the decoded sprite:\n%s", diff)
+	}
+}
+
+func TestFrameReaderErrors(t *testing.T) {
+	chica := readChica(t)
+	if _, err := NewFrameReader(bytes.NewReader(chica[:64]), DecodeOptions{}); err == nil {
+		t.Errorf("short header: no error")
+	}
+	if _, err := NewFrameReader(bytes.NewReader(chica), DecodeOptions{MaxFrames: 2}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("frame limit: got %v, want ErrLimitExceeded", err)
+	}
+	frames, err := NewFrameReader(bytes.NewReader(chica[:len(chica)/2]), DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = frames.Next()
+	}
+	if err == io.EOF || frames.FrameIndex() == 0 {
+		t.Errorf("truncated sprite read %d frames and stopped with %v", frames.FrameIndex(), err)
+	}
+}
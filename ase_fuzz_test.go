@@ -0,0 +1,154 @@
+//go:build go1.18
+
+package asefile
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Limits applied while fuzzing so that valid but huge inputs don't exhaust memory
+var fuzzDecodeOptions = DecodeOptions{
+	MaxWidth:             1024,
+	MaxHeight:            1024,
+	MaxFrames:            256,
+	MaxLayers:            256,
+	MaxChunkSize:         1 << 20,
+	MaxDecompressedBytes: 1 << 20,
+}
+
+func FuzzDecode(f *testing.F) {
+	chica := readChica(f)
+	f.Add(chica)
+	f.Add(chica[:128])
+	f.Add(chica[:len(chica)/2])
+	f.Add([]byte{})
+	f.Add(edgeCaseSprite(0, 0x2004, nil))
+	f.Add(edgeCaseSprite(0xFFFFFFFF, 0x2005, nil))
+	f.Add(edgeCaseSprite(6+4, 0x2019, []byte{0xFF, 0xFF, 0xFF, 0xFF}))
+	f.Add(edgeCaseSprite(6+2, 0x0011, []byte{0xFF, 0xFF}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var aseFile AsepriteFile
+		aseFile.DecodeWithOptions(bytes.NewReader(data), fuzzDecodeOptions)
+		recoverOptions := fuzzDecodeOptions
+		recoverOptions.Recover = true
+		aseFile.DecodeWithOptions(bytes.NewReader(data), recoverOptions)
+	})
+}
+
+// FuzzRoundTrip checks that whatever decodes also encodes and decodes again,
+// and that encoding is stable from then on. Cels are written raw, as zlib
+// compression under coverage instrumentation slows fuzzing to a crawl.
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(readChica(f))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var aseFile AsepriteFile
+		if err := aseFile.DecodeWithOptions(bytes.NewReader(data), fuzzDecodeOptions); err != nil {
+			return
+		}
+		var first, second bytes.Buffer
+		if err := aseFile.EncodeWithOptions(&first, EncodeOptions{RawCels: true}); err != nil {
+			t.Fatal(err)
+		}
+		var decoded AsepriteFile
+		if err := decoded.Decode(bytes.NewReader(first.Bytes())); err != nil {
+			t.Fatalf("decoding an encoded sprite: %v", err)
+		}
+		if err := decoded.EncodeWithOptions(&second, EncodeOptions{RawCels: true}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("encoding is not stable: %d bytes then %d bytes", first.Len(), second.Len())
+		}
+	})
+}
+
+// fuzzChunk fuzzes the decoder of one chunk type, seeded with the chunks of
+// that type in the example sprite. Decoded chunks must encode and decode again.
+func fuzzChunk(f *testing.F, chunkType uint16, newChunk func() AsepriteCodec) {
+	for _, chunkDat := range chicaChunks(f)[chunkType] {
+		f.Add(chunkDat)
+	}
+	f.Add([]byte{})
+	f.Add(bytes.Repeat([]byte{0xFF}, 64))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		chunk := newChunk()
+		if err := chunk.Decode(bytes.NewReader(data)); err != nil {
+			return
+		}
+		var encoded bytes.Buffer
+		chunk.Encode(&encoded)
+		if err := newChunk().Decode(bytes.NewReader(encoded.Bytes())); err != nil {
+			t.Fatalf("decoding an encoded chunk: %v", err)
+		}
+	})
+}
+
+func FuzzOldPaletteChunk0004(f *testing.F) {
+	fuzzChunk(f, 0x0004, func() AsepriteCodec { return &AsepriteOldPaletteChunk0004{} })
+}
+
+func FuzzPaletteChunk0011(f *testing.F) {
+	f.Add([]byte{0x02, 0x00, 0x00, 0x01, 0x3F, 0x3F, 0x3F})
+	fuzzChunk(f, 0x0011, func() AsepriteCodec { return &AsepritePaletteChunk0011{} })
+}
+
+func FuzzLayerChunk2004(f *testing.F) {
+	fuzzChunk(f, 0x2004, func() AsepriteCodec { return &AsepriteLayerChunk2004{} })
+}
+
+func FuzzCelChunk2005(f *testing.F) {
+	fuzzChunk(f, 0x2005, func() AsepriteCodec {
+		return &limitedCel{}
+	})
+}
+
+// limitedCel is a cel decoded with the fuzzing limits
+type limitedCel struct {
+	AsepriteCelChunk2005
+}
+
+func (cel *limitedCel) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return cel.decodeWith(&decoder{opts: fuzzDecodeOptions}, fr)
+}
+
+func FuzzCelExtraChunk2006(f *testing.F) {
+	fuzzChunk(f, 0x2006, func() AsepriteCodec { return &AsepriteCelExtraChunk2006{} })
+}
+
+func FuzzColorProfileChunk2007(f *testing.F) {
+	fuzzChunk(f, 0x2007, func() AsepriteCodec { return &AsepriteColorProfileChunk2007{} })
+}
+
+func FuzzExternalFilesChunk2008(f *testing.F) {
+	fuzzChunk(f, 0x2008, func() AsepriteCodec { return &AsepriteExternalFilesChunk2008{} })
+}
+
+func FuzzMaskChunk2016(f *testing.F) {
+	fuzzChunk(f, 0x2016, func() AsepriteCodec { return &AsepriteMaskChunk2016{} })
+}
+
+func FuzzTagsChunk2018(f *testing.F) {
+	fuzzChunk(f, 0x2018, func() AsepriteCodec { return &AsepriteTagsChunk2018{} })
+}
+
+func FuzzPaletteChunk2019(f *testing.F) {
+	fuzzChunk(f, 0x2019, func() AsepriteCodec { return &AsepritePaletteChunk2019{} })
+}
+
+func FuzzUserDataChunk2020(f *testing.F) {
+	fuzzChunk(f, 0x2020, func() AsepriteCodec { return &AsepriteUserDataChunk2020{} })
+}
+
+func FuzzSliceChunk2022(f *testing.F) {
+	fuzzChunk(f, 0x2022, func() AsepriteCodec { return &AsepriteSliceChunk2022{} })
+}
+
+func FuzzTilesetChunk2023(f *testing.F) {
+	fuzzChunk(f, 0x2023, func() AsepriteCodec { return &AsepriteTilesetChunk2023{} })
+}
@@ -65,8 +65,8 @@ type AsepriteHeader struct {
 	Frames         uint16
 	WidthInPixels  uint16
 	HeightInPixels uint16
-	ColorDepth     uint16
-	Flags          uint32
+	ColorDepth     ColorMode
+	Flags          HeaderFlags
 	Speed          uint16 // deprecated, use frame duration from frame header
 	// These are 0
 	ignore1, ignore2 uint32
@@ -83,6 +83,25 @@ type AsepriteHeader struct {
 	reserved [84]byte
 }
 
+// PixelRatio returns the width and height of a pixel, eg 2 and 1 for pixels
+// twice as wide as they are high, in lowest terms so that 2:2 is 1:1. Either
+// field being zero means 1:1.
+func (aseHeader *AsepriteHeader) PixelRatio() (width, height int) {
+	if aseHeader.PixelWidth == 0 || aseHeader.PixelHeight == 0 {
+		return 1, 1
+	}
+	width, height = int(aseHeader.PixelWidth), int(aseHeader.PixelHeight)
+	divisor := gcd(width, height)
+	return width / divisor, height / divisor
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
 /**
  * DWORD     Bytes in this frame
  * WORD      Magic number (always 0xF1FA)
@@ -109,9 +128,16 @@ type AsepriteFrame struct {
 	Layers                    []AsepriteLayerChunk2004
 	Cels                      []AsepriteCelChunk2005
 	ColorProfiles             []AsepriteColorProfileChunk2007
+	ExternalFiles             []AsepriteExternalFilesChunk2008
 	Tags                      AsepriteTagsChunk2018
 	Palettes                  []AsepritePaletteChunk2019
 	Slices                    []AsepriteSliceChunk2022
+	Tilesets                  []AsepriteTilesetChunk2023
+	// User data of the whole sprite, written after the palette of the first
+	// frame by Aseprite 1.3
+	SpriteUserData AsepriteUserDataChunk2020
+	// Chunks of the types added with RegisterChunk, in file order
+	CustomChunks []Chunk
 }
 
 /**
@@ -209,12 +235,12 @@ type AsepritePaletteChunk0011Packet struct {
  */
 
 type AsepriteLayerChunk2004 struct {
-	Flags                uint16
-	LayerType            uint16
+	Flags                LayerFlags
+	LayerType            LayerType
 	LayerChildLevel      uint16
 	DefLayerWidthPixels  uint16 // (ignored)
 	DefLayerHeightPixels uint16 // (ignored)
-	BlendMode            uint16
+	BlendMode            BlendMode
 	Opacity              byte // only valid if file headre flag field has bit 1 set
 	forFuture            [3]byte
 	LayerName            string
@@ -271,7 +297,7 @@ type AsepriteCelChunk2005 struct {
 	LayerIndex   uint16
 	X, Y         int16
 	OpacityLevel byte
-	CelType      uint16
+	CelType      CelType
 	future       [7]byte
 	// + For cel type = 0 (Raw Image Data)
 	WidthInPix, HeightInPix uint16
@@ -292,6 +318,11 @@ type AsepriteCelChunk2005 struct {
 	reserved                    [10]byte
 	Tiles                       []byte // zlib data (see NOTE.3)
 	Extra                       *AsepriteCelExtraChunk2006
+	// zlib data kept by DecodeOptions.LazyCels until Pixels inflates it
+	compressed  []byte
+	inflateSize int64
+	budget      *inflateBudget
+	budgeted    bool
 }
 
 /**
@@ -308,9 +339,9 @@ type AsepriteCelChunk2005 struct {
  */
 type AsepriteCelExtraChunk2006 struct {
 	Flags              uint32
-	PreciseX, PreciseY uint32
-	WidthCelInSprite   uint32
-	HeightCelInSprite  uint32
+	PreciseX, PreciseY Fixed
+	WidthCelInSprite   Fixed
+	HeightCelInSprite  Fixed
 	futureUse          [16]byte
 }
 
@@ -336,9 +367,9 @@ type AsepriteCelExtraChunk2006 struct {
  */
 
 type AsepriteColorProfileChunk2007 struct {
-	Type       uint16
-	Flags      uint16
-	FixedGamma uint32
+	Type       ColorProfileType
+	Flags      ColorProfileFlags
+	FixedGamma Fixed
 	reserved   [8]byte
 	// + If type = ICC:
 	ICCProfileDatLen uint32
@@ -433,7 +464,7 @@ func (tagsChunk *AsepriteTagsChunk2018) AddUserData(userDat AsepriteUserDataChun
 
 type AsepriteTagsChunk2018Tag struct {
 	FromFrame, ToFrame uint16
-	LoopAnimDirection  byte
+	LoopAnimDirection  LoopDirection
 	reserved2          [8]byte
 	TagColor           [3]byte // deprecated
 	ExtraByte          byte    // (zero)
@@ -479,7 +510,7 @@ type AsepritePaletteChunk2019Entry struct {
  * Insert this user data in the last read chunk. E.g. If we've read a layer, this user data belongs to that layer, if we've read a cel, it belongs to that cel, etc. There are some special cases: After a Tags chunk, there will be several user data fields, one for each tag, you should associate the user data in the same order as the tags are in the Tags chunk. In version 1.3 a sprite has associated user data, to consider this case there is an User Data Chunk at the first frame after the Palette Chunk.
  *
  * DWORD  Flags
- *         1 = Has text  2 = Has color
+ *         1 = Has text  2 = Has color  4 = Has properties
  *  + If flags have bit 1
  *    STRING    Text
  *  + If flags have bit 2
@@ -487,14 +518,26 @@ type AsepritePaletteChunk2019Entry struct {
  *    BYTE  Color Green (0-255)
  *    BYTE  Color Blue (0-255)
  *    BYTE  Color Alpha (0-255)
+ *  + If flags have bit 4
+ *    DWORD Size in bytes of all properties maps, this field included
+ *    DWORD Number of properties maps
+ *    + For each properties map
+ *      DWORD   Key, 0 for user properties or the entry ID of an extension
+ *      DWORD   Number of properties
+ *      + For each property
+ *        STRING  Name
+ *        WORD    Type
+ *        BYTE[]  Value, see PropertyValue
  */
 
 type AsepriteUserDataChunk2020 struct {
-	Flags uint32
+	Flags UserDataFlags
 	// + If flags have bit 1
 	Text string
 	// + If flags have bit 2
 	R, G, B, A byte
+	// + If flags have bit 4
+	Properties []PropertiesMap
 }
 
 /**
@@ -526,7 +569,7 @@ type AsepriteUserDataChunk2020 struct {
 
 type AsepriteSliceChunk2022 struct {
 	NumSliceKeys uint32
-	Flags        uint32
+	Flags        SliceFlags
 	reserved     uint32
 	Name         string
 	// + For each slice key
@@ -585,7 +628,7 @@ type AsepriteSliceChunk2022Data struct {
 
 type AsepriteTilesetChunk2023 struct {
 	TilesetID             uint32
-	Flags                 uint32
+	Flags                 TilesetFlags
 	NumTiles              uint32
 	TileWidth, TileHeight uint16
 	BaseIndex             int16
@@ -597,6 +640,11 @@ type AsepriteTilesetChunk2023 struct {
 	// + If flag 2 is set
 	CompressedDatLen     uint32
 	CompressedTilesetImg []byte
+	UserData             AsepriteUserDataChunk2020
+}
+
+func (tileset *AsepriteTilesetChunk2023) AddUserData(userDat AsepriteUserDataChunk2020) {
+	tileset.UserData = userDat
 }
 
 /**
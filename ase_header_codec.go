@@ -4,9 +4,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"encoding/binary"
-	"fmt"
 	"io"
-	"log"
 )
 
 type AsepriteCodec interface {
@@ -18,6 +16,13 @@ type AsepriteUserDatHolder interface {
 	AddUserData(AsepriteUserDataChunk2020)
 }
 
+// userDataFunc is a holder for user data that is not kept on a chunk
+type userDataFunc func(AsepriteUserDataChunk2020)
+
+func (holder userDataFunc) AddUserData(userDat AsepriteUserDataChunk2020) {
+	holder(userDat)
+}
+
 var ble = binary.LittleEndian
 
 func DecodeAseString(r io.Reader) string {
@@ -36,31 +41,36 @@ func EncodeAseString(w io.Writer, str string) {
 }
 
 func (aseHeader *AsepriteHeader) Decode(r io.Reader) error {
-	binary.Read(r, ble, &aseHeader.FileSize)
-	binary.Read(r, ble, &aseHeader.MagicNumber)
+	var headerDat [128]byte
+	if _, err := io.ReadFull(r, headerDat[:]); err != nil {
+		return truncated(err)
+	}
+	fr := fieldReader{headerDat[:]}
+	aseHeader.FileSize = fr.uint32()
+	aseHeader.MagicNumber = fr.uint16()
 
 	if aseHeader.MagicNumber != 0xA5E0 {
-		return fmt.Errorf("header magic number incorrect")
-	}
-
-	binary.Read(r, ble, &aseHeader.Frames)
-	binary.Read(r, ble, &aseHeader.WidthInPixels)
-	binary.Read(r, ble, &aseHeader.HeightInPixels)
-	binary.Read(r, ble, &aseHeader.ColorDepth)
-	binary.Read(r, ble, &aseHeader.Flags)
-	binary.Read(r, ble, &aseHeader.Speed)
-	binary.Read(r, ble, &aseHeader.ignore1)
-	binary.Read(r, ble, &aseHeader.ignore2)
-	binary.Read(r, ble, &aseHeader.PaletteEntry)
-	binary.Read(r, ble, &aseHeader.ignore3)
-	binary.Read(r, ble, &aseHeader.NumberOfColors)
-	binary.Read(r, ble, &aseHeader.PixelWidth)
-	binary.Read(r, ble, &aseHeader.PixelHeight)
-	binary.Read(r, ble, &aseHeader.XPositionOfGrid)
-	binary.Read(r, ble, &aseHeader.YPositionOfGrid)
-	binary.Read(r, ble, &aseHeader.GridWidth)
-	binary.Read(r, ble, &aseHeader.GridHeight)
-	binary.Read(r, ble, &aseHeader.reserved)
+		return FormatError("header magic number incorrect")
+	}
+
+	aseHeader.Frames = fr.uint16()
+	aseHeader.WidthInPixels = fr.uint16()
+	aseHeader.HeightInPixels = fr.uint16()
+	aseHeader.ColorDepth = ColorMode(fr.uint16())
+	aseHeader.Flags = HeaderFlags(fr.uint32())
+	aseHeader.Speed = fr.uint16()
+	aseHeader.ignore1 = fr.uint32()
+	aseHeader.ignore2 = fr.uint32()
+	aseHeader.PaletteEntry = fr.uint8()
+	fr.skip(aseHeader.ignore3[:])
+	aseHeader.NumberOfColors = fr.uint16()
+	aseHeader.PixelWidth = fr.uint8()
+	aseHeader.PixelHeight = fr.uint8()
+	aseHeader.XPositionOfGrid = fr.int16()
+	aseHeader.YPositionOfGrid = fr.int16()
+	aseHeader.GridWidth = fr.uint16()
+	aseHeader.GridHeight = fr.uint16()
+	fr.skip(aseHeader.reserved[:])
 	return nil
 }
 
@@ -88,17 +98,28 @@ func (aseHeader *AsepriteHeader) Encode(w io.Writer) {
 }
 
 func (aseFrame *AsepriteFrame) Decode(r io.Reader) error {
-	binary.Read(r, ble, &aseFrame.BytesThisFrame)
-	binary.Read(r, ble, &aseFrame.MagicNumber)
+	return aseFrame.decode(&decoder{}, r)
+}
+
+// decode reads the frame with the options and running totals of dec
+func (aseFrame *AsepriteFrame) decode(dec *decoder, r io.Reader) error {
+	frameDat := dec.scratch[:16]
+	if _, err := io.ReadFull(r, frameDat); err != nil {
+		return truncated(err)
+	}
+	fr := fieldReader{frameDat}
+	aseFrame.BytesThisFrame = fr.uint32()
+	aseFrame.MagicNumber = fr.uint16()
 
 	if aseFrame.MagicNumber != 0xF1FA {
-		return fmt.Errorf("frame magic number incorrect")
+		return FormatError("frame magic number incorrect")
 	}
 
-	binary.Read(r, ble, &aseFrame.ChunksThisFrame)
-	binary.Read(r, ble, &aseFrame.FrameDurationMilliseconds)
-	binary.Read(r, ble, &aseFrame.reserved)
-	binary.Read(r, ble, &aseFrame.ChunksThisFrameExt)
+	aseFrame.ChunksThisFrame = fr.uint16()
+	aseFrame.FrameDurationMilliseconds = fr.uint16()
+	fr.skip(aseFrame.reserved[:])
+	aseFrame.ChunksThisFrameExt = fr.uint32()
+	dec.advance(16)
 	//
 	// Load n-amount of chunks
 	aseFrame.OldPalettes0004 = make([]AsepriteOldPaletteChunk0004, 0)
@@ -106,6 +127,7 @@ func (aseFrame *AsepriteFrame) Decode(r io.Reader) error {
 	aseFrame.Layers = make([]AsepriteLayerChunk2004, 0)
 	aseFrame.Cels = make([]AsepriteCelChunk2005, 0)
 	aseFrame.ColorProfiles = make([]AsepriteColorProfileChunk2007, 0)
+	aseFrame.ExternalFiles = make([]AsepriteExternalFilesChunk2008, 0)
 	aseFrame.Palettes = make([]AsepritePaletteChunk2019, 0)
 	aseFrame.Slices = make([]AsepriteSliceChunk2022, 0)
 
@@ -114,100 +136,424 @@ func (aseFrame *AsepriteFrame) Decode(r io.Reader) error {
 		loadChunks = int(aseFrame.ChunksThisFrame)
 	} else {
 		loadChunks = int(aseFrame.ChunksThisFrameExt)
+		if aseFrame.ChunksThisFrame != 0xFFFF && uint32(aseFrame.ChunksThisFrame) != aseFrame.ChunksThisFrameExt {
+			err := dec.warn(WarningCountMismatch, 0, "frame header gives %d and %d chunks",
+				aseFrame.ChunksThisFrame, aseFrame.ChunksThisFrameExt)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	var lastUserdatHolder AsepriteUserDatHolder
+	dec.extraCel = -1
 	read := 0
+	// In recovery mode a damaged chunk is skipped by its size, while a chunk
+	// whose size cannot be trusted ends the frame with what was read so far
 	for x := 0; x < loadChunks; x += 1 {
-		var chunkSize uint32
-		var chunkType uint16
-		binary.Read(r, ble, &chunkSize)
-		binary.Read(r, ble, &chunkType)
+		if err := dec.checkContext(); err != nil {
+			return err
+		}
+		chunkHeader := dec.scratch[:6]
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			if err = truncated(err); dec.recovering(err) {
+				dec.damagedChunk(x, 0, err)
+				break
+			}
+			return err
+		}
+		chunkSize := ble.Uint32(chunkHeader[0:])
+		chunkType := ChunkType(ble.Uint16(chunkHeader[4:]))
+		dec.chunkBuf.Reset()
+		if chunkType == ChunkCel {
+			action, err := dec.filterCel(r, chunkSize)
+			if err != nil {
+				if dec.recovering(err) {
+					dec.damagedChunk(x, chunkType, err)
+					break
+				}
+				return err
+			}
+			if action == celSkip {
+				dec.extraCel = -1
+				dec.advance(int(chunkSize))
+				read += 1
+				continue
+			}
+			dec.cel = action
+		}
+		chunkDat, err := dec.readChunk(r, chunkSize)
+		if err != nil {
+			if dec.recovering(err) {
+				dec.damagedChunk(x, chunkType, err)
+				break
+			}
+			return err
+		}
+		cr := &fieldReader{chunkDat}
+		dec.advance(int(chunkSize))
 
-		switch chunkType {
-		case 0x0004:
-			var oldPalette0004 AsepriteOldPaletteChunk0004
-			oldPalette0004.Decode(r)
-			aseFrame.OldPalettes0004 = append(aseFrame.OldPalettes0004, oldPalette0004)
-			read += 1
-		case 0x0011:
-			var oldPalette0011 AsepritePaletteChunk0011
-			oldPalette0011.Decode(r)
-			aseFrame.OldPalettes0011 = append(aseFrame.OldPalettes0011, oldPalette0011)
-			read += 1
-		case 0x2004:
-			var layer AsepriteLayerChunk2004
-			layer.Decode(r)
-			aseFrame.Layers = append(aseFrame.Layers, layer)
-			lastUserdatHolder = &aseFrame.Layers[len(aseFrame.Layers)-1]
-			read += 1
-		case 0x2005:
-			var cel AsepriteCelChunk2005
-			cel.parentHeader = aseFrame.parentHeader
-			cel.chunkSize = chunkSize
-			cel.Decode(r)
-			aseFrame.Cels = append(aseFrame.Cels, cel)
-			read += 1
-		case 0x2007:
-			var colProfile AsepriteColorProfileChunk2007
-			colProfile.Decode(r)
-			aseFrame.ColorProfiles = append(aseFrame.ColorProfiles, colProfile)
-			read += 1
-		case 0x2018:
-			aseFrame.Tags.Decode(r)
-			lastUserdatHolder = &aseFrame.Tags
-			read += 1
-		case 0x2019:
-			var palette AsepritePaletteChunk2019
-			palette.Decode(r)
-			aseFrame.Palettes = append(aseFrame.Palettes, palette)
-			read += 1
-		case 0x2020:
-			var userDat AsepriteUserDataChunk2020
-			userDat.Decode(r)
-			if lastUserdatHolder != nil {
-				lastUserdatHolder.AddUserData(userDat)
+		known, err := aseFrame.decodeChunk(dec, chunkType, chunkSize, cr, &lastUserdatHolder)
+		if err != nil {
+			if !dec.recovering(err) {
+				return err
 			}
+			dec.damagedChunk(x, chunkType, err)
+		}
+		if known {
 			read += 1
-		case 0x2022:
-			var sliceDat AsepriteSliceChunk2022
-			sliceDat.Decode(r)
-			aseFrame.Slices = append(aseFrame.Slices, sliceDat)
-			lastUserdatHolder = &aseFrame.Slices[len(aseFrame.Slices)-1]
-			read += 1
-		default:
-			log.Printf("Unused chunk type: %X\n", chunkType)
 		}
 	}
-	if read != loadChunks {
-		return fmt.Errorf("did not read expected amount of chunks")
+	if read != loadChunks && !dec.opts.Recover {
+		return FormatError("did not read expected amount of chunks")
+	}
+	if dec.filtering() {
+		if err := dec.resolveFilter(); err != nil {
+			return err
+		}
+	}
+	if dec.concurrent() && dec.frame >= dec.fromFrame {
+		for x := range aseFrame.Cels {
+			if err := dec.queue(&aseFrame.Cels[x]); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// decodeChunk decodes a chunk of the frame and reports whether it counts
+// towards the number of chunks in the frame, which every chunk read does,
+// even one that fails to decode or is skipped with a warning
+func (aseFrame *AsepriteFrame) decodeChunk(dec *decoder, chunkType ChunkType, chunkSize uint32, cr *fieldReader, lastUserdatHolder *AsepriteUserDatHolder) (bool, error) {
+	extraCel := dec.extraCel
+	dec.extraCel = -1
+	if factory := registeredChunk(chunkType); factory != nil {
+		return true, aseFrame.decodeCustomChunk(factory, cr, lastUserdatHolder)
+	}
+	switch chunkType {
+	case ChunkOldPalette0004:
+		var oldPalette0004 AsepriteOldPaletteChunk0004
+		if err := oldPalette0004.decodeFields(cr); err != nil {
+			return true, err
+		}
+		aseFrame.OldPalettes0004 = append(aseFrame.OldPalettes0004, oldPalette0004)
+	case ChunkOldPalette0011:
+		var oldPalette0011 AsepritePaletteChunk0011
+		if err := oldPalette0011.decodeFields(cr); err != nil {
+			return true, err
+		}
+		aseFrame.OldPalettes0011 = append(aseFrame.OldPalettes0011, oldPalette0011)
+	case ChunkLayer:
+		var layer AsepriteLayerChunk2004
+		if err := layer.decodeFields(cr); err != nil {
+			return true, err
+		}
+		if err := dec.addLayer(&layer); err != nil {
+			return true, err
+		}
+		if err := dec.checkString(layer.LayerName); err != nil {
+			return true, err
+		}
+		aseFrame.Layers = append(aseFrame.Layers, layer)
+		*lastUserdatHolder = &aseFrame.Layers[len(aseFrame.Layers)-1]
+	case ChunkCel:
+		var cel AsepriteCelChunk2005
+		cel.parentHeader = aseFrame.parentHeader
+		cel.chunkSize = chunkSize
+		if err := cel.decodeWith(dec, cr); err != nil {
+			return true, err
+		}
+		aseFrame.Cels = append(aseFrame.Cels, cel)
+		dec.extraCel = len(aseFrame.Cels) - 1
+	case ChunkCelExtra:
+		var extra AsepriteCelExtraChunk2006
+		if err := extra.decodeFields(cr); err != nil {
+			return true, err
+		}
+		if extraCel < 0 {
+			return true, dec.warn(WarningSkippedChunk, chunkType, "%v chunk does not follow a cel", chunkType)
+		}
+		aseFrame.Cels[extraCel].Extra = &extra
+	case ChunkColorProfile:
+		var colProfile AsepriteColorProfileChunk2007
+		if err := colProfile.decodeFields(cr); err != nil {
+			return true, err
+		}
+		aseFrame.ColorProfiles = append(aseFrame.ColorProfiles, colProfile)
+	case ChunkExternalFiles:
+		var extFiles AsepriteExternalFilesChunk2008
+		if err := extFiles.decodeFields(cr); err != nil {
+			return true, err
+		}
+		for _, file := range extFiles.ExternalFile {
+			if err := dec.checkString(file.ExternalFilename); err != nil {
+				return true, err
+			}
+		}
+		aseFrame.ExternalFiles = append(aseFrame.ExternalFiles, extFiles)
+	case ChunkTags:
+		if err := aseFrame.Tags.decodeFields(cr); err != nil {
+			return true, err
+		}
+		for _, tag := range aseFrame.Tags.Tags {
+			if err := dec.checkString(tag.TagName); err != nil {
+				return true, err
+			}
+		}
+		dec.tags = append(dec.tags, aseFrame.Tags.Tags...)
+		*lastUserdatHolder = &aseFrame.Tags
+	case ChunkPalette:
+		var palette AsepritePaletteChunk2019
+		if err := palette.decodeFields(cr); err != nil {
+			return true, err
+		}
+		for _, paletteEntry := range palette.PaletteEntries {
+			if err := dec.checkString(paletteEntry.ColorName); err != nil {
+				return true, err
+			}
+		}
+		if palette.PaletteSize < palette.LastColIndexToChange+1 {
+			err := dec.warn(WarningCountMismatch, chunkType, "palette of %d colors changes color %d",
+				palette.PaletteSize, palette.LastColIndexToChange)
+			if err != nil {
+				return true, err
+			}
+		}
+		aseFrame.Palettes = append(aseFrame.Palettes, palette)
+		if dec.frame == 0 {
+			*lastUserdatHolder = userDataFunc(func(userDat AsepriteUserDataChunk2020) {
+				aseFrame.SpriteUserData = userDat
+			})
+		}
+	case ChunkUserData:
+		var userDat AsepriteUserDataChunk2020
+		if err := userDat.decodeFields(cr); err != nil {
+			return true, err
+		}
+		if err := dec.checkString(userDat.Text); err != nil {
+			return true, err
+		}
+		if *lastUserdatHolder != nil {
+			(*lastUserdatHolder).AddUserData(userDat)
+		}
+	case ChunkSlice:
+		var sliceDat AsepriteSliceChunk2022
+		if err := sliceDat.decodeFields(cr); err != nil {
+			return true, err
+		}
+		if err := dec.checkString(sliceDat.Name); err != nil {
+			return true, err
+		}
+		aseFrame.Slices = append(aseFrame.Slices, sliceDat)
+		*lastUserdatHolder = &aseFrame.Slices[len(aseFrame.Slices)-1]
+	case ChunkTileset:
+		var tileset AsepriteTilesetChunk2023
+		if err := tileset.decodeFields(cr); err != nil {
+			return true, err
+		}
+		if err := dec.checkString(tileset.Name); err != nil {
+			return true, err
+		}
+		aseFrame.Tilesets = append(aseFrame.Tilesets, tileset)
+		*lastUserdatHolder = &aseFrame.Tilesets[len(aseFrame.Tilesets)-1]
+	default:
+		switch chunkType {
+		case ChunkMask, ChunkPath:
+			return true, dec.warn(WarningDeprecatedChunk, chunkType, "deprecated %v chunk ignored", chunkType)
+		}
+		return true, dec.warn(WarningUnknownChunk, chunkType, "%v chunk is not decoded", chunkType)
+	}
+	return true, nil
+}
+
 func (aseFrame AsepriteFrame) Encode(w io.Writer) {
+	aseFrame.encode(w, newDefaultEncoder())
+}
+
+func (aseFrame *AsepriteFrame) encode(w io.Writer, enc *encoder) {
+	var chunks bytes.Buffer
+	aseFrame.setChunkCounts(aseFrame.encodeChunks(&chunks, enc))
+	aseFrame.BytesThisFrame = uint32(16 + chunks.Len())
+	reserved := aseFrame.reserved
+	if enc.opts.Deterministic {
+		reserved = [2]byte{}
+	}
+
 	binary.Write(w, ble, &aseFrame.BytesThisFrame)
 	binary.Write(w, ble, &aseFrame.MagicNumber)
 	binary.Write(w, ble, &aseFrame.ChunksThisFrame)
 	binary.Write(w, ble, &aseFrame.FrameDurationMilliseconds)
-	binary.Write(w, ble, &aseFrame.reserved)
+	binary.Write(w, ble, &reserved)
 	binary.Write(w, ble, &aseFrame.ChunksThisFrameExt)
 	//
 	// Write n-amount of chunks
+	w.Write(chunks.Bytes())
+}
+
+// setChunkCounts sets the magic number and both chunk count fields of the
+// frame header
+func (aseFrame *AsepriteFrame) setChunkCounts(numChunks int) {
+	aseFrame.MagicNumber = 0xF1FA
+	if numChunks < 0xFFFF {
+		aseFrame.ChunksThisFrame = uint16(numChunks)
+	} else {
+		aseFrame.ChunksThisFrame = 0xFFFF
+	}
+	aseFrame.ChunksThisFrameExt = uint32(numChunks)
+}
+
+// encodeChunks writes every chunk of the frame in the order Aseprite saves them,
+// each followed by its user data: the sprite wide chunks up to the layers, then
+// the tags and slices, then the cels, and returns the number of chunks written
+func (aseFrame *AsepriteFrame) encodeChunks(w io.Writer, enc *encoder) int {
+	written := 0
+	chunk := func(chunkType ChunkType, codec interface{ Encode(io.Writer) }) {
+		if enc.checkContext() != nil {
+			return
+		}
+		written += 1
+		if !enc.countOnly {
+			encodeChunk(w, chunkType, codec)
+		}
+	}
+	userData := func(userDat AsepriteUserDataChunk2020) {
+		if userDat.Flags != 0 {
+			chunk(ChunkUserData, userDat)
+		}
+	}
+	zero := enc.opts.Deterministic
+	for _, colProfile := range aseFrame.ColorProfiles {
+		if zero {
+			colProfile.reserved = [8]byte{}
+		}
+		chunk(ChunkColorProfile, colProfile)
+	}
+	for _, extFiles := range aseFrame.ExternalFiles {
+		if zero {
+			extFiles.reserved = [8]byte{}
+			extFiles.ExternalFile = append([]AsepriteExternalFilesChunk2008Entry(nil), extFiles.ExternalFile...)
+			for x := range extFiles.ExternalFile {
+				extFiles.ExternalFile[x].reserved = [8]byte{}
+			}
+		}
+		chunk(ChunkExternalFiles, &extFiles)
+	}
+	for _, palette := range aseFrame.Palettes {
+		if zero {
+			palette.reserved = [8]byte{}
+		}
+		chunk(ChunkPalette, palette)
+	}
+	for _, oldPalette0004 := range aseFrame.OldPalettes0004 {
+		chunk(ChunkOldPalette0004, oldPalette0004)
+	}
+	for _, oldPalette0011 := range aseFrame.OldPalettes0011 {
+		chunk(ChunkOldPalette0011, oldPalette0011)
+	}
+	if len(aseFrame.Palettes) > 0 {
+		userData(aseFrame.SpriteUserData)
+	}
+	for _, tileset := range aseFrame.Tilesets {
+		if zero {
+			tileset.reserved = [14]byte{}
+		}
+		chunk(ChunkTileset, &tileset)
+		userData(tileset.UserData)
+	}
+	for _, layer := range aseFrame.Layers {
+		if zero {
+			layer.forFuture = [3]byte{}
+		}
+		chunk(ChunkLayer, layer)
+		userData(layer.UserData)
+	}
+	if len(aseFrame.Tags.Tags) > 0 {
+		tags := aseFrame.Tags
+		if zero {
+			tags.reserved1 = [8]byte{}
+			tags.Tags = append([]AsepriteTagsChunk2018Tag(nil), tags.Tags...)
+			for x := range tags.Tags {
+				tags.Tags[x].reserved2 = [8]byte{}
+				tags.Tags[x].ExtraByte = 0
+			}
+		}
+		chunk(ChunkTags, tags)
+		for _, userDat := range tags.UserData {
+			chunk(ChunkUserData, userDat)
+		}
+	}
+	for _, sliceDat := range aseFrame.Slices {
+		if zero {
+			sliceDat.reserved = 0
+		}
+		chunk(ChunkSlice, sliceDat)
+		userData(sliceDat.UserData)
+	}
+	for _, x := range enc.celOrder(aseFrame.Cels) {
+		cel := aseFrame.Cels[x]
+		if !enc.countOnly {
+			var err error
+			if cel, err = enc.cel(cel, bytesPerPixel(aseFrame.parentHeader)); err != nil {
+				enc.err = err
+				return written
+			}
+		}
+		chunk(ChunkCel, encoderCel{&cel, enc.zwriter})
+		if cel.Extra != nil {
+			extra := *cel.Extra
+			if zero {
+				extra.futureUse = [16]byte{}
+			}
+			chunk(ChunkCelExtra, &extra)
+		}
+	}
+	for _, custom := range aseFrame.CustomChunks {
+		chunk(custom.ChunkType(), custom)
+	}
+	return written
+}
+
+// encodeChunk writes the chunk size and type followed by the encoded chunk data
+func encodeChunk(w io.Writer, chunkType ChunkType, codec interface{ Encode(io.Writer) }) {
+	var chunkDat bytes.Buffer
+	codec.Encode(&chunkDat)
+	chunkSize := uint32(6 + chunkDat.Len())
+	binary.Write(w, ble, &chunkSize)
+	binary.Write(w, ble, &chunkType)
+	w.Write(chunkDat.Bytes())
+}
+
+func (asePaletteChunk *AsepriteOldPaletteChunk0004) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return asePaletteChunk.decodeFields(fr)
 }
 
-func (asePaletteChunk *AsepriteOldPaletteChunk0004) Decode(r io.Reader) {
-	binary.Read(r, ble, &asePaletteChunk.NumberOfPackets)
+func (asePaletteChunk *AsepriteOldPaletteChunk0004) decodeFields(fr *fieldReader) error {
+	asePaletteChunk.NumberOfPackets = fr.uint16()
+	if err := checkCount(fr, uint64(asePaletteChunk.NumberOfPackets), 2); err != nil {
+		return err
+	}
 	asePaletteChunk.Packets = make([]AsepriteOldPaletteChunk0004Packet, asePaletteChunk.NumberOfPackets)
 	for x := 0; x < int(asePaletteChunk.NumberOfPackets); x += 1 {
-		binary.Read(r, ble, &asePaletteChunk.Packets[x].NumPalletteEntriesToSkip)
-		binary.Read(r, ble, &asePaletteChunk.Packets[x].NumColorsInThePacket)
-		asePaletteChunk.Packets[x].Colors = make([]AsepriteRGB24, asePaletteChunk.Packets[x].NumColorsInThePacket)
-		for y := 0; y < int(asePaletteChunk.Packets[x].NumColorsInThePacket); y += 1 {
-			binary.Read(r, ble, &asePaletteChunk.Packets[x].Colors[y].R)
-			binary.Read(r, ble, &asePaletteChunk.Packets[x].Colors[y].G)
-			binary.Read(r, ble, &asePaletteChunk.Packets[x].Colors[y].B)
+		packet := &asePaletteChunk.Packets[x]
+		packet.NumPalletteEntriesToSkip = fr.uint8()
+		packet.NumColorsInThePacket = fr.uint8()
+		numColors := int(packet.NumColorsInThePacket)
+		if numColors == 0 {
+			numColors = 256
+		}
+		if err := checkCount(fr, uint64(numColors), 3); err != nil {
+			return err
+		}
+		packet.Colors = make([]AsepriteRGB24, numColors)
+		for y := 0; y < numColors; y += 1 {
+			packet.Colors[y] = AsepriteRGB24{fr.uint8(), fr.uint8(), fr.uint8()}
 		}
 	}
+	return nil
 }
 
 func (asePaletteChunk AsepriteOldPaletteChunk0004) Encode(w io.Writer) {
@@ -215,7 +561,7 @@ func (asePaletteChunk AsepriteOldPaletteChunk0004) Encode(w io.Writer) {
 	for x := 0; x < int(asePaletteChunk.NumberOfPackets); x += 1 {
 		binary.Write(w, ble, &asePaletteChunk.Packets[x].NumPalletteEntriesToSkip)
 		binary.Write(w, ble, &asePaletteChunk.Packets[x].NumColorsInThePacket)
-		for y := 0; y < int(asePaletteChunk.Packets[x].NumColorsInThePacket); y += 1 {
+		for y := 0; y < len(asePaletteChunk.Packets[x].Colors); y += 1 {
 			binary.Write(w, ble, &asePaletteChunk.Packets[x].Colors[y].R)
 			binary.Write(w, ble, &asePaletteChunk.Packets[x].Colors[y].G)
 			binary.Write(w, ble, &asePaletteChunk.Packets[x].Colors[y].B)
@@ -223,18 +569,37 @@ func (asePaletteChunk AsepriteOldPaletteChunk0004) Encode(w io.Writer) {
 	}
 }
 
-func (asePaletteChunk *AsepritePaletteChunk0011) Decode(r io.Reader) {
-	binary.Read(r, ble, &asePaletteChunk.NumberOfPackets)
+func (asePaletteChunk *AsepritePaletteChunk0011) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return asePaletteChunk.decodeFields(fr)
+}
+
+func (asePaletteChunk *AsepritePaletteChunk0011) decodeFields(fr *fieldReader) error {
+	asePaletteChunk.NumberOfPackets = fr.uint16()
+	if err := checkCount(fr, uint64(asePaletteChunk.NumberOfPackets), 2); err != nil {
+		return err
+	}
+	asePaletteChunk.Packets = make([]AsepritePaletteChunk0011Packet, asePaletteChunk.NumberOfPackets)
 	for x := 0; x < int(asePaletteChunk.NumberOfPackets); x += 1 {
-		binary.Read(r, ble, &asePaletteChunk.Packets[x].NumPalletteEntriesToSkip)
-		binary.Read(r, ble, &asePaletteChunk.Packets[x].NumColorsInThePacket)
-		asePaletteChunk.Packets[x].Colors = make([]AsepriteRGB24, asePaletteChunk.Packets[x].NumColorsInThePacket)
-		for y := 0; y < int(asePaletteChunk.Packets[x].NumColorsInThePacket); y += 1 {
-			binary.Read(r, ble, &asePaletteChunk.Packets[x].Colors[y].R)
-			binary.Read(r, ble, &asePaletteChunk.Packets[x].Colors[y].G)
-			binary.Read(r, ble, &asePaletteChunk.Packets[x].Colors[y].B)
+		packet := &asePaletteChunk.Packets[x]
+		packet.NumPalletteEntriesToSkip = fr.uint8()
+		packet.NumColorsInThePacket = fr.uint8()
+		numColors := int(packet.NumColorsInThePacket)
+		if numColors == 0 {
+			numColors = 256
+		}
+		if err := checkCount(fr, uint64(numColors), 3); err != nil {
+			return err
+		}
+		packet.Colors = make([]AsepriteRGB24, numColors)
+		for y := 0; y < numColors; y += 1 {
+			packet.Colors[y] = AsepriteRGB24{fr.uint8(), fr.uint8(), fr.uint8()}
 		}
 	}
+	return nil
 }
 
 func (asePaletteChunk AsepritePaletteChunk0011) Encode(w io.Writer) {
@@ -242,7 +607,7 @@ func (asePaletteChunk AsepritePaletteChunk0011) Encode(w io.Writer) {
 	for x := 0; x < int(asePaletteChunk.NumberOfPackets); x += 1 {
 		binary.Write(w, ble, &asePaletteChunk.Packets[x].NumPalletteEntriesToSkip)
 		binary.Write(w, ble, &asePaletteChunk.Packets[x].NumColorsInThePacket)
-		for y := 0; y < int(asePaletteChunk.Packets[x].NumColorsInThePacket); y += 1 {
+		for y := 0; y < len(asePaletteChunk.Packets[x].Colors); y += 1 {
 			binary.Write(w, ble, &asePaletteChunk.Packets[x].Colors[y].R)
 			binary.Write(w, ble, &asePaletteChunk.Packets[x].Colors[y].G)
 			binary.Write(w, ble, &asePaletteChunk.Packets[x].Colors[y].B)
@@ -251,17 +616,25 @@ func (asePaletteChunk AsepritePaletteChunk0011) Encode(w io.Writer) {
 }
 
 func (aseLayerChunk *AsepriteLayerChunk2004) Decode(r io.Reader) error {
-	binary.Read(r, ble, &aseLayerChunk.Flags)
-	binary.Read(r, ble, &aseLayerChunk.LayerType)
-	binary.Read(r, ble, &aseLayerChunk.LayerChildLevel)
-	binary.Read(r, ble, &aseLayerChunk.DefLayerWidthPixels)
-	binary.Read(r, ble, &aseLayerChunk.DefLayerHeightPixels)
-	binary.Read(r, ble, &aseLayerChunk.BlendMode)
-	binary.Read(r, ble, &aseLayerChunk.Opacity)
-	binary.Read(r, ble, &aseLayerChunk.forFuture)
-	aseLayerChunk.LayerName = DecodeAseString(r)
-	if aseLayerChunk.LayerType == 2 {
-		binary.Read(r, ble, &aseLayerChunk.TilesetIndex)
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseLayerChunk.decodeFields(fr)
+}
+
+func (aseLayerChunk *AsepriteLayerChunk2004) decodeFields(fr *fieldReader) error {
+	aseLayerChunk.Flags = LayerFlags(fr.uint16())
+	aseLayerChunk.LayerType = LayerType(fr.uint16())
+	aseLayerChunk.LayerChildLevel = fr.uint16()
+	aseLayerChunk.DefLayerWidthPixels = fr.uint16()
+	aseLayerChunk.DefLayerHeightPixels = fr.uint16()
+	aseLayerChunk.BlendMode = BlendMode(fr.uint16())
+	aseLayerChunk.Opacity = fr.uint8()
+	fr.skip(aseLayerChunk.forFuture[:])
+	aseLayerChunk.LayerName = fr.string()
+	if aseLayerChunk.LayerType == LayerTypeTilemap {
+		aseLayerChunk.TilesetIndex = fr.uint32()
 	}
 	return nil
 }
@@ -276,68 +649,94 @@ func (aseLayerChunk AsepriteLayerChunk2004) Encode(w io.Writer) {
 	binary.Write(w, ble, &aseLayerChunk.Opacity)
 	binary.Write(w, ble, &aseLayerChunk.forFuture)
 	EncodeAseString(w, aseLayerChunk.LayerName)
-	if aseLayerChunk.LayerType == 2 {
+	if aseLayerChunk.LayerType == LayerTypeTilemap {
 		binary.Write(w, ble, &aseLayerChunk.TilesetIndex)
 	}
 }
 
-func (aseCelChunk *AsepriteCelChunk2005) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseCelChunk.LayerIndex)
-	binary.Read(r, ble, &aseCelChunk.X)
-	binary.Read(r, ble, &aseCelChunk.Y)
-	binary.Read(r, ble, &aseCelChunk.OpacityLevel)
-	binary.Read(r, ble, &aseCelChunk.CelType)
-	binary.Read(r, ble, &aseCelChunk.future)
+func (aseCelChunk *AsepriteCelChunk2005) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseCelChunk.decodeFields(fr)
+}
+
+func (aseCelChunk *AsepriteCelChunk2005) decodeFields(fr *fieldReader) error {
+	return aseCelChunk.decodeWith(&decoder{}, fr)
+}
+
+// decodeWith reads the cel with the options and running totals of dec. A
+// lazy cel keeps the decompressed bytes budget of dec, not dec itself.
+func (aseCelChunk *AsepriteCelChunk2005) decodeWith(dec *decoder, fr *fieldReader) error {
+	aseCelChunk.LayerIndex = fr.uint16()
+	aseCelChunk.X = fr.int16()
+	aseCelChunk.Y = fr.int16()
+	aseCelChunk.OpacityLevel = fr.uint8()
+	aseCelChunk.CelType = CelType(fr.uint16())
+	fr.skip(aseCelChunk.future[:])
 	switch aseCelChunk.CelType {
-	case 0:
-		binary.Read(r, ble, &aseCelChunk.WidthInPix)
-		binary.Read(r, ble, &aseCelChunk.HeightInPix)
-		bytesToAlloc := int(aseCelChunk.WidthInPix) * int(aseCelChunk.HeightInPix)
-		switch aseCelChunk.parentHeader.ColorDepth {
-		case 32:
-			bytesToAlloc *= 4
-		case 16:
-			bytesToAlloc *= 2
-		case 8:
-			break
-		}
-		aseCelChunk.RawPixData = make([]byte, bytesToAlloc)
-		binary.Read(r, ble, &aseCelChunk.RawPixData)
-	case 1:
-		binary.Read(r, ble, &aseCelChunk.FramePosToLinkWith)
-	case 2:
-		binary.Read(r, ble, &aseCelChunk.WidthInPix)
-		binary.Read(r, ble, &aseCelChunk.HeightInPix)
-		bytesToRead := aseCelChunk.chunkSize - 26
-		bytesBuff := make([]byte, bytesToRead)
-		binary.Read(r, ble, bytesBuff)
-		zreader, err := zlib.NewReader(bytes.NewReader(bytesBuff))
+	case CelTypeRaw:
+		aseCelChunk.WidthInPix = fr.uint16()
+		aseCelChunk.HeightInPix = fr.uint16()
+		if err := dec.checkSize("cel", int(aseCelChunk.WidthInPix), int(aseCelChunk.HeightInPix)); err != nil {
+			return err
+		}
+		bytesToAlloc := int(aseCelChunk.WidthInPix) * int(aseCelChunk.HeightInPix) *
+			bytesPerPixel(aseCelChunk.parentHeader)
+		if err := checkCount(fr, uint64(bytesToAlloc), 1); err != nil {
+			return err
+		}
+		aseCelChunk.RawPixData = fr.bytes(bytesToAlloc)
+	case CelTypeLinked:
+		aseCelChunk.FramePosToLinkWith = fr.uint16()
+	case CelTypeCompressedImage:
+		aseCelChunk.WidthInPix = fr.uint16()
+		aseCelChunk.HeightInPix = fr.uint16()
+		if err := dec.checkSize("cel", int(aseCelChunk.WidthInPix), int(aseCelChunk.HeightInPix)); err != nil {
+			return err
+		}
+		celSize := int64(aseCelChunk.WidthInPix) * int64(aseCelChunk.HeightInPix) *
+			int64(bytesPerPixel(aseCelChunk.parentHeader))
+		if dec.lazy() {
+			aseCelChunk.keepCompressed(fr.rest(), celSize, dec.inflateBudget())
+			return nil
+		}
+		rawCelData, err := dec.inflate(bytes.NewReader(fr.rest()), celSize)
 		if err != nil {
-			log.Println(err)
-		}
-		byteBuff := bytes.NewBuffer([]byte{})
-		io.Copy(byteBuff, zreader)
-		aseCelChunk.RawCelData = byteBuff.Bytes()
-	case 3:
-		binary.Read(r, ble, &aseCelChunk.WidthInTiles)
-		binary.Read(r, ble, &aseCelChunk.HeightInTiles)
-		binary.Read(r, ble, &aseCelChunk.BitsPerTile)
-		binary.Read(r, ble, &aseCelChunk.BitMaskForTileID)
-		binary.Read(r, ble, &aseCelChunk.BitMaskForXFlip)
-		binary.Read(r, ble, &aseCelChunk.BitMaskForYFlip)
-		binary.Read(r, ble, &aseCelChunk.BitMaskFor90CWRot)
-		binary.Read(r, ble, &aseCelChunk.reserved)
-		zreader, err := zlib.NewReader(r)
+			return err
+		}
+		aseCelChunk.RawCelData = rawCelData
+	case CelTypeCompressedTilemap:
+		aseCelChunk.WidthInTiles = fr.uint16()
+		aseCelChunk.HeightInTiles = fr.uint16()
+		aseCelChunk.BitsPerTile = fr.uint16()
+		aseCelChunk.BitMaskForTileID = fr.uint32()
+		aseCelChunk.BitMaskForXFlip = fr.uint32()
+		aseCelChunk.BitMaskForYFlip = fr.uint32()
+		aseCelChunk.BitMaskFor90CWRot = fr.uint32()
+		fr.skip(aseCelChunk.reserved[:])
+		tilesSize := int64(aseCelChunk.WidthInTiles) * int64(aseCelChunk.HeightInTiles) *
+			int64((aseCelChunk.BitsPerTile+7)/8)
+		if dec.lazy() {
+			aseCelChunk.keepCompressed(fr.rest(), tilesSize, dec.inflateBudget())
+			return nil
+		}
+		tiles, err := dec.inflate(bytes.NewReader(fr.rest()), tilesSize)
 		if err != nil {
-			log.Println(err)
+			return err
 		}
-		byteBuff := bytes.NewBuffer([]byte{})
-		io.Copy(byteBuff, zreader)
-		aseCelChunk.Tiles = byteBuff.Bytes()
+		aseCelChunk.Tiles = tiles
 	}
+	return nil
 }
 
 func (aseCelChunk *AsepriteCelChunk2005) Encode(w io.Writer) {
+	aseCelChunk.encode(w, zlib.NewWriter(w))
+}
+
+// encode writes the cel compressing its data with zwriter, which is reset to w
+func (aseCelChunk *AsepriteCelChunk2005) encode(w io.Writer, zwriter *zlib.Writer) {
 	binary.Write(w, ble, &aseCelChunk.LayerIndex)
 	binary.Write(w, ble, &aseCelChunk.X)
 	binary.Write(w, ble, &aseCelChunk.Y)
@@ -345,29 +744,20 @@ func (aseCelChunk *AsepriteCelChunk2005) Encode(w io.Writer) {
 	binary.Write(w, ble, &aseCelChunk.CelType)
 	binary.Write(w, ble, &aseCelChunk.future)
 	switch aseCelChunk.CelType {
-	case 0:
+	case CelTypeRaw:
 		binary.Write(w, ble, &aseCelChunk.WidthInPix)
 		binary.Write(w, ble, &aseCelChunk.HeightInPix)
-		bytesToAlloc := int(aseCelChunk.WidthInPix) * int(aseCelChunk.HeightInPix)
-		switch aseCelChunk.parentHeader.ColorDepth {
-		case 32:
-			bytesToAlloc *= 4
-		case 16:
-			bytesToAlloc *= 2
-		case 8:
-			break
-		}
 		binary.Write(w, ble, &aseCelChunk.RawPixData)
-	case 1:
+	case CelTypeLinked:
 		binary.Write(w, ble, &aseCelChunk.FramePosToLinkWith)
-	case 2:
+	case CelTypeCompressedImage:
 		binary.Write(w, ble, &aseCelChunk.WidthInPix)
 		binary.Write(w, ble, &aseCelChunk.HeightInPix)
-		var byteBuff bytes.Buffer
-		zwriter := zlib.NewWriter(&byteBuff)
-		zwriter.Write(aseCelChunk.RawCelData)
-		w.Write(byteBuff.Bytes())
-	case 3:
+		rawCelData, _ := aseCelChunk.Pixels()
+		zwriter.Reset(w)
+		zwriter.Write(rawCelData)
+		zwriter.Close()
+	case CelTypeCompressedTilemap:
 		binary.Write(w, ble, &aseCelChunk.WidthInTiles)
 		binary.Write(w, ble, &aseCelChunk.HeightInTiles)
 		binary.Write(w, ble, &aseCelChunk.BitsPerTile)
@@ -376,20 +766,29 @@ func (aseCelChunk *AsepriteCelChunk2005) Encode(w io.Writer) {
 		binary.Write(w, ble, &aseCelChunk.BitMaskForYFlip)
 		binary.Write(w, ble, &aseCelChunk.BitMaskFor90CWRot)
 		binary.Write(w, ble, &aseCelChunk.reserved)
-		var byteBuff bytes.Buffer
-		zwriter := zlib.NewWriter(&byteBuff)
-		zwriter.Write(aseCelChunk.Tiles)
-		w.Write(byteBuff.Bytes())
+		tiles, _ := aseCelChunk.Pixels()
+		zwriter.Reset(w)
+		zwriter.Write(tiles)
+		zwriter.Close()
 	}
 }
 
-func (aseCelExtra *AsepriteCelExtraChunk2006) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseCelExtra.Flags)
-	binary.Read(r, ble, &aseCelExtra.PreciseX)
-	binary.Read(r, ble, &aseCelExtra.PreciseY)
-	binary.Read(r, ble, &aseCelExtra.WidthCelInSprite)
-	binary.Read(r, ble, &aseCelExtra.HeightCelInSprite)
-	binary.Read(r, ble, &aseCelExtra.futureUse)
+func (aseCelExtra *AsepriteCelExtraChunk2006) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseCelExtra.decodeFields(fr)
+}
+
+func (aseCelExtra *AsepriteCelExtraChunk2006) decodeFields(fr *fieldReader) error {
+	aseCelExtra.Flags = fr.uint32()
+	aseCelExtra.PreciseX = fr.fixed()
+	aseCelExtra.PreciseY = fr.fixed()
+	aseCelExtra.WidthCelInSprite = fr.fixed()
+	aseCelExtra.HeightCelInSprite = fr.fixed()
+	fr.skip(aseCelExtra.futureUse[:])
+	return nil
 }
 
 func (aseCelExtra *AsepriteCelExtraChunk2006) Encode(w io.Writer) {
@@ -401,16 +800,27 @@ func (aseCelExtra *AsepriteCelExtraChunk2006) Encode(w io.Writer) {
 	binary.Write(w, ble, &aseCelExtra.futureUse)
 }
 
-func (aseColProfile *AsepriteColorProfileChunk2007) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseColProfile.Type)
-	binary.Read(r, ble, &aseColProfile.Flags)
-	binary.Read(r, ble, &aseColProfile.FixedGamma)
-	binary.Read(r, ble, &aseColProfile.reserved)
-	if aseColProfile.Type == 2 {
-		binary.Read(r, ble, &aseColProfile.ICCProfileDatLen)
-		aseColProfile.ICCProfileDat = make([]byte, aseColProfile.ICCProfileDatLen)
-		binary.Read(r, ble, &aseColProfile.ICCProfileDat)
+func (aseColProfile *AsepriteColorProfileChunk2007) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
 	}
+	return aseColProfile.decodeFields(fr)
+}
+
+func (aseColProfile *AsepriteColorProfileChunk2007) decodeFields(fr *fieldReader) error {
+	aseColProfile.Type = ColorProfileType(fr.uint16())
+	aseColProfile.Flags = ColorProfileFlags(fr.uint16())
+	aseColProfile.FixedGamma = fr.fixed()
+	fr.skip(aseColProfile.reserved[:])
+	if aseColProfile.Type == ColorProfileICC {
+		aseColProfile.ICCProfileDatLen = fr.uint32()
+		if err := checkCount(fr, uint64(aseColProfile.ICCProfileDatLen), 1); err != nil {
+			return err
+		}
+		aseColProfile.ICCProfileDat = fr.bytes(int(aseColProfile.ICCProfileDatLen))
+	}
+	return nil
 }
 
 func (aseColProfile AsepriteColorProfileChunk2007) Encode(w io.Writer) {
@@ -418,22 +828,35 @@ func (aseColProfile AsepriteColorProfileChunk2007) Encode(w io.Writer) {
 	binary.Write(w, ble, &aseColProfile.Flags)
 	binary.Write(w, ble, &aseColProfile.FixedGamma)
 	binary.Write(w, ble, &aseColProfile.reserved)
-	if aseColProfile.Type == 2 {
+	if aseColProfile.Type == ColorProfileICC {
 		binary.Write(w, ble, &aseColProfile.ICCProfileDatLen)
 		binary.Write(w, ble, &aseColProfile.ICCProfileDat)
 	}
 }
 
-func (aseExtFile *AsepriteExternalFilesChunk2008) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseExtFile.NumEntries)
-	binary.Read(r, ble, &aseExtFile.reserved)
+func (aseExtFile *AsepriteExternalFilesChunk2008) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseExtFile.decodeFields(fr)
+}
+
+func (aseExtFile *AsepriteExternalFilesChunk2008) decodeFields(fr *fieldReader) error {
+	aseExtFile.NumEntries = fr.uint32()
+	fr.skip(aseExtFile.reserved[:])
+	if err := checkCount(fr, uint64(aseExtFile.NumEntries), 14); err != nil {
+		return err
+	}
 	// for each entry
 	aseExtFile.ExternalFile = make([]AsepriteExternalFilesChunk2008Entry, aseExtFile.NumEntries)
-	for _, file := range aseExtFile.ExternalFile {
-		binary.Read(r, ble, &file.EntryID)
-		binary.Read(r, ble, &file.reserved)
-		file.ExternalFilename = DecodeAseString(r)
+	for x := range aseExtFile.ExternalFile {
+		file := &aseExtFile.ExternalFile[x]
+		file.EntryID = fr.uint32()
+		fr.skip(file.reserved[:])
+		file.ExternalFilename = fr.string()
 	}
+	return nil
 }
 
 func (aseExtFile *AsepriteExternalFilesChunk2008) Encode(w io.Writer) {
@@ -447,16 +870,27 @@ func (aseExtFile *AsepriteExternalFilesChunk2008) Encode(w io.Writer) {
 	}
 }
 
-func (aseMask *AsepriteMaskChunk2016) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseMask.X)
-	binary.Read(r, ble, &aseMask.Y)
-	binary.Read(r, ble, &aseMask.Width)
-	binary.Read(r, ble, &aseMask.Height)
-	binary.Read(r, ble, &aseMask.future)
-	aseMask.MaskName = DecodeAseString(r)
-	aseMask.BitMapData = make([]byte,
-		(aseMask.Height * ((aseMask.Width + 7) / 8)))
-	binary.Read(r, ble, &aseMask.BitMapData)
+func (aseMask *AsepriteMaskChunk2016) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseMask.decodeFields(fr)
+}
+
+func (aseMask *AsepriteMaskChunk2016) decodeFields(fr *fieldReader) error {
+	aseMask.X = fr.int16()
+	aseMask.Y = fr.int16()
+	aseMask.Width = fr.uint16()
+	aseMask.Height = fr.uint16()
+	fr.skip(aseMask.future[:])
+	aseMask.MaskName = fr.string()
+	bitMapSize := int(aseMask.Height) * ((int(aseMask.Width) + 7) / 8)
+	if err := checkCount(fr, uint64(bitMapSize), 1); err != nil {
+		return err
+	}
+	aseMask.BitMapData = fr.bytes(bitMapSize)
+	return nil
 }
 
 func (aseMask *AsepriteMaskChunk2016) Encode(w io.Writer) {
@@ -469,23 +903,46 @@ func (aseMask *AsepriteMaskChunk2016) Encode(w io.Writer) {
 	binary.Write(w, ble, &aseMask.BitMapData)
 }
 
-func (aseTags *AsepriteTagsChunk2018) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseTags.NumTags)
-	binary.Read(r, ble, &aseTags.reserved1)
+func (aseTags *AsepriteTagsChunk2018) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseTags.decodeFields(fr)
+}
+
+func (aseTags *AsepriteTagsChunk2018) decodeFields(fr *fieldReader) error {
+	aseTags.NumTags = fr.uint16()
+	fr.skip(aseTags.reserved1[:])
+	if err := checkCount(fr, uint64(aseTags.NumTags), 19); err != nil {
+		return err
+	}
 	aseTags.Tags = make([]AsepriteTagsChunk2018Tag, aseTags.NumTags)
 	for x := 0; x < int(aseTags.NumTags); x += 1 {
-		aseTags.Tags[x].Decode(r)
+		if err := aseTags.Tags[x].decodeFields(fr); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (aseTag *AsepriteTagsChunk2018Tag) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseTag.FromFrame)
-	binary.Read(r, ble, &aseTag.ToFrame)
-	binary.Read(r, ble, &aseTag.LoopAnimDirection)
-	binary.Read(r, ble, &aseTag.reserved2)
-	binary.Read(r, ble, &aseTag.TagColor)
-	binary.Read(r, ble, &aseTag.ExtraByte)
-	aseTag.TagName = DecodeAseString(r)
+func (aseTag *AsepriteTagsChunk2018Tag) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseTag.decodeFields(fr)
+}
+
+func (aseTag *AsepriteTagsChunk2018Tag) decodeFields(fr *fieldReader) error {
+	aseTag.FromFrame = fr.uint16()
+	aseTag.ToFrame = fr.uint16()
+	aseTag.LoopAnimDirection = LoopDirection(fr.uint8())
+	fr.skip(aseTag.reserved2[:])
+	fr.skip(aseTag.TagColor[:])
+	aseTag.ExtraByte = fr.uint8()
+	aseTag.TagName = fr.string()
+	return nil
 }
 
 func (aseTags AsepriteTagsChunk2018) Encode(w io.Writer) {
@@ -506,27 +963,54 @@ func (aseTag AsepriteTagsChunk2018Tag) Encode(w io.Writer) {
 	EncodeAseString(w, aseTag.TagName)
 }
 
-func (asePaletteChunk *AsepritePaletteChunk2019) Decode(r io.Reader) {
-	binary.Read(r, ble, &asePaletteChunk.PaletteSize)
-	binary.Read(r, ble, &asePaletteChunk.FirstColIndexToChange)
-	binary.Read(r, ble, &asePaletteChunk.LastColIndexToChange)
-	binary.Read(r, ble, &asePaletteChunk.reserved)
+func (asePaletteChunk *AsepritePaletteChunk2019) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return asePaletteChunk.decodeFields(fr)
+}
+
+func (asePaletteChunk *AsepritePaletteChunk2019) decodeFields(fr *fieldReader) error {
+	asePaletteChunk.PaletteSize = fr.uint32()
+	asePaletteChunk.FirstColIndexToChange = fr.uint32()
+	asePaletteChunk.LastColIndexToChange = fr.uint32()
+	fr.skip(asePaletteChunk.reserved[:])
+	if asePaletteChunk.LastColIndexToChange < asePaletteChunk.FirstColIndexToChange {
+		return FormatError("palette color range is reversed")
+	}
+	numEntries := uint64(asePaletteChunk.LastColIndexToChange-asePaletteChunk.FirstColIndexToChange) + 1
+	if err := checkCount(fr, numEntries, 6); err != nil {
+		return err
+	}
 	asePaletteChunk.PaletteEntries =
-		make([]AsepritePaletteChunk2019Entry, asePaletteChunk.PaletteSize)
+		make([]AsepritePaletteChunk2019Entry, numEntries)
 	for x := 0; x < len(asePaletteChunk.PaletteEntries); x += 1 {
-		asePaletteChunk.PaletteEntries[x].Decode(r)
+		if err := asePaletteChunk.PaletteEntries[x].decodeFields(fr); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (asePaletteEntry *AsepritePaletteChunk2019Entry) Decode(r io.Reader) {
-	binary.Read(r, ble, &asePaletteEntry.EntryFlags)
-	binary.Read(r, ble, &asePaletteEntry.R)
-	binary.Read(r, ble, &asePaletteEntry.G)
-	binary.Read(r, ble, &asePaletteEntry.B)
-	binary.Read(r, ble, &asePaletteEntry.A)
+func (asePaletteEntry *AsepritePaletteChunk2019Entry) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return asePaletteEntry.decodeFields(fr)
+}
+
+func (asePaletteEntry *AsepritePaletteChunk2019Entry) decodeFields(fr *fieldReader) error {
+	asePaletteEntry.EntryFlags = fr.uint16()
+	asePaletteEntry.R = fr.uint8()
+	asePaletteEntry.G = fr.uint8()
+	asePaletteEntry.B = fr.uint8()
+	asePaletteEntry.A = fr.uint8()
 	if asePaletteEntry.EntryFlags&0x01 == 1 {
-		asePaletteEntry.ColorName = DecodeAseString(r)
+		asePaletteEntry.ColorName = fr.string()
 	}
+	return nil
 }
 
 func (asePaletteChunk AsepritePaletteChunk2019) Encode(w io.Writer) {
@@ -545,65 +1029,109 @@ func (asePaletteEntry AsepritePaletteChunk2019Entry) Encode(w io.Writer) {
 	binary.Write(w, ble, &asePaletteEntry.G)
 	binary.Write(w, ble, &asePaletteEntry.B)
 	binary.Write(w, ble, &asePaletteEntry.A)
-	EncodeAseString(w, asePaletteEntry.ColorName)
+	if asePaletteEntry.EntryFlags&0x01 == 1 {
+		EncodeAseString(w, asePaletteEntry.ColorName)
+	}
+}
+
+func (aseUserDat *AsepriteUserDataChunk2020) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseUserDat.decodeFields(fr)
 }
 
-func (aseUserDat *AsepriteUserDataChunk2020) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseUserDat.Flags)
-	if aseUserDat.Flags&0x00000001 == 1 {
-		aseUserDat.Text = DecodeAseString(r)
+func (aseUserDat *AsepriteUserDataChunk2020) decodeFields(fr *fieldReader) error {
+	aseUserDat.Flags = UserDataFlags(fr.uint32())
+	if aseUserDat.Flags.HasText() {
+		aseUserDat.Text = fr.string()
 	}
-	if aseUserDat.Flags&0x00000002 == 2 {
-		binary.Read(r, ble, &aseUserDat.R)
-		binary.Read(r, ble, &aseUserDat.G)
-		binary.Read(r, ble, &aseUserDat.B)
-		binary.Read(r, ble, &aseUserDat.A)
+	if aseUserDat.Flags.HasColor() {
+		aseUserDat.R = fr.uint8()
+		aseUserDat.G = fr.uint8()
+		aseUserDat.B = fr.uint8()
+		aseUserDat.A = fr.uint8()
 	}
+	if aseUserDat.Flags.HasProperties() {
+		properties, err := decodeProperties(fr)
+		if err != nil {
+			return err
+		}
+		aseUserDat.Properties = properties
+	}
+	return nil
 }
 
 func (aseUserDat AsepriteUserDataChunk2020) Encode(w io.Writer) {
 	binary.Write(w, ble, &aseUserDat.Flags)
-	if aseUserDat.Flags&0x00000001 == 1 {
+	if aseUserDat.Flags.HasText() {
 		EncodeAseString(w, aseUserDat.Text)
 	}
-	if aseUserDat.Flags&0x00000002 == 2 {
+	if aseUserDat.Flags.HasColor() {
 		binary.Write(w, ble, &aseUserDat.R)
 		binary.Write(w, ble, &aseUserDat.G)
 		binary.Write(w, ble, &aseUserDat.B)
 		binary.Write(w, ble, &aseUserDat.A)
 	}
+	if aseUserDat.Flags.HasProperties() {
+		encodeProperties(w, aseUserDat.Properties)
+	}
 }
 
-func (aseSlice *AsepriteSliceChunk2022) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseSlice.NumSliceKeys)
-	binary.Read(r, ble, &aseSlice.Flags)
-	binary.Read(r, ble, &aseSlice.reserved)
-	aseSlice.Name = DecodeAseString(r)
+func (aseSlice *AsepriteSliceChunk2022) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseSlice.decodeFields(fr)
+}
+
+func (aseSlice *AsepriteSliceChunk2022) decodeFields(fr *fieldReader) error {
+	aseSlice.NumSliceKeys = fr.uint32()
+	aseSlice.Flags = SliceFlags(fr.uint32())
+	aseSlice.reserved = fr.uint32()
+	aseSlice.Name = fr.string()
+	if err := checkCount(fr, uint64(aseSlice.NumSliceKeys), 20); err != nil {
+		return err
+	}
 	aseSlice.SliceKeysData =
 		make([]AsepriteSliceChunk2022Data, aseSlice.NumSliceKeys)
-	for i, slice := range aseSlice.SliceKeysData {
+	for i := range aseSlice.SliceKeysData {
+		slice := &aseSlice.SliceKeysData[i]
 		slice.parentChunk = aseSlice
-		slice.Decode(r)
-		aseSlice.SliceKeysData[i] = slice
+		if err := slice.decodeFields(fr); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (aseSliceDat *AsepriteSliceChunk2022Data) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseSliceDat.FrameNumber)
-	binary.Read(r, ble, &aseSliceDat.SliceXOriginCoords)
-	binary.Read(r, ble, &aseSliceDat.SliceYOriginCoords)
-	binary.Read(r, ble, &aseSliceDat.SliceWidth)
-	binary.Read(r, ble, &aseSliceDat.SliceHeight)
-	if aseSliceDat.parentChunk.Flags&0x00000001 == 1 {
-		binary.Read(r, ble, &aseSliceDat.CenterX)
-		binary.Read(r, ble, &aseSliceDat.CenterY)
-		binary.Read(r, ble, &aseSliceDat.CenterWidth)
-		binary.Read(r, ble, &aseSliceDat.CenterHeight)
+func (aseSliceDat *AsepriteSliceChunk2022Data) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
 	}
-	if aseSliceDat.parentChunk.Flags&0x00000002 == 2 {
-		binary.Read(r, ble, &aseSliceDat.PivotX)
-		binary.Read(r, ble, &aseSliceDat.PivotY)
+	return aseSliceDat.decodeFields(fr)
+}
+
+func (aseSliceDat *AsepriteSliceChunk2022Data) decodeFields(fr *fieldReader) error {
+	aseSliceDat.FrameNumber = fr.uint32()
+	aseSliceDat.SliceXOriginCoords = fr.int32()
+	aseSliceDat.SliceYOriginCoords = fr.int32()
+	aseSliceDat.SliceWidth = fr.uint32()
+	aseSliceDat.SliceHeight = fr.uint32()
+	if aseSliceDat.parentChunk.Flags.NinePatch() {
+		aseSliceDat.CenterX = fr.int32()
+		aseSliceDat.CenterY = fr.int32()
+		aseSliceDat.CenterWidth = fr.uint32()
+		aseSliceDat.CenterHeight = fr.uint32()
+	}
+	if aseSliceDat.parentChunk.Flags.HasPivot() {
+		aseSliceDat.PivotX = fr.int32()
+		aseSliceDat.PivotY = fr.int32()
 	}
+	return nil
 }
 
 func (aseSlice AsepriteSliceChunk2022) Encode(w io.Writer) {
@@ -623,34 +1151,64 @@ func (aseSliceDat AsepriteSliceChunk2022Data) Encode(w io.Writer) {
 	binary.Write(w, ble, &aseSliceDat.SliceYOriginCoords)
 	binary.Write(w, ble, &aseSliceDat.SliceWidth)
 	binary.Write(w, ble, &aseSliceDat.SliceHeight)
-	if aseSliceDat.parentChunk.Flags&0x00000001 == 1 {
+	if aseSliceDat.parentChunk.Flags.NinePatch() {
 		binary.Write(w, ble, &aseSliceDat.CenterX)
 		binary.Write(w, ble, &aseSliceDat.CenterY)
 		binary.Write(w, ble, &aseSliceDat.CenterWidth)
 		binary.Write(w, ble, &aseSliceDat.CenterHeight)
 	}
-	if aseSliceDat.parentChunk.Flags&0x00000002 == 2 {
+	if aseSliceDat.parentChunk.Flags.HasPivot() {
 		binary.Write(w, ble, &aseSliceDat.PivotX)
 		binary.Write(w, ble, &aseSliceDat.PivotY)
 	}
 }
 
-func (aseTileset *AsepriteTilesetChunk2023) Decode(r io.Reader) {
-	binary.Read(r, ble, &aseTileset.TilesetID)
-	binary.Read(r, ble, &aseTileset.Flags)
-	binary.Read(r, ble, &aseTileset.NumTiles)
-	binary.Read(r, ble, &aseTileset.TileWidth)
-	binary.Read(r, ble, &aseTileset.TileHeight)
-	binary.Read(r, ble, &aseTileset.BaseIndex)
-	binary.Read(r, ble, &aseTileset.reserved)
-	aseTileset.Name = DecodeAseString(r)
-	if aseTileset.Flags&0x00000001 == 1 {
-		binary.Read(r, ble, &aseTileset.ExternalFileID)
-		binary.Read(r, ble, &aseTileset.TilesetIDInExternalFile)
-	}
-	if aseTileset.Flags&0x00000002 == 2 {
-		binary.Read(r, ble, &aseTileset.CompressedDatLen)
-		aseTileset.CompressedTilesetImg = make([]byte, aseTileset.CompressedDatLen)
-		binary.Read(r, ble, &aseTileset.CompressedTilesetImg)
+func (aseTileset *AsepriteTilesetChunk2023) Decode(r io.Reader) error {
+	fr, err := readFields(r)
+	if err != nil {
+		return err
+	}
+	return aseTileset.decodeFields(fr)
+}
+
+func (aseTileset *AsepriteTilesetChunk2023) decodeFields(fr *fieldReader) error {
+	aseTileset.TilesetID = fr.uint32()
+	aseTileset.Flags = TilesetFlags(fr.uint32())
+	aseTileset.NumTiles = fr.uint32()
+	aseTileset.TileWidth = fr.uint16()
+	aseTileset.TileHeight = fr.uint16()
+	aseTileset.BaseIndex = fr.int16()
+	fr.skip(aseTileset.reserved[:])
+	aseTileset.Name = fr.string()
+	if aseTileset.Flags.ExternalFile() {
+		aseTileset.ExternalFileID = fr.uint32()
+		aseTileset.TilesetIDInExternalFile = fr.uint32()
+	}
+	if aseTileset.Flags.TilesInFile() {
+		aseTileset.CompressedDatLen = fr.uint32()
+		if err := checkCount(fr, uint64(aseTileset.CompressedDatLen), 1); err != nil {
+			return err
+		}
+		aseTileset.CompressedTilesetImg = fr.bytes(int(aseTileset.CompressedDatLen))
+	}
+	return nil
+}
+
+func (aseTileset *AsepriteTilesetChunk2023) Encode(w io.Writer) {
+	binary.Write(w, ble, &aseTileset.TilesetID)
+	binary.Write(w, ble, &aseTileset.Flags)
+	binary.Write(w, ble, &aseTileset.NumTiles)
+	binary.Write(w, ble, &aseTileset.TileWidth)
+	binary.Write(w, ble, &aseTileset.TileHeight)
+	binary.Write(w, ble, &aseTileset.BaseIndex)
+	binary.Write(w, ble, &aseTileset.reserved)
+	EncodeAseString(w, aseTileset.Name)
+	if aseTileset.Flags.ExternalFile() {
+		binary.Write(w, ble, &aseTileset.ExternalFileID)
+		binary.Write(w, ble, &aseTileset.TilesetIDInExternalFile)
+	}
+	if aseTileset.Flags.TilesInFile() {
+		binary.Write(w, ble, &aseTileset.CompressedDatLen)
+		binary.Write(w, ble, &aseTileset.CompressedTilesetImg)
 	}
 }
@@ -0,0 +1,237 @@
+package asefile
+
+import (
+	"fmt"
+	"io"
+)
+
+/**
+ * FileIndex records where each frame and chunk of a sprite starts, built
+ * from the BytesThisFrame and chunk size fields without decoding any chunk.
+ * An index holds no reference to the data it was built from, so it can be
+ * kept and opened again over another io.ReaderAt with the same contents, such
+ * as a memory-mapped copy of the file.
+ */
+type FileIndex struct {
+	Header AsepriteHeader
+	Frames []FrameIndex
+}
+
+// FrameIndex is the position of a frame and its chunks in the file
+type FrameIndex struct {
+	Offset         int64
+	BytesThisFrame uint32
+	Chunks         []ChunkIndex
+}
+
+// ChunkIndex is the position of a chunk, including its 6 byte size and type
+// prefix. Layer is the layer index of a cel chunk and -1 for other chunks.
+type ChunkIndex struct {
+	Offset int64
+	Size   uint32
+	Type   ChunkType
+	Layer  int
+}
+
+/**
+ * SpriteReader decodes frames and cels of a sprite on demand, so that showing
+ * frame N never requires decoding the frames before it.
+ */
+type SpriteReader struct {
+	Index *FileIndex
+	r     io.ReaderAt
+	opts  DecodeOptions
+}
+
+// OpenReaderAt indexes the sprite of size bytes in r without any
+// DecodeOptions limits
+func OpenReaderAt(r io.ReaderAt, size int64) (*SpriteReader, error) {
+	return OpenReaderAtWithOptions(r, size, DecodeOptions{})
+}
+
+// OpenReaderAtWithOptions indexes the sprite of size bytes in r. The limits
+// in opts apply to the header when indexing, and to each frame or cel decoded
+// afterwards.
+func OpenReaderAtWithOptions(r io.ReaderAt, size int64, opts DecodeOptions) (*SpriteReader, error) {
+	index, err := NewFileIndex(r, size, opts)
+	if err != nil {
+		return nil, err
+	}
+	return index.Open(r, opts), nil
+}
+
+// NewFileIndex reads the header of the sprite of size bytes in r, then walks
+// its frame and chunk headers to record their offsets
+func NewFileIndex(r io.ReaderAt, size int64, opts DecodeOptions) (*FileIndex, error) {
+	dec := &decoder{opts: opts}
+	index := &FileIndex{}
+	if err := index.Header.Decode(io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, err
+	}
+	if err := dec.checkHeader(&index.Header); err != nil {
+		return nil, err
+	}
+	index.Frames = make([]FrameIndex, index.Header.Frames)
+	offset := int64(128)
+	for x := range index.Frames {
+		var frameDat [16]byte
+		if err := readAt(r, frameDat[:], offset, size); err != nil {
+			return nil, err
+		}
+		frameIndex := &index.Frames[x]
+		frameIndex.Offset = offset
+		frameIndex.BytesThisFrame = ble.Uint32(frameDat[0:])
+		if ble.Uint16(frameDat[4:]) != 0xF1FA {
+			return nil, FormatError("frame magic number incorrect")
+		}
+		if frameIndex.BytesThisFrame < 16 || offset+int64(frameIndex.BytesThisFrame) > size {
+			return nil, FormatError(fmt.Sprintf("frame %d size %d does not fit in the file", x, frameIndex.BytesThisFrame))
+		}
+		numChunks := int(ble.Uint16(frameDat[6:]))
+		if chunksExt := ble.Uint32(frameDat[12:]); chunksExt != 0 {
+			numChunks = int(chunksExt)
+		}
+		frameEnd := offset + int64(frameIndex.BytesThisFrame)
+		chunkOffset := offset + 16
+		for y := 0; y < numChunks; y += 1 {
+			var chunkHeader [8]byte
+			if err := readAt(r, chunkHeader[:6], chunkOffset, frameEnd); err != nil {
+				return nil, err
+			}
+			chunk := ChunkIndex{
+				Offset: chunkOffset,
+				Size:   ble.Uint32(chunkHeader[0:]),
+				Type:   ChunkType(ble.Uint16(chunkHeader[4:])),
+				Layer:  -1,
+			}
+			if chunk.Size < 6 || chunkOffset+int64(chunk.Size) > frameEnd {
+				return nil, FormatError(fmt.Sprintf("chunk size %d does not fit in frame %d", chunk.Size, x))
+			}
+			if opts.MaxChunkSize > 0 && int64(chunk.Size) > int64(opts.MaxChunkSize) {
+				return nil, limitError("chunk size", int64(chunk.Size), int64(opts.MaxChunkSize))
+			}
+			if chunk.Type == ChunkCel {
+				if err := readAt(r, chunkHeader[6:], chunkOffset+6, chunkOffset+int64(chunk.Size)); err != nil {
+					return nil, err
+				}
+				chunk.Layer = int(ble.Uint16(chunkHeader[6:]))
+			}
+			frameIndex.Chunks = append(frameIndex.Chunks, chunk)
+			chunkOffset += int64(chunk.Size)
+		}
+		offset = frameEnd
+	}
+	return index, nil
+}
+
+// readAt fills buf from offset in r, failing if that would read past end
+func readAt(r io.ReaderAt, buf []byte, offset, end int64) error {
+	if offset+int64(len(buf)) > end {
+		return io.ErrUnexpectedEOF
+	}
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return truncated(err)
+	}
+	return nil
+}
+
+// Open returns a reader that decodes from r using the index, which must have
+// been built from the same contents
+func (index *FileIndex) Open(r io.ReaderAt, opts DecodeOptions) *SpriteReader {
+	// Frames and cels are picked by the caller instead of by filters, and
+	// decoding one of them is no progress through the file
+	opts.Layers, opts.LayerIndices, opts.Frames = nil, nil, nil
+	opts.Progress = nil
+	return &SpriteReader{Index: index, r: r, opts: opts}
+}
+
+// FrameCount returns the number of frames in the sprite
+func (spriteReader *SpriteReader) FrameCount() int {
+	return len(spriteReader.Index.Frames)
+}
+
+// Frame decodes frame n
+func (spriteReader *SpriteReader) Frame(n int) (*AsepriteFrame, error) {
+	if n < 0 || n >= len(spriteReader.Index.Frames) {
+		return nil, fmt.Errorf("frame %d out of range, the sprite has %d frames", n, len(spriteReader.Index.Frames))
+	}
+	frameIndex := spriteReader.Index.Frames[n]
+	aseFrame := &AsepriteFrame{parentHeader: &spriteReader.Index.Header}
+	dec := &decoder{opts: spriteReader.opts, frame: n}
+	frameReader := io.NewSectionReader(spriteReader.r, frameIndex.Offset, int64(frameIndex.BytesThisFrame))
+	if err := aseFrame.decode(dec, frameReader); err != nil {
+		return nil, err
+	}
+	if err := dec.inflateQueued(); err != nil {
+		return nil, err
+	}
+	return aseFrame, nil
+}
+
+// Cel decodes the cel of layer in frame, or returns nil if the layer has no
+// cel in that frame. A linked cel is resolved to the cel it links to, with
+// the position and opacity given in the frame itself.
+func (spriteReader *SpriteReader) Cel(frame, layer int) (*AsepriteCelChunk2005, error) {
+	if frame < 0 || frame >= len(spriteReader.Index.Frames) {
+		return nil, fmt.Errorf("frame %d out of range, the sprite has %d frames", frame, len(spriteReader.Index.Frames))
+	}
+	visited := make(map[int]bool)
+	var cel *AsepriteCelChunk2005
+	for !visited[frame] {
+		visited[frame] = true
+		linked, err := spriteReader.decodeCel(frame, layer)
+		if err != nil || linked == nil {
+			return nil, err
+		}
+		if cel == nil {
+			cel = linked
+		}
+		if linked.CelType != CelTypeLinked {
+			if linked != cel {
+				linked.X, linked.Y = cel.X, cel.Y
+				linked.OpacityLevel = cel.OpacityLevel
+			}
+			return linked, nil
+		}
+		frame = int(linked.FramePosToLinkWith)
+		if frame >= len(spriteReader.Index.Frames) {
+			break
+		}
+	}
+	return nil, FormatError(fmt.Sprintf("cel on layer %d links to a missing or circular frame", layer))
+}
+
+// decodeCel decodes the cel chunk of layer in frame as it is stored
+func (spriteReader *SpriteReader) decodeCel(frame, layer int) (*AsepriteCelChunk2005, error) {
+	chunks := spriteReader.Index.Frames[frame].Chunks
+	for x, chunk := range chunks {
+		if chunk.Type != ChunkCel || chunk.Layer != layer {
+			continue
+		}
+		// A single cel gains nothing from the worker pool
+		dec := &decoder{opts: spriteReader.opts, frame: frame}
+		dec.opts.Concurrency = 0
+		chunkDat, err := dec.readChunk(io.NewSectionReader(spriteReader.r, chunk.Offset+6, int64(chunk.Size)-6), chunk.Size)
+		if err != nil {
+			return nil, err
+		}
+		cel := &AsepriteCelChunk2005{parentHeader: &spriteReader.Index.Header, chunkSize: chunk.Size}
+		if err := cel.decodeWith(dec, &fieldReader{chunkDat}); err != nil {
+			return nil, err
+		}
+		if x+1 < len(chunks) && chunks[x+1].Type == ChunkCelExtra {
+			extraChunk := chunks[x+1]
+			dec.chunkBuf.Reset()
+			extraDat, err := dec.readChunk(io.NewSectionReader(spriteReader.r, extraChunk.Offset+6, int64(extraChunk.Size)-6), extraChunk.Size)
+			if err != nil {
+				return nil, err
+			}
+			cel.Extra = &AsepriteCelExtraChunk2006{}
+			if err := cel.Extra.decodeFields(&fieldReader{extraDat}); err != nil {
+				return nil, err
+			}
+		}
+		return cel, nil
+	}
+	return nil, nil
+}
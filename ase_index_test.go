@@ -0,0 +1,137 @@
+package asefile
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOpenReaderAt(t *testing.T) {
+	chica := readChica(t)
+	sprite, err := OpenReaderAt(bytes.NewReader(chica), int64(len(chica)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aseFile := decodeChica(t, DecodeOptions{})
+	if sprite.FrameCount() != len(aseFile.Frames) {
+		t.Fatalf("%d frames, want %d", sprite.FrameCount(), len(aseFile.Frames))
+	}
+	// Frames are decoded out of order, each on its own
+	for _, n := range []int{12, 0, 5} {
+		aseFrame, err := sprite.Frame(n)
+		if err != nil {
+			t.Fatalf("frame %d: %v", n, err)
+		}
+		want := &AsepriteFile{Header: aseFile.Header, Frames: []AsepriteFrame{aseFile.Frames[n]}}
+		got := &AsepriteFile{Header: aseFile.Header, Frames: []AsepriteFrame{*aseFrame}}
+		if diff := Diff(want, got, EqualOptions{}); diff != "" {
+			t.Errorf("frame %d differs from the decoded sprite:\n%s", n, diff)
+		}
+		for _, wantCel := range aseFile.Frames[n].Cels {
+			cel, err := sprite.Cel(n, int(wantCel.LayerIndex))
+			if err != nil {
+				t.Fatalf("frame %d, layer %d: %v", n, wantCel.LayerIndex, err)
+			}
+			pixels, _ := cel.Pixels()
+			if cel.X != wantCel.X || cel.Y != wantCel.Y || !bytes.Equal(pixels, wantCel.RawCelData) {
+				t.Errorf("frame %d: cel on layer %d differs", n, wantCel.LayerIndex)
+			}
+		}
+	}
+
+	// The index opens again over another copy of the data
+	again := sprite.Index.Open(bytes.NewReader(append([]byte(nil), chica...)), DecodeOptions{})
+	if _, err := again.Frame(3); err != nil {
+		t.Errorf("reopened index: %v", err)
+	}
+	for _, n := range []int{-1, 13} {
+		if _, err := sprite.Frame(n); err == nil {
+			t.Errorf("frame %d: no error", n)
+		}
+		if _, err := sprite.Cel(n, 0); err == nil {
+			t.Errorf("cel in frame %d: no error", n)
+		}
+	}
+	if cel, err := sprite.Cel(0, 7); cel != nil || err != nil {
+		t.Errorf("cel on a missing layer gave %v, %v", cel, err)
+	}
+}
+
+func TestOpenReaderAtLinkedCel(t *testing.T) {
+	red := solidImage(2, 2, color.NRGBA{255, 0, 0, 255})
+	builder := NewSprite(4, 4, ColorModeRGBA)
+	layer := builder.AddLayer("Layer", -1)
+	builder.SetCel(builder.AddFrame(100), layer, red, image.Pt(1, 1))
+	builder.SetCel(builder.AddFrame(100), layer, red, image.Pt(1, 1))
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := aseFile.EncodeWithOptions(&buf, EncodeOptions{LinkIdenticalCels: true}); err != nil {
+		t.Fatal(err)
+	}
+	sprite, err := OpenReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cel, err := sprite.Cel(1, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pixels, _ := cel.Pixels(); cel.CelType != CelTypeCompressedImage || cel.X != 1 || len(pixels) != 16 {
+		t.Errorf("linked cel resolved to a %v cel at %d,%d with %d bytes", cel.CelType, cel.X, cel.Y, len(pixels))
+	}
+}
+
+func TestOpenReaderAtWarningFrame(t *testing.T) {
+	// An unknown chunk in frame 4 is reported as being in frame 4, not in
+	// frame 0, when the frame is decoded on its own
+	sprite := insertChunk(readChica(t), 4, 0x7A01, []byte{1, 2, 3})
+	reader, err := OpenReaderAtWithOptions(bytes.NewReader(sprite), int64(len(sprite)), DecodeOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reader.Frame(4)
+	var warning Warning
+	if !errors.As(err, &warning) || warning.Frame != 4 || warning.Kind != WarningUnknownChunk {
+		t.Errorf("got %v, want an unknown chunk warning for frame 4", err)
+	}
+
+	// So is damaged zlib data in a cel decoded on its own
+	chunk := reader.Index.Frames[6].Chunks[len(reader.Index.Frames[6].Chunks)-1]
+	damaged := append([]byte(nil), sprite...)
+	for x := chunk.Offset + int64(chunk.Size) - 8; x < chunk.Offset+int64(chunk.Size); x += 1 {
+		damaged[x] ^= 0xFF
+	}
+	reader, err = OpenReaderAtWithOptions(bytes.NewReader(damaged), int64(len(damaged)), DecodeOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reader.Cel(6, chunk.Layer)
+	if !errors.As(err, &warning) || warning.Frame != 6 || warning.Kind != WarningZlib {
+		t.Errorf("got %v, want a zlib warning for frame 6", err)
+	}
+}
+
+func TestNewFileIndexErrors(t *testing.T) {
+	chica := readChica(t)
+	badFrame := append([]byte(nil), chica...)
+	badFrame[128+4] = 0
+	for _, test := range []struct {
+		name string
+		data []byte
+		opts DecodeOptions
+	}{
+		{"short header", chica[:64], DecodeOptions{}},
+		{"truncated", chica[:len(chica)-10], DecodeOptions{}},
+		{"frame magic number", badFrame, DecodeOptions{}},
+		{"chunk size limit", chica, DecodeOptions{MaxChunkSize: 100}},
+	} {
+		if _, err := NewFileIndex(bytes.NewReader(test.data), int64(len(test.data)), test.opts); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
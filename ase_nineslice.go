@@ -0,0 +1,101 @@
+package asefile
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// SliceKey is one key of a slice chunk: the bounds of the slice from a given
+// frame onwards, plus the center rectangle for 9-patch slices.
+type SliceKey = AsepriteSliceChunk2022Data
+
+// StretchMode selects how the edges and center of a 9-patch slice fill the
+// space between the fixed corners.
+type StretchMode int
+
+const (
+	// StretchModeStretch scales the edges and center with nearest neighbour sampling
+	StretchModeStretch StretchMode = iota
+	// StretchModeTile repeats the edges and center, starting from the top left of each region
+	StretchModeTile
+)
+
+// NineSlice renders the 9-patch slice described by key at w x h pixels.
+//
+// The slice bounds are read from src relative to its bounds minimum, so src is
+// usually a flattened frame of the sprite the slice was authored in. The four
+// corners are copied as they are, the top and bottom edges are resized
+// horizontally, the left and right edges vertically, and the center in both
+// directions, as selected by mode.
+//
+// An error is returned when key does not belong to a 9-patch slice, when its
+// center lies outside the slice bounds, or when w x h is smaller than the fixed
+// borders around the center. Keys built by hand rather than decoded have no
+// slice to check the 9-patch flag of, so they need a center that is not empty.
+func NineSlice(src image.Image, key SliceKey, w, h int, mode StretchMode) (*image.NRGBA, error) {
+	switch {
+	case key.parentChunk != nil && !key.parentChunk.Flags.NinePatch():
+		return nil, fmt.Errorf("slice %q is not a 9-patch slice", key.parentChunk.Name)
+	case key.parentChunk == nil && key.CenterWidth == 0 && key.CenterHeight == 0:
+		return nil, fmt.Errorf("slice key has no 9-patch center")
+	}
+	if mode != StretchModeStretch && mode != StretchModeTile {
+		return nil, fmt.Errorf("unknown stretch mode %d", mode)
+	}
+	sliceW, sliceH := int(key.SliceWidth), int(key.SliceHeight)
+	centerX, centerY := int(key.CenterX), int(key.CenterY)
+	centerW, centerH := int(key.CenterWidth), int(key.CenterHeight)
+	if centerX < 0 || centerY < 0 || centerX+centerW > sliceW || centerY+centerH > sliceH {
+		return nil, fmt.Errorf("9-patch center %dx%d+%d+%d lies outside the %dx%d slice",
+			centerW, centerH, centerX, centerY, sliceW, sliceH)
+	}
+	left, top := centerX, centerY
+	right, bottom := sliceW-centerX-centerW, sliceH-centerY-centerH
+	if w < left+right || h < top+bottom {
+		return nil, fmt.Errorf("target size %dx%d is smaller than the fixed borders of %dx%d",
+			w, h, left+right, top+bottom)
+	}
+	if (centerW == 0 && w > left+right) || (centerH == 0 && h > top+bottom) {
+		return nil, fmt.Errorf("9-patch center is empty and cannot fill %dx%d", w, h)
+	}
+
+	origin := src.Bounds().Min.Add(image.Pt(int(key.SliceXOriginCoords), int(key.SliceYOriginCoords)))
+	// Column and row boundaries of the three by three grid in the source and in the target
+	srcCols := [4]int{0, left, left + centerW, sliceW}
+	srcRows := [4]int{0, top, top + centerH, sliceH}
+	dstCols := [4]int{0, left, w - right, w}
+	dstRows := [4]int{0, top, h - bottom, h}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for row := 0; row < 3; row += 1 {
+		for col := 0; col < 3; col += 1 {
+			srcRect := image.Rect(srcCols[col], srcRows[row], srcCols[col+1], srcRows[row+1]).Add(origin)
+			dstRect := image.Rect(dstCols[col], dstRows[row], dstCols[col+1], dstRows[row+1])
+			drawNineSliceRegion(dst, dstRect, src, srcRect, mode)
+		}
+	}
+	return dst, nil
+}
+
+func drawNineSliceRegion(dst *image.NRGBA, dstRect image.Rectangle, src image.Image, srcRect image.Rectangle, mode StretchMode) {
+	srcW, srcH := srcRect.Dx(), srcRect.Dy()
+	dstW, dstH := dstRect.Dx(), dstRect.Dy()
+	if srcW == 0 || srcH == 0 || dstW == 0 || dstH == 0 {
+		return
+	}
+	for y := 0; y < dstH; y += 1 {
+		sy := y % srcH
+		if mode == StretchModeStretch {
+			sy = y * srcH / dstH
+		}
+		for x := 0; x < dstW; x += 1 {
+			sx := x % srcW
+			if mode == StretchModeStretch {
+				sx = x * srcW / dstW
+			}
+			col := color.NRGBAModel.Convert(src.At(srcRect.Min.X+sx, srcRect.Min.Y+sy)).(color.NRGBA)
+			dst.SetNRGBA(dstRect.Min.X+x, dstRect.Min.Y+y, col)
+		}
+	}
+}
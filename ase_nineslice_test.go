@@ -0,0 +1,91 @@
+package asefile
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// nineSliceSource is a 3x3 grid of 1 pixel regions, each colored after its
+// column and row, placed at 1,1 in a 5x5 image
+func nineSliceSource() *image.NRGBA {
+	src := image.NewNRGBA(image.Rect(0, 0, 5, 5))
+	for y := 0; y < 3; y += 1 {
+		for x := 0; x < 3; x += 1 {
+			src.SetNRGBA(1+x, 1+y, color.NRGBA{byte(x * 100), byte(y * 100), 0, 255})
+		}
+	}
+	return src
+}
+
+func nineSliceKey(flags SliceFlags) SliceKey {
+	slice := &AsepriteSliceChunk2022{Name: "panel", Flags: flags}
+	return SliceKey{parentChunk: slice, SliceXOriginCoords: 1, SliceYOriginCoords: 1,
+		SliceWidth: 3, SliceHeight: 3, CenterX: 1, CenterY: 1, CenterWidth: 1, CenterHeight: 1}
+}
+
+func TestNineSlice(t *testing.T) {
+	src := nineSliceSource()
+	// Expected column and row of the source region at each target pixel
+	for _, test := range []struct {
+		name       string
+		w, h       int
+		mode       StretchMode
+		cols, rows []int
+	}{
+		{"same size", 3, 3, StretchModeStretch, []int{0, 1, 2}, []int{0, 1, 2}},
+		{"stretch", 5, 4, StretchModeStretch, []int{0, 1, 1, 1, 2}, []int{0, 1, 1, 2}},
+		{"tile", 4, 5, StretchModeTile, []int{0, 1, 1, 2}, []int{0, 1, 1, 1, 2}},
+		{"borders only", 2, 2, StretchModeStretch, []int{0, 2}, []int{0, 2}},
+	} {
+		dst, err := NineSlice(src, nineSliceKey(SliceFlagNinePatch), test.w, test.h, test.mode)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if dst.Bounds() != image.Rect(0, 0, test.w, test.h) {
+			t.Errorf("%s: bounds %v", test.name, dst.Bounds())
+			continue
+		}
+		for y, row := range test.rows {
+			for x, col := range test.cols {
+				want := color.NRGBA{byte(col * 100), byte(row * 100), 0, 255}
+				if got := dst.NRGBAAt(x, y); got != want {
+					t.Errorf("%s: pixel %d,%d is %v, want %v", test.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestNineSliceErrors(t *testing.T) {
+	src := nineSliceSource()
+	handBuilt := nineSliceKey(0)
+	handBuilt.parentChunk = nil
+	emptyCenter := handBuilt
+	emptyCenter.CenterX, emptyCenter.CenterY, emptyCenter.CenterWidth, emptyCenter.CenterHeight = 0, 0, 0, 0
+	outside := nineSliceKey(SliceFlagNinePatch)
+	outside.CenterWidth = 3
+	for _, test := range []struct {
+		name string
+		key  SliceKey
+		w, h int
+		mode StretchMode
+		want string
+	}{
+		{"not 9-patch", nineSliceKey(0), 4, 4, StretchModeStretch, "not a 9-patch slice"},
+		{"hand built without center", emptyCenter, 4, 4, StretchModeStretch, "no 9-patch center"},
+		{"center outside", outside, 4, 4, StretchModeStretch, "lies outside"},
+		{"too small", nineSliceKey(SliceFlagNinePatch), 1, 4, StretchModeStretch, "smaller than the fixed borders"},
+		{"unknown mode", nineSliceKey(SliceFlagNinePatch), 4, 4, StretchMode(7), "unknown stretch mode"},
+	} {
+		_, err := NineSlice(src, test.key, test.w, test.h, test.mode)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+	if _, err := NineSlice(src, handBuilt, 4, 4, StretchModeStretch); err != nil {
+		t.Errorf("hand built key with a center: %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+package asefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// PropertiesMap is a set of user data properties. Key 0 holds the user's
+// own properties, any other key the properties of the extension with that
+// entry ID in an external files chunk.
+type PropertiesMap struct {
+	Key        uint32
+	Properties []Property
+}
+
+// Property is a named value of a properties map
+type Property struct {
+	Name  string
+	Value PropertyValue
+}
+
+/**
+ * PropertyValue is a value of the given type. Value holds bool or the integer
+ * type of the same name for those types, Fixed for FIXED, float32 for FLOAT,
+ * float64 for DOUBLE, string, image.Point for POINT and SIZE (X and Y being
+ * the width and height of a size), image.Rectangle for RECT, PropertyVector
+ * for vectors, []Property for nested maps and [16]byte for UUIDs.
+ *
+ * Encoding writes the zero value of Type when Value holds another Go type;
+ * Validate reports such values and types outside the spec.
+ */
+type PropertyValue struct {
+	Type  PropertyType
+	Value interface{}
+}
+
+// PropertyVector is a list of values, all of ElementType or each of its own
+// type when ElementType is 0. The Type of the elements is ignored when
+// ElementType is set.
+type PropertyVector struct {
+	ElementType PropertyType
+	Elements    []PropertyValue
+}
+
+// maxPropertyDepth bounds how deep vectors and maps nest, so that a small
+// chunk cannot recurse without end
+const maxPropertyDepth = 64
+
+// decodeProperties reads the properties maps of a user data chunk, which
+// start with their size in bytes, that DWORD included
+func decodeProperties(fr *fieldReader) ([]PropertiesMap, error) {
+	size := fr.uint32()
+	if size < 8 || uint64(size-4) > uint64(fr.Len()) {
+		return nil, FormatError(fmt.Sprintf("properties of %d bytes do not fit in the remaining %d bytes of the chunk",
+			size, fr.Len()+4))
+	}
+	pr := &fieldReader{fr.next(int(size - 4))}
+	numMaps := pr.uint32()
+	if err := checkCount(pr, uint64(numMaps), 8); err != nil {
+		return nil, err
+	}
+	maps := make([]PropertiesMap, numMaps)
+	for x := range maps {
+		maps[x].Key = pr.uint32()
+		properties, err := decodePropertyList(pr, 0)
+		if err != nil {
+			return nil, err
+		}
+		maps[x].Properties = properties
+	}
+	return maps, nil
+}
+
+// decodePropertyList reads a DWORD count followed by that many properties
+func decodePropertyList(fr *fieldReader, depth int) ([]Property, error) {
+	count := fr.uint32()
+	// A property is at least an empty name and its type
+	if err := checkCount(fr, uint64(count), 4); err != nil {
+		return nil, err
+	}
+	properties := make([]Property, count)
+	for x := range properties {
+		properties[x].Name = fr.string()
+		value, err := decodePropertyValue(fr, PropertyType(fr.uint16()), depth)
+		if err != nil {
+			return nil, fmt.Errorf("property %q: %w", properties[x].Name, err)
+		}
+		properties[x].Value = value
+	}
+	return properties, nil
+}
+
+func decodePropertyValue(fr *fieldReader, propertyType PropertyType, depth int) (PropertyValue, error) {
+	value := PropertyValue{Type: propertyType}
+	switch propertyType {
+	case PropertyTypeBool:
+		value.Value = fr.uint8() != 0
+	case PropertyTypeInt8:
+		value.Value = int8(fr.uint8())
+	case PropertyTypeUint8:
+		value.Value = fr.uint8()
+	case PropertyTypeInt16:
+		value.Value = fr.int16()
+	case PropertyTypeUint16:
+		value.Value = fr.uint16()
+	case PropertyTypeInt32:
+		value.Value = fr.int32()
+	case PropertyTypeUint32:
+		value.Value = fr.uint32()
+	case PropertyTypeInt64:
+		value.Value = int64(fr.uint64())
+	case PropertyTypeUint64:
+		value.Value = fr.uint64()
+	case PropertyTypeFixed:
+		value.Value = fr.fixed()
+	case PropertyTypeFloat:
+		value.Value = math.Float32frombits(fr.uint32())
+	case PropertyTypeDouble:
+		value.Value = math.Float64frombits(fr.uint64())
+	case PropertyTypeString:
+		value.Value = fr.string()
+	case PropertyTypePoint, PropertyTypeSize:
+		x, y := fr.int32(), fr.int32()
+		value.Value = image.Pt(int(x), int(y))
+	case PropertyTypeRect:
+		x, y := fr.int32(), fr.int32()
+		width, height := fr.int32(), fr.int32()
+		value.Value = image.Rect(0, 0, int(width), int(height)).Add(image.Pt(int(x), int(y)))
+	case PropertyTypeVector:
+		if depth >= maxPropertyDepth {
+			return value, FormatError(fmt.Sprintf("properties nest more than %d deep", maxPropertyDepth))
+		}
+		count := fr.uint32()
+		vector := PropertyVector{ElementType: PropertyType(fr.uint16())}
+		minSize := uint64(1)
+		if vector.ElementType == 0 {
+			minSize = 2
+		}
+		if err := checkCount(fr, uint64(count), minSize); err != nil {
+			return value, err
+		}
+		vector.Elements = make([]PropertyValue, count)
+		for x := range vector.Elements {
+			elementType := vector.ElementType
+			if elementType == 0 {
+				elementType = PropertyType(fr.uint16())
+			}
+			element, err := decodePropertyValue(fr, elementType, depth+1)
+			if err != nil {
+				return value, fmt.Errorf("element %d: %w", x, err)
+			}
+			vector.Elements[x] = element
+		}
+		value.Value = vector
+	case PropertyTypeMap:
+		if depth >= maxPropertyDepth {
+			return value, FormatError(fmt.Sprintf("properties nest more than %d deep", maxPropertyDepth))
+		}
+		properties, err := decodePropertyList(fr, depth+1)
+		if err != nil {
+			return value, err
+		}
+		value.Value = properties
+	case PropertyTypeUUID:
+		var uuid [16]byte
+		fr.skip(uuid[:])
+		value.Value = uuid
+	default:
+		return value, FormatError(fmt.Sprintf("unknown property type %v", propertyType))
+	}
+	return value, nil
+}
+
+// encodeProperties writes the properties maps of a user data chunk after
+// their size
+func encodeProperties(w io.Writer, maps []PropertiesMap) {
+	var propDat bytes.Buffer
+	binary.Write(&propDat, ble, uint32(len(maps)))
+	for _, propertiesMap := range maps {
+		binary.Write(&propDat, ble, propertiesMap.Key)
+		encodePropertyList(&propDat, propertiesMap.Properties)
+	}
+	binary.Write(w, ble, uint32(propDat.Len()+4))
+	w.Write(propDat.Bytes())
+}
+
+func encodePropertyList(w io.Writer, properties []Property) {
+	binary.Write(w, ble, uint32(len(properties)))
+	for _, property := range properties {
+		EncodeAseString(w, property.Name)
+		binary.Write(w, ble, property.Value.Type)
+		property.Value.encode(w)
+	}
+}
+
+// encode writes the value without its type
+func (value PropertyValue) encode(w io.Writer) {
+	switch value.Type {
+	case PropertyTypeBool:
+		v, _ := value.Value.(bool)
+		var b byte
+		if v {
+			b = 1
+		}
+		binary.Write(w, ble, b)
+	case PropertyTypeInt8:
+		v, _ := value.Value.(int8)
+		binary.Write(w, ble, v)
+	case PropertyTypeUint8:
+		v, _ := value.Value.(uint8)
+		binary.Write(w, ble, v)
+	case PropertyTypeInt16:
+		v, _ := value.Value.(int16)
+		binary.Write(w, ble, v)
+	case PropertyTypeUint16:
+		v, _ := value.Value.(uint16)
+		binary.Write(w, ble, v)
+	case PropertyTypeInt32:
+		v, _ := value.Value.(int32)
+		binary.Write(w, ble, v)
+	case PropertyTypeUint32:
+		v, _ := value.Value.(uint32)
+		binary.Write(w, ble, v)
+	case PropertyTypeInt64:
+		v, _ := value.Value.(int64)
+		binary.Write(w, ble, v)
+	case PropertyTypeUint64:
+		v, _ := value.Value.(uint64)
+		binary.Write(w, ble, v)
+	case PropertyTypeFixed:
+		v, _ := value.Value.(Fixed)
+		binary.Write(w, ble, v)
+	case PropertyTypeFloat:
+		v, _ := value.Value.(float32)
+		binary.Write(w, ble, v)
+	case PropertyTypeDouble:
+		v, _ := value.Value.(float64)
+		binary.Write(w, ble, v)
+	case PropertyTypeString:
+		v, _ := value.Value.(string)
+		EncodeAseString(w, v)
+	case PropertyTypePoint, PropertyTypeSize:
+		v, _ := value.Value.(image.Point)
+		binary.Write(w, ble, [2]int32{int32(v.X), int32(v.Y)})
+	case PropertyTypeRect:
+		v, _ := value.Value.(image.Rectangle)
+		binary.Write(w, ble, [4]int32{int32(v.Min.X), int32(v.Min.Y), int32(v.Dx()), int32(v.Dy())})
+	case PropertyTypeVector:
+		v, _ := value.Value.(PropertyVector)
+		binary.Write(w, ble, uint32(len(v.Elements)))
+		binary.Write(w, ble, v.ElementType)
+		for _, element := range v.Elements {
+			if v.ElementType != 0 {
+				element.Type = v.ElementType
+			} else {
+				binary.Write(w, ble, element.Type)
+			}
+			element.encode(w)
+		}
+	case PropertyTypeMap:
+		v, _ := value.Value.([]Property)
+		encodePropertyList(w, v)
+	case PropertyTypeUUID:
+		v, _ := value.Value.([16]byte)
+		w.Write(v[:])
+	}
+}
+
+// check returns why the value cannot be encoded as it is, or nil
+func (value PropertyValue) check() error {
+	var ok bool
+	switch value.Type {
+	case PropertyTypeBool:
+		_, ok = value.Value.(bool)
+	case PropertyTypeInt8:
+		_, ok = value.Value.(int8)
+	case PropertyTypeUint8:
+		_, ok = value.Value.(uint8)
+	case PropertyTypeInt16:
+		_, ok = value.Value.(int16)
+	case PropertyTypeUint16:
+		_, ok = value.Value.(uint16)
+	case PropertyTypeInt32:
+		_, ok = value.Value.(int32)
+	case PropertyTypeUint32:
+		_, ok = value.Value.(uint32)
+	case PropertyTypeInt64:
+		_, ok = value.Value.(int64)
+	case PropertyTypeUint64:
+		_, ok = value.Value.(uint64)
+	case PropertyTypeFixed:
+		_, ok = value.Value.(Fixed)
+	case PropertyTypeFloat:
+		_, ok = value.Value.(float32)
+	case PropertyTypeDouble:
+		_, ok = value.Value.(float64)
+	case PropertyTypeString:
+		_, ok = value.Value.(string)
+	case PropertyTypePoint, PropertyTypeSize:
+		_, ok = value.Value.(image.Point)
+	case PropertyTypeRect:
+		_, ok = value.Value.(image.Rectangle)
+	case PropertyTypeVector:
+		var vector PropertyVector
+		if vector, ok = value.Value.(PropertyVector); ok {
+			for x, element := range vector.Elements {
+				if vector.ElementType != 0 {
+					element.Type = vector.ElementType
+				}
+				if err := element.check(); err != nil {
+					return fmt.Errorf("element %d: %w", x, err)
+				}
+			}
+		}
+	case PropertyTypeMap:
+		var properties []Property
+		if properties, ok = value.Value.([]Property); ok {
+			for _, property := range properties {
+				if err := property.Value.check(); err != nil {
+					return fmt.Errorf("property %q: %w", property.Name, err)
+				}
+			}
+		}
+	case PropertyTypeUUID:
+		_, ok = value.Value.([16]byte)
+	default:
+		return fmt.Errorf("unknown property type %v", value.Type)
+	}
+	if !ok {
+		return fmt.Errorf("%v property holds a %T", value.Type, value.Value)
+	}
+	return nil
+}
+
+// cloneProperties deep copies properties maps, whose vectors and nested maps
+// would otherwise be shared
+func cloneProperties(maps []PropertiesMap) []PropertiesMap {
+	if maps == nil {
+		return nil
+	}
+	clone := make([]PropertiesMap, len(maps))
+	for x, propertiesMap := range maps {
+		clone[x] = PropertiesMap{propertiesMap.Key, clonePropertyList(propertiesMap.Properties)}
+	}
+	return clone
+}
+
+func clonePropertyList(properties []Property) []Property {
+	if properties == nil {
+		return nil
+	}
+	clone := make([]Property, len(properties))
+	for x, property := range properties {
+		clone[x] = Property{property.Name, property.Value.clone()}
+	}
+	return clone
+}
+
+func (value PropertyValue) clone() PropertyValue {
+	switch v := value.Value.(type) {
+	case PropertyVector:
+		if v.Elements != nil {
+			elements := make([]PropertyValue, len(v.Elements))
+			for x, element := range v.Elements {
+				elements[x] = element.clone()
+			}
+			v.Elements = elements
+		}
+		value.Value = v
+	case []Property:
+		value.Value = clonePropertyList(v)
+	}
+	return value
+}
@@ -0,0 +1,179 @@
+package asefile
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// allProperties holds a property of every type, with vectors and maps nested
+func allProperties() []PropertiesMap {
+	return []PropertiesMap{{Key: 0, Properties: []Property{
+		{"bool", PropertyValue{PropertyTypeBool, true}},
+		{"int8", PropertyValue{PropertyTypeInt8, int8(-8)}},
+		{"uint8", PropertyValue{PropertyTypeUint8, uint8(8)}},
+		{"int16", PropertyValue{PropertyTypeInt16, int16(-16)}},
+		{"uint16", PropertyValue{PropertyTypeUint16, uint16(16)}},
+		{"int32", PropertyValue{PropertyTypeInt32, int32(-32)}},
+		{"uint32", PropertyValue{PropertyTypeUint32, uint32(32)}},
+		{"int64", PropertyValue{PropertyTypeInt64, int64(-64)}},
+		{"uint64", PropertyValue{PropertyTypeUint64, uint64(1 << 63)}},
+		{"fixed", PropertyValue{PropertyTypeFixed, FixedFromFloat64(-1.25)}},
+		{"float", PropertyValue{PropertyTypeFloat, float32(0.5)}},
+		{"double", PropertyValue{PropertyTypeDouble, 0.125}},
+		{"string", PropertyValue{PropertyTypeString, "text"}},
+		{"point", PropertyValue{PropertyTypePoint, image.Pt(-3, 4)}},
+		{"size", PropertyValue{PropertyTypeSize, image.Pt(5, 6)}},
+		{"rect", PropertyValue{PropertyTypeRect, image.Rect(-1, -2, 3, 4)}},
+		{"vector", PropertyValue{PropertyTypeVector, PropertyVector{ElementType: PropertyTypeFixed, Elements: []PropertyValue{
+			{PropertyTypeFixed, FixedOne}, {PropertyTypeFixed, FixedFromFloat64(-0.5)},
+		}}}},
+		{"mixed", PropertyValue{PropertyTypeVector, PropertyVector{Elements: []PropertyValue{
+			{PropertyTypeString, "a"}, {PropertyTypeBool, false},
+			{PropertyTypeVector, PropertyVector{ElementType: PropertyTypeUint8, Elements: []PropertyValue{}}},
+		}}}},
+		{"map", PropertyValue{PropertyTypeMap, []Property{
+			{"nested", PropertyValue{PropertyTypeFixed, FixedFromInt(-2)}},
+		}}},
+		{"uuid", PropertyValue{PropertyTypeUUID, [16]byte{1, 2, 3, 15: 16}}},
+	}}, {Key: 3, Properties: []Property{}}}
+}
+
+func TestPropertiesRoundTrip(t *testing.T) {
+	userDat := AsepriteUserDataChunk2020{Flags: UserDataFlagHasText | UserDataFlagHasProperties, Text: "text",
+		Properties: allProperties()}
+	var buf bytes.Buffer
+	userDat.Encode(&buf)
+	var decoded AsepriteUserDataChunk2020
+	if err := decoded.Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, userDat) {
+		t.Errorf("decoded %+v, want %+v", decoded, userDat)
+	}
+
+	// A FIXED property as Aseprite writes it
+	data := []byte{4, 0, 0, 0, 29, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0,
+		5, 0, 'g', 'a', 'm', 'm', 'a', 0x0A, 0, 0x00, 0x80, 0x01, 0x00}
+	if err := decoded.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	want := []PropertiesMap{{Properties: []Property{{"gamma", PropertyValue{PropertyTypeFixed, FixedFromFloat64(1.5)}}}}}
+	if !reflect.DeepEqual(decoded.Properties, want) {
+		t.Errorf("decoded %+v, want %+v", decoded.Properties, want)
+	}
+	buf.Reset()
+	decoded.Encode(&buf)
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("encoded % x, want % x", buf.Bytes(), data)
+	}
+}
+
+func TestPropertiesErrors(t *testing.T) {
+	// nested wraps a vector in depth more vectors
+	nested := func(depth int) []byte {
+		data := []byte{0, 0, 0, 0, byte(PropertyTypeBool), 0}
+		for x := 0; x < depth; x += 1 {
+			data = append([]byte{1, 0, 0, 0, byte(PropertyTypeVector), 0}, data...)
+		}
+		return data
+	}
+	for _, test := range []struct {
+		name  string
+		props []byte
+		want  string
+	}{
+		{"unknown type", []byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0x14, 0}, "unknown property type"},
+		{"property count", []byte{1, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0, 0}, "65535 entries"},
+		{"map count", []byte{0xFF, 0xFF, 0, 0}, "65535 entries"},
+		{"vector count", []byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0x11, 0, 0xFF, 0xFF, 0, 0, 0, 0}, "65535 entries"},
+		{"nesting", append([]byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0x11, 0}, nested(maxPropertyDepth)...),
+			"nest more than"},
+	} {
+		size := uint32(len(test.props) + 4)
+		data := append(appendUint32Le(appendUint32Le(nil, uint32(UserDataFlagHasProperties)), size), test.props...)
+		var userDat AsepriteUserDataChunk2020
+		var formatErr FormatError
+		if err := userDat.Decode(bytes.NewReader(data)); !errors.As(err, &formatErr) || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want a FormatError about %q", test.name, err, test.want)
+		}
+	}
+
+	// Nesting up to the limit is fine
+	props := append([]byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0x11, 0}, nested(maxPropertyDepth-1)...)
+	data := append(appendUint32Le(appendUint32Le(nil, uint32(UserDataFlagHasProperties)), uint32(len(props)+4)), props...)
+	var userDat AsepriteUserDataChunk2020
+	if err := userDat.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("properties nested %d deep: %v", maxPropertyDepth, err)
+	}
+
+	// A size past the end of the chunk
+	data = appendUint32Le(appendUint32Le(nil, uint32(UserDataFlagHasProperties)), 100)
+	var formatErr FormatError
+	if err := userDat.Decode(bytes.NewReader(append(data, 0, 0, 0, 0))); !errors.As(err, &formatErr) {
+		t.Errorf("properties past the end of the chunk gave %v, want a FormatError", err)
+	}
+}
+
+func appendUint32Le(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func TestPropertiesSprite(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	layer := &aseFile.Frames[0].Layers[0]
+	layer.UserData = AsepriteUserDataChunk2020{Flags: UserDataFlagHasProperties, Properties: allProperties()}
+	decoded := encodeDecode(t, aseFile, EncodeOptions{})
+	if diff := Diff(aseFile, decoded, EqualOptions{}); diff != "" {
+		t.Fatalf("properties did not round trip:\n%s", diff)
+	}
+
+	// Clones and sprites have their own nested maps
+	clone, err := decoded.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sprite, err := SpriteFromFile(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nestedMap := func(props []PropertiesMap) []Property {
+		return props[0].Properties[18].Value.Value.([]Property)
+	}
+	nestedMap(clone.Frames[0].Layers[0].UserData.Properties)[0].Name = "changed"
+	nestedMap(sprite.Layers()[0].UserData.Properties)[0].Value.Value = FixedOne
+	if diff := Diff(aseFile, decoded, EqualOptions{}); diff != "" {
+		t.Errorf("changing a copy changed the sprite:\n%s", diff)
+	}
+	if diff := Diff(decoded, clone, EqualOptions{}); !strings.Contains(diff, "Properties[0].Properties[18]") {
+		t.Errorf("diff %q, want it to mention the nested map", diff)
+	}
+
+	toFile, err := sprite.ToFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := toFile.Frames[0].Layers[0].UserData.Properties
+	if got := nestedMap(properties)[0].Value.Value; got != FixedOne {
+		t.Errorf("ToFile wrote the nested property as %v, want 1", got)
+	}
+}
+
+func TestValidateProperties(t *testing.T) {
+	aseFile := decodeChica(t, DecodeOptions{})
+	aseFile.Frames[0].Layers[0].UserData = AsepriteUserDataChunk2020{Flags: UserDataFlagHasProperties,
+		Properties: []PropertiesMap{{Properties: []Property{
+			{"gamma", PropertyValue{PropertyTypeFixed, 1.5}},
+			{"list", PropertyValue{PropertyTypeVector, PropertyVector{Elements: []PropertyValue{{PropertyType(0x20), nil}}}}},
+			{"fine", PropertyValue{PropertyTypeFixed, FixedOne}},
+		}}}}
+	problems := aseFile.Validate()
+	if len(problems) != 2 || !strings.Contains(problems[0].Message, `"gamma"`) ||
+		!strings.Contains(problems[0].Message, "fixed property holds a float64") ||
+		!strings.Contains(problems[1].Message, "element 0: unknown property type PropertyType(32)") {
+		t.Errorf("got problems %v", problems)
+	}
+}
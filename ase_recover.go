@@ -0,0 +1,178 @@
+package asefile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/**
+ * RecoveryReport lists what was lost while decoding a damaged sprite with
+ * DecodeOptions.Recover.
+ */
+type RecoveryReport struct {
+	// Truncated is set when the file is shorter than the size in its header,
+	// MissingBytes being the difference
+	Truncated    bool
+	MissingBytes int64
+	// Indices of the frames in the header that were lost. They are kept in
+	// AsepriteFile.Frames as empty placeholder frames, so that the indices of
+	// tags, linked cels and the other frames stay those of the original.
+	LostFrames []int
+	// Ranges of bytes that were skipped looking for the next frame
+	Skipped []SkippedBytes
+	// Chunks that could not be decoded and were left out of their frame
+	DamagedChunks []DamagedChunk
+}
+
+// SkippedBytes is a range of the file that held no recognizable frame
+type SkippedBytes struct {
+	Offset, Length int64
+}
+
+// DamagedChunk is a chunk, by its position within its frame, that was lost.
+// Frame is the index of the frame in AsepriteFile.Frames.
+type DamagedChunk struct {
+	Frame, Chunk int
+	Type         ChunkType
+	Err          error
+}
+
+func (damaged DamagedChunk) String() string {
+	return fmt.Sprintf("frame %d chunk %d (type %04X): %v", damaged.Frame, damaged.Chunk, uint16(damaged.Type), damaged.Err)
+}
+
+// Lost reports whether anything at all was lost
+func (report *RecoveryReport) Lost() bool {
+	return report.Truncated || len(report.LostFrames) > 0 || len(report.Skipped) > 0 || len(report.DamagedChunks) > 0
+}
+
+// RecoveryReport returns what was lost when the sprite was decoded with
+// DecodeOptions.Recover, or nil when it was decoded without it
+func (aseFile *AsepriteFile) RecoveryReport() *RecoveryReport {
+	return aseFile.recovery
+}
+
+// recovering reports whether err is damage that recovery mode works around,
+// rather than a limit, cancellation or strict mode warning that always stops
+// decoding
+func (dec *decoder) recovering(err error) bool {
+	var warning Warning
+	if !dec.opts.Recover || errors.Is(err, ErrLimitExceeded) || errors.As(err, &warning) {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// damagedChunk records a chunk of the frame being decoded that was lost
+func (dec *decoder) damagedChunk(chunk int, chunkType ChunkType, err error) {
+	dec.report.DamagedChunks = append(dec.report.DamagedChunks, DamagedChunk{dec.frame, chunk, chunkType, err})
+}
+
+// lostFrame appends an empty frame in place of a frame that was lost
+func (aseFile *AsepriteFile) lostFrame(report *RecoveryReport) {
+	report.LostFrames = append(report.LostFrames, len(aseFile.Frames))
+	aseFile.Frames = append(aseFile.Frames, AsepriteFrame{
+		parentHeader:              &aseFile.Header,
+		MagicNumber:               0xF1FA,
+		FrameDurationMilliseconds: aseFile.Header.Speed,
+	})
+}
+
+// plausibleFrame reports whether a frame that fits in data starts at offset:
+// it has the frame magic number, a size that fits and, if it has chunks, a
+// first chunk whose size fits in the frame
+func plausibleFrame(data []byte, offset int) bool {
+	if offset+16 > len(data) || ble.Uint16(data[offset+4:]) != 0xF1FA {
+		return false
+	}
+	frameSize := int64(ble.Uint32(data[offset:]))
+	if frameSize < 16 || int64(offset)+frameSize > int64(len(data)) {
+		return false
+	}
+	if ble.Uint16(data[offset+6:]) == 0 && ble.Uint32(data[offset+12:]) == 0 {
+		return true
+	}
+	if frameSize < 16+6 {
+		return false
+	}
+	chunkSize := int64(ble.Uint32(data[offset+16:]))
+	return chunkSize >= 6 && chunkSize <= frameSize-16
+}
+
+// decodeRecover decodes the frames after the header in recovery mode. The
+// rest of the file is read into memory so that it can be scanned for frames.
+// Each range of skipped bytes is taken to have held one frame, and frames
+// missing at the end of the file are lost too.
+func (aseFile *AsepriteFile) decodeRecover(dec *decoder, r io.Reader) error {
+	report := &RecoveryReport{}
+	dec.report = report
+	aseFile.recovery = report
+	data, err := io.ReadAll(r)
+	if err != nil && !dec.recovering(err) {
+		return err
+	}
+	if missing := int64(aseFile.Header.FileSize) - 128 - int64(len(data)); missing > 0 {
+		report.Truncated = true
+		report.MissingBytes = missing
+	}
+	aseFile.Frames = make([]AsepriteFrame, 0, aseFile.Header.Frames)
+	offset := 0
+	// Set when the scan goes on after the header of a frame whose size is
+	// damaged, as the bytes skipped next are the rest of that frame
+	resync := false
+	for len(aseFile.Frames) < int(aseFile.Header.Frames) && offset+16 <= len(data) {
+		if err := dec.checkContext(); err != nil {
+			return err
+		}
+		frameSize := int(ble.Uint32(data[offset:]))
+		frameEnd := offset + frameSize
+		if ble.Uint16(data[offset+4:]) != 0xF1FA || frameSize < 16 {
+			next := offset + 1
+			for next+16 <= len(data) && !plausibleFrame(data, next) {
+				next += 1
+			}
+			if next+16 > len(data) {
+				next = len(data)
+			}
+			report.Skipped = append(report.Skipped, SkippedBytes{int64(128 + offset), int64(next - offset)})
+			offset = next
+			if !resync {
+				aseFile.lostFrame(report)
+			}
+			resync = false
+			continue
+		}
+		if frameEnd > len(data) || frameEnd < offset {
+			// Either the last frame was cut short, or its size is damaged
+			// and the scan continues after its header
+			frameEnd = len(data)
+		}
+		aseFile.Frames = append(aseFile.Frames, AsepriteFrame{parentHeader: &aseFile.Header})
+		dec.frame = len(aseFile.Frames) - 1
+		if err := aseFile.Frames[dec.frame].decode(dec, bytes.NewReader(data[offset:frameEnd])); err != nil {
+			return err
+		}
+		resync = false
+		switch {
+		case offset+frameSize <= len(data) && offset+frameSize > offset:
+			offset += frameSize
+		case report.Truncated:
+			offset = len(data)
+		default:
+			offset += 16
+			resync = true
+		}
+	}
+	for len(aseFile.Frames) < int(aseFile.Header.Frames) {
+		aseFile.lostFrame(report)
+	}
+	// Damaged zlib data keeps what inflated before the damage, as it does
+	// when decoding serially
+	if err := dec.inflateQueued(); err != nil && !dec.recovering(err) {
+		return err
+	}
+	return nil
+}
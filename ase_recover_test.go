@@ -0,0 +1,92 @@
+package asefile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// chicaFrameIndex returns the positions of the frames and chunks of Chica
+func chicaFrameIndex(t *testing.T, chica []byte) []FrameIndex {
+	t.Helper()
+	index, err := NewFileIndex(bytes.NewReader(chica), int64(len(chica)), DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index.Frames
+}
+
+func TestDecodeRecover(t *testing.T) {
+	chica := readChica(t)
+	frames := chicaFrameIndex(t, chica)
+	damage := func(edit func(data []byte)) []byte {
+		data := append([]byte(nil), chica...)
+		edit(data)
+		return data
+	}
+	firstCel := func(frame int) ChunkIndex {
+		for _, chunk := range frames[frame].Chunks {
+			if chunk.Type == ChunkCel {
+				return chunk
+			}
+		}
+		t.Fatalf("frame %d has no cel", frame)
+		return ChunkIndex{}
+	}
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		lost    []int
+		skipped int
+		damaged []int
+	}{
+		{"undamaged", chica, nil, 0, nil},
+		{"frame magic number", damage(func(data []byte) { data[frames[4].Offset+4] = 0 }), []int{4}, 1, nil},
+		{"two frames", damage(func(data []byte) {
+			data[frames[2].Offset+4] = 0
+			data[frames[9].Offset+5] = 0
+		}), []int{2, 9}, 2, nil},
+		{"frame size", damage(func(data []byte) { ble.PutUint32(data[frames[4].Offset:], 0xFFFFFF) }), nil, 1, nil},
+		{"truncated", chica[:frames[11].Offset], []int{11, 12}, 0, nil},
+		{"damaged cel", damage(func(data []byte) { data[firstCel(7).Offset+13] = 0 }), nil, 0, []int{7}},
+	} {
+		var aseFile AsepriteFile
+		if err := aseFile.DecodeWithOptions(bytes.NewReader(test.data), DecodeOptions{Recover: true}); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		report := aseFile.RecoveryReport()
+		if len(aseFile.Frames) != len(frames) {
+			t.Errorf("%s: recovered %d frames, want %d", test.name, len(aseFile.Frames), len(frames))
+			continue
+		}
+		var damaged []int
+		for _, chunk := range report.DamagedChunks {
+			damaged = append(damaged, chunk.Frame)
+		}
+		if !reflect.DeepEqual(report.LostFrames, test.lost) || len(report.Skipped) != test.skipped ||
+			!reflect.DeepEqual(damaged, test.damaged) {
+			t.Errorf("%s: lost frames %v, %d skipped ranges and damaged chunks in frames %v, want %v, %d and %v",
+				test.name, report.LostFrames, len(report.Skipped), damaged, test.lost, test.skipped, test.damaged)
+		}
+		if report.Lost() != (test.lost != nil || test.skipped > 0 || test.damaged != nil) {
+			t.Errorf("%s: Lost() is %v", test.name, report.Lost())
+		}
+
+		// The frames that were not lost keep their index
+		want := decodeChica(t, DecodeOptions{})
+		for _, x := range test.lost {
+			want.Frames[x] = AsepriteFrame{MagicNumber: 0xF1FA, FrameDurationMilliseconds: want.Header.Speed}
+		}
+		for _, x := range test.damaged {
+			want.Frames[x].Cels = want.Frames[x].Cels[1:]
+		}
+		for x := range want.Frames {
+			wantFrame := &AsepriteFile{Header: want.Header, Frames: want.Frames[x : x+1]}
+			gotFrame := &AsepriteFile{Header: want.Header, Frames: aseFile.Frames[x : x+1]}
+			if diff := Diff(wantFrame, gotFrame, EqualOptions{}); diff != "" {
+				t.Errorf("%s: frame %d differs:\n%s", test.name, x, diff)
+			}
+		}
+	}
+}
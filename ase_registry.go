@@ -0,0 +1,63 @@
+package asefile
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+/**
+ * Chunk is a chunk type decoded by code outside this package, such as private
+ * chunks a studio embeds in its sprites. Decode receives the chunk data
+ * without the size and type prefix, and Encode writes it back the same way.
+ *
+ * A chunk that implements AsepriteUserDatHolder receives the user data chunks
+ * that follow it. Those are not written back by Encode, a chunk that wants
+ * them kept has to write its own user data.
+ */
+type Chunk interface {
+	AsepriteCodec
+	ChunkType() ChunkType
+}
+
+var (
+	chunkRegistryLock sync.RWMutex
+	chunkRegistry     = map[ChunkType]func() Chunk{}
+)
+
+// RegisterChunk has chunks of chunkType decoded by a Chunk made with factory
+// and kept in AsepriteFrame.CustomChunks. Chunk types this package decodes
+// itself cannot be registered and panic, as does registering a type twice.
+func RegisterChunk(chunkType ChunkType, factory func() Chunk) {
+	switch chunkType {
+	case ChunkOldPalette0004, ChunkOldPalette0011, ChunkLayer, ChunkCel, ChunkCelExtra, ChunkColorProfile, ChunkExternalFiles,
+		ChunkTags, ChunkPalette, ChunkUserData, ChunkSlice, ChunkTileset:
+		panic(fmt.Sprintf("asefile: %v chunks are decoded by this package and cannot be registered", chunkType))
+	}
+	chunkRegistryLock.Lock()
+	defer chunkRegistryLock.Unlock()
+	if _, exists := chunkRegistry[chunkType]; exists {
+		panic(fmt.Sprintf("asefile: chunk type %04X registered twice", uint16(chunkType)))
+	}
+	chunkRegistry[chunkType] = factory
+}
+
+// registeredChunk returns the factory registered for chunkType, or nil
+func registeredChunk(chunkType ChunkType) func() Chunk {
+	chunkRegistryLock.RLock()
+	defer chunkRegistryLock.RUnlock()
+	return chunkRegistry[chunkType]
+}
+
+// decodeCustomChunk decodes a chunk of a registered type into the frame
+func (aseFrame *AsepriteFrame) decodeCustomChunk(factory func() Chunk, cr *fieldReader, lastUserdatHolder *AsepriteUserDatHolder) error {
+	chunk := factory()
+	if err := chunk.Decode(bytes.NewReader(cr.rest())); err != nil {
+		return err
+	}
+	aseFrame.CustomChunks = append(aseFrame.CustomChunks, chunk)
+	// User data after a chunk that cannot hold it must not go to the chunk
+	// before it
+	*lastUserdatHolder, _ = chunk.(AsepriteUserDatHolder)
+	return nil
+}
@@ -0,0 +1,118 @@
+package asefile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// plainChunk is a custom chunk holding its data as is, failing to decode
+// data that starts with 0xFF
+type plainChunk struct {
+	chunkType ChunkType
+	Data      []byte
+}
+
+func (chunk *plainChunk) ChunkType() ChunkType {
+	return chunk.chunkType
+}
+
+func (chunk *plainChunk) Decode(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if len(data) > 0 && data[0] == 0xFF {
+		return errors.New("test chunk data starts with 0xFF")
+	}
+	chunk.Data = data
+	return err
+}
+
+func (chunk *plainChunk) Encode(w io.Writer) {
+	w.Write(chunk.Data)
+}
+
+// testChunk is a custom chunk that also takes the user data after it
+type testChunk struct {
+	plainChunk
+	UserData []AsepriteUserDataChunk2020
+}
+
+func (chunk *testChunk) AddUserData(userDat AsepriteUserDataChunk2020) {
+	chunk.UserData = append(chunk.UserData, userDat)
+}
+
+// registerTestChunk registers factory for the duration of the test
+func registerTestChunk(t *testing.T, chunkType ChunkType, factory func() Chunk) {
+	RegisterChunk(chunkType, factory)
+	t.Cleanup(func() {
+		chunkRegistryLock.Lock()
+		defer chunkRegistryLock.Unlock()
+		delete(chunkRegistry, chunkType)
+	})
+}
+
+func userDataChunk(text string) []byte {
+	var buf bytes.Buffer
+	AsepriteUserDataChunk2020{Flags: UserDataFlagHasText, Text: text}.Encode(&buf)
+	return buf.Bytes()
+}
+
+func TestRegisterChunk(t *testing.T) {
+	registerTestChunk(t, 0x7C01, func() Chunk { return &testChunk{plainChunk: plainChunk{chunkType: 0x7C01}} })
+	registerTestChunk(t, 0x7C02, func() Chunk { return &plainChunk{chunkType: 0x7C02} })
+	sprite := insertChunk(readChica(t), 1, 0x7C01, []byte("first"))
+	sprite = insertChunk(sprite, 1, ChunkUserData, userDataChunk("kept"))
+	sprite = insertChunk(sprite, 1, 0x7C02, []byte("second"))
+	sprite = insertChunk(sprite, 1, ChunkUserData, userDataChunk("dropped"))
+	var aseFile AsepriteFile
+	if err := aseFile.DecodeWithOptions(bytes.NewReader(sprite), DecodeOptions{Strict: true}); err != nil {
+		t.Fatal(err)
+	}
+	custom := aseFile.Frames[1].CustomChunks
+	if len(custom) != 2 {
+		t.Fatalf("decoded %d custom chunks, want 2", len(custom))
+	}
+	first, ok := custom[0].(*testChunk)
+	if !ok || string(first.Data) != "first" || len(first.UserData) != 1 || first.UserData[0].Text != "kept" {
+		t.Errorf("first custom chunk decoded as %+v", custom[0])
+	}
+	second, ok := custom[1].(*plainChunk)
+	if !ok || string(second.Data) != "second" {
+		t.Errorf("second custom chunk decoded as %+v", custom[1])
+	}
+	// User data after a chunk that does not hold it goes nowhere
+	if len(first.UserData) != 1 {
+		t.Errorf("user data %v went to the chunk before", first.UserData)
+	}
+
+	// Custom chunks are written back after the built-in ones
+	decoded := encodeDecode(t, &aseFile, EncodeOptions{})
+	custom = decoded.Frames[1].CustomChunks
+	if len(custom) != 2 || string(custom[0].(*testChunk).Data) != "first" || string(custom[1].(*plainChunk).Data) != "second" {
+		t.Errorf("custom chunks encoded and decoded to %v", custom)
+	}
+
+	// A custom chunk that fails to decode fails the decode
+	damaged := insertChunk(readChica(t), 1, 0x7C01, []byte{0xFF})
+	if err := aseFile.Decode(bytes.NewReader(damaged)); err == nil {
+		t.Errorf("custom chunk decode error was ignored")
+	}
+}
+
+func TestRegisterChunkPanics(t *testing.T) {
+	registerTestChunk(t, 0x7C03, func() Chunk { return &plainChunk{chunkType: 0x7C03} })
+	for _, chunkType := range []ChunkType{ChunkOldPalette0004, ChunkOldPalette0011, ChunkLayer, ChunkCel, ChunkCelExtra,
+		ChunkColorProfile, ChunkExternalFiles, ChunkTags, ChunkPalette, ChunkUserData, ChunkSlice, ChunkTileset, 0x7C03} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %v did not panic", chunkType)
+				}
+			}()
+			RegisterChunk(chunkType, func() Chunk { return &plainChunk{chunkType: chunkType, Data: []byte("replaced")} })
+		}()
+	}
+	if registeredChunk(0x7C03)().(*plainChunk).Data != nil {
+		t.Errorf("registering 0x7C03 twice replaced the first factory")
+	}
+}
@@ -0,0 +1,562 @@
+package asefile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ErrImageTooLarge is returned, wrapped with the size, when a rendered frame,
+// scaled for the pixel ratio, or a sprite sheet would have more than
+// maxImagePixels pixels
+var ErrImageTooLarge = errors.New("image too large")
+
+// maxImagePixels bounds the images built from a sprite, 256MiB of RGBA
+// pixels, as a pixel ratio of 255:1 on a large canvas would otherwise
+// allocate gigabytes
+const maxImagePixels = 1 << 26
+
+func checkImageSize(width, height int64) error {
+	if width*height > maxImagePixels {
+		return fmt.Errorf("%w: %dx%d is over the limit of %d pixels", ErrImageTooLarge, width, height, maxImagePixels)
+	}
+	return nil
+}
+
+/**
+ * RenderOptions control how Sprite.Render flattens a frame.
+ *
+ * By default layers are blended on the gamma encoded values of their
+ * pixels, as Aseprite does, which darkens the edges where semi-transparent
+ * pixels meet. LinearBlend decodes the pixels to linear light with the
+ * transfer curve of the color profile of the sprite before layer and cel
+ * opacity and the blend modes are applied. Either way the result is
+ * converted from the color profile of the sprite to sRGB.
+ */
+type RenderOptions struct {
+	LinearBlend bool
+	// Premultiplied makes Render return an *image.RGBA, whose colors are
+	// premultiplied by alpha, rather than an *image.NRGBA
+	Premultiplied bool
+	// CorrectPixelRatio scales the frame by the pixel ratio of the sprite,
+	// repeating pixels, so that non-square pixels look as they do in
+	// Aseprite. Frames that would scale past the size limit give an error
+	// wrapping ErrImageTooLarge.
+	CorrectPixelRatio bool
+}
+
+// renderer flattens the layers of one frame into a canvas of premultiplied
+// colors, in linear light with LinearBlend and gamma encoded otherwise, both
+// in the color space of the sprite
+type renderer struct {
+	sprite    *Sprite
+	opts      RenderOptions
+	frame     *Frame
+	palette   []color.NRGBA
+	pixelSize int
+	converter *ColorConverter
+	// Working space value of each 8 bit value, per channel
+	toWorking [3][256]float64
+	bounds    image.Rectangle
+	// Scratch canvas the cel being drawn is placed on before it is blended
+	celCanvas []float64
+}
+
+// Render flattens the visible layers of a frame into an image of the size of
+// the sprite, blending them with their blend mode and opacity. Groups whose
+// blend mode or opacity is not the default are flattened on their own first.
+// Reference layers are left out. Sprites whose color profile can not be
+// converted to sRGB give the error of Sprite.ColorConverter, and frames of
+// more than 2^26 pixels, once scaled for the pixel ratio, an error wrapping
+// ErrImageTooLarge.
+func (sprite *Sprite) Render(frame int, opts RenderOptions) (image.Image, error) {
+	aseFrame := sprite.Frame(frame)
+	if aseFrame == nil {
+		return nil, fmt.Errorf("frame %d out of range, the sprite has %d frames", frame, len(sprite.frames))
+	}
+	width, height := sprite.frameSize(opts)
+	if err := checkImageSize(int64(width), int64(height)); err != nil {
+		return nil, err
+	}
+	converter, err := sprite.ColorConverter()
+	if err != nil {
+		return nil, err
+	}
+	r := &renderer{
+		sprite:    sprite,
+		opts:      opts,
+		frame:     aseFrame,
+		pixelSize: bytesPerPixel(&sprite.header),
+		converter: converter,
+		bounds:    image.Rect(0, 0, sprite.Width, sprite.Height),
+	}
+	for _, c := range sprite.palette {
+		r.palette = append(r.palette, color.NRGBAModel.Convert(c).(color.NRGBA))
+	}
+	for c := 0; c < 3; c += 1 {
+		for v := 0; v < 256; v += 1 {
+			r.toWorking[c][v] = float64(v) / 255
+			if opts.LinearBlend {
+				r.toWorking[c][v] = converter.linear(c, byte(v))
+			}
+		}
+	}
+	size := sprite.Width * sprite.Height * 4
+	canvas := make([]float64, size)
+	r.celCanvas = make([]float64, size)
+	var topLevel []*Layer
+	for _, layer := range sprite.layers {
+		if layer.Parent == nil {
+			topLevel = append(topLevel, layer)
+		}
+	}
+	if err := r.layers(canvas, topLevel); err != nil {
+		return nil, err
+	}
+	img := r.image(canvas)
+	if opts.CorrectPixelRatio {
+		pixelWidth, pixelHeight := sprite.PixelRatio()
+		return ScaleNearest(img, pixelWidth, pixelHeight)
+	}
+	return img, nil
+}
+
+// frameSize returns the size of a rendered frame
+func (sprite *Sprite) frameSize(opts RenderOptions) (width, height int) {
+	width, height = sprite.Width, sprite.Height
+	if opts.CorrectPixelRatio {
+		pixelWidth, pixelHeight := sprite.PixelRatio()
+		width, height = width*pixelWidth, height*pixelHeight
+	}
+	return width, height
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// layers blends the visible layers onto canvas, from the bottom up
+func (r *renderer) layers(canvas []float64, layers []*Layer) error {
+	header := &r.sprite.header
+	for _, layer := range layers {
+		if !layer.Flags.Visible() || layer.Flags.Reference() {
+			continue
+		}
+		opacity, blendMode := float64(layer.Opacity)/255, layer.BlendMode
+		if layer.Type == LayerTypeGroup {
+			if !header.Flags.GroupOpacityValid() {
+				opacity, blendMode = 1, BlendNormal
+			}
+			if opacity == 1 && blendMode == BlendNormal {
+				if err := r.layers(canvas, layer.Children); err != nil {
+					return err
+				}
+				continue
+			}
+			group := make([]float64, len(canvas))
+			if err := r.layers(group, layer.Children); err != nil {
+				return err
+			}
+			r.blend(canvas, group, r.bounds, blendMode, opacity)
+			continue
+		}
+		cel := r.frame.Cel(layer.Index)
+		if cel == nil {
+			continue
+		}
+		if !header.Flags.LayerOpacityValid() {
+			opacity = 1
+		}
+		opacity *= float64(cel.Opacity) / 255
+		bounds, err := r.drawCel(cel)
+		if err != nil {
+			return err
+		}
+		r.blend(canvas, r.celCanvas, bounds, blendMode, opacity)
+	}
+	return nil
+}
+
+// drawCel places the premultiplied colors of a cel on the cel canvas and
+// returns the part of the canvas it covers
+func (r *renderer) drawCel(cel *Cel) (image.Rectangle, error) {
+	at, err := r.celColors(cel)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	bounds := cel.Bounds().Intersect(r.bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+			c := at(x-cel.Position.X, y-cel.Position.Y)
+			alpha := float64(c.A) / 255
+			offset := (y*r.sprite.Width + x) * 4
+			r.celCanvas[offset] = r.toWorking[0][c.R] * alpha
+			r.celCanvas[offset+1] = r.toWorking[1][c.G] * alpha
+			r.celCanvas[offset+2] = r.toWorking[2][c.B] * alpha
+			r.celCanvas[offset+3] = alpha
+		}
+	}
+	return bounds, nil
+}
+
+// celColors returns the color of the cel at a position relative to its top
+// left corner, looking tiles up in the tileset for tilemap cels
+func (r *renderer) celColors(cel *Cel) (func(x, y int) color.NRGBA, error) {
+	pixels, err := cel.Pixels()
+	if err != nil {
+		return nil, err
+	}
+	pixelSize := r.pixelSize
+	background := cel.Layer.Flags.Background()
+	if !cel.Tilemap() {
+		width, height := int(cel.source.WidthInPix), int(cel.source.HeightInPix)
+		if len(pixels) < width*height*pixelSize {
+			return nil, FormatError(fmt.Sprintf("cel on layer %q has %d bytes of pixels, %dx%d needs %d",
+				cel.Layer.Name, len(pixels), width, height, width*height*pixelSize))
+		}
+		return func(x, y int) color.NRGBA {
+			return r.pixel(pixels[(y*width+x)*pixelSize:], background)
+		}, nil
+	}
+	tileset := cel.Layer.Tileset
+	if tileset == nil {
+		return nil, fmt.Errorf("tilemap layer %q has no tileset", cel.Layer.Name)
+	}
+	tiles, err := tileset.Pixels()
+	if err != nil {
+		return nil, err
+	}
+	source := cel.source
+	tileSize := int(source.BitsPerTile) / 8
+	width, height := int(source.WidthInTiles), int(source.HeightInTiles)
+	if tileSize == 0 || len(pixels) < width*height*tileSize {
+		return nil, FormatError(fmt.Sprintf("tilemap cel on layer %q has %d bytes of tiles, %dx%d of %d bits needs %d",
+			cel.Layer.Name, len(pixels), width, height, source.BitsPerTile, width*height*tileSize))
+	}
+	tileWidth, tileHeight := tileset.TileWidth, tileset.TileHeight
+	if tileWidth < 1 || tileHeight < 1 {
+		return nil, FormatError(fmt.Sprintf("tileset %q has tiles of %dx%d", tileset.Name, tileWidth, tileHeight))
+	}
+	// Tilesets kept in an external file have no pixels and draw as transparent
+	if tiles != nil && len(tiles) < tileset.NumTiles*tileWidth*tileHeight*pixelSize {
+		return nil, FormatError(fmt.Sprintf("tileset %q has %d bytes of pixels, %d tiles of %dx%d need %d",
+			tileset.Name, len(tiles), tileset.NumTiles, tileWidth, tileHeight, tileset.NumTiles*tileWidth*tileHeight*pixelSize))
+	}
+	return func(x, y int) color.NRGBA {
+		offset := (y/tileHeight*width + x/tileWidth) * tileSize
+		var tile uint32
+		switch tileSize {
+		case 1:
+			tile = uint32(pixels[offset])
+		case 2:
+			tile = uint32(binary.LittleEndian.Uint16(pixels[offset:]))
+		default:
+			tile = binary.LittleEndian.Uint32(pixels[offset:])
+		}
+		id := int(tile & source.BitMaskForTileID)
+		if id >= tileset.NumTiles || len(tiles) == 0 {
+			return color.NRGBA{}
+		}
+		x, y = x%tileWidth, y%tileHeight
+		if tile&source.BitMaskFor90CWRot != 0 {
+			x, y = y, x
+		}
+		if tile&source.BitMaskForXFlip != 0 {
+			x = tileWidth - 1 - x
+		}
+		if tile&source.BitMaskForYFlip != 0 {
+			y = tileHeight - 1 - y
+		}
+		if x >= tileWidth || y >= tileHeight {
+			return color.NRGBA{}
+		}
+		return r.pixel(tiles[((id*tileHeight+y)*tileWidth+x)*pixelSize:], background)
+	}, nil
+}
+
+// pixel reads a pixel in the color mode of the sprite, transparent if pix is
+// too short to hold one
+func (r *renderer) pixel(pix []byte, background bool) color.NRGBA {
+	if len(pix) < r.pixelSize {
+		return color.NRGBA{}
+	}
+	switch r.sprite.ColorMode {
+	case ColorModeIndexed:
+		index := int(pix[0])
+		if (index == r.sprite.TransparentIndex && !background) || index >= len(r.palette) {
+			return color.NRGBA{}
+		}
+		return r.palette[index]
+	case ColorModeGrayscale:
+		return color.NRGBA{pix[0], pix[0], pix[0], pix[1]}
+	}
+	return color.NRGBA{pix[0], pix[1], pix[2], pix[3]}
+}
+
+/**
+ * blend composites the premultiplied colors of src over those of dst within
+ * bounds, following the W3C compositing model: where the backdrop is opaque
+ * the source color is replaced by the result of the blend mode, where it is
+ * transparent the source color is used as is.
+ */
+func (r *renderer) blend(dst, src []float64, bounds image.Rectangle, blendMode BlendMode, opacity float64) {
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
+			offset := (y*r.sprite.Width + x) * 4
+			srcAlpha := src[offset+3]
+			if srcAlpha == 0 || opacity == 0 {
+				continue
+			}
+			cs := [3]float64{src[offset] / srcAlpha, src[offset+1] / srcAlpha, src[offset+2] / srcAlpha}
+			dstAlpha := dst[offset+3]
+			mixed := cs
+			if dstAlpha > 0 && blendMode != BlendNormal {
+				cb := [3]float64{dst[offset] / dstAlpha, dst[offset+1] / dstAlpha, dst[offset+2] / dstAlpha}
+				blended := blendColors(blendMode, cb, cs)
+				for c := 0; c < 3; c += 1 {
+					mixed[c] = (1-dstAlpha)*cs[c] + dstAlpha*blended[c]
+				}
+			}
+			srcAlpha *= opacity
+			for c := 0; c < 3; c += 1 {
+				dst[offset+c] = srcAlpha*mixed[c] + dst[offset+c]*(1-srcAlpha)
+			}
+			dst[offset+3] = srcAlpha + dstAlpha*(1-srcAlpha)
+		}
+	}
+}
+
+// image encodes the canvas to 8 bit sRGB colors
+func (r *renderer) image(canvas []float64) image.Image {
+	var pix []byte
+	var img image.Image
+	if r.opts.Premultiplied {
+		rgba := image.NewRGBA(r.bounds)
+		pix, img = rgba.Pix, rgba
+	} else {
+		nrgba := image.NewNRGBA(r.bounds)
+		pix, img = nrgba.Pix, nrgba
+	}
+	for x := 0; x < len(canvas); x += 4 {
+		alpha := math.Min(canvas[x+3], 1)
+		if alpha <= 0 {
+			continue
+		}
+		var v [3]float64
+		for c := range v {
+			v[c] = math.Max(0, math.Min(canvas[x+c]/alpha, 1))
+		}
+		var c color.NRGBA
+		if r.opts.LinearBlend {
+			c.R, c.G, c.B = r.converter.fromLinear(v[0], v[1], v[2])
+		} else {
+			c = r.converter.Convert(color.NRGBA{byte(math.Round(v[0] * 255)), byte(math.Round(v[1] * 255)),
+				byte(math.Round(v[2] * 255)), 255})
+		}
+		pix[x], pix[x+1], pix[x+2] = c.R, c.G, c.B
+		if r.opts.Premultiplied {
+			for c := 0; c < 3; c += 1 {
+				pix[x+c] = byte(math.Round(float64(pix[x+c]) * alpha))
+			}
+		}
+		pix[x+3] = byte(math.Round(alpha * 255))
+	}
+	return img
+}
+
+// blendColors applies a blend mode to the straight colors of the backdrop cb
+// and the source cs. Unknown blend modes blend as normal.
+func blendColors(blendMode BlendMode, cb, cs [3]float64) [3]float64 {
+	switch blendMode {
+	case BlendHue:
+		return setLum(setSat(cs, sat(cb)), lum(cb))
+	case BlendSaturation:
+		return setLum(setSat(cb, sat(cs)), lum(cb))
+	case BlendColor:
+		return setLum(cs, lum(cb))
+	case BlendLuminosity:
+		return setLum(cb, lum(cs))
+	}
+	var blended [3]float64
+	for c := 0; c < 3; c += 1 {
+		blended[c] = blendChannel(blendMode, cb[c], cs[c])
+	}
+	return blended
+}
+
+// blendChannel applies a separable blend mode to one channel
+func blendChannel(blendMode BlendMode, b, s float64) float64 {
+	switch blendMode {
+	case BlendMultiply:
+		return b * s
+	case BlendScreen:
+		return b + s - b*s
+	case BlendOverlay:
+		return blendChannel(BlendHardLight, s, b)
+	case BlendDarken:
+		return math.Min(b, s)
+	case BlendLighten:
+		return math.Max(b, s)
+	case BlendColorDodge:
+		switch {
+		case b == 0:
+			return 0
+		case s >= 1:
+			return 1
+		}
+		return math.Min(1, b/(1-s))
+	case BlendColorBurn:
+		switch {
+		case b >= 1:
+			return 1
+		case s == 0:
+			return 0
+		}
+		return 1 - math.Min(1, (1-b)/s)
+	case BlendHardLight:
+		if s <= 0.5 {
+			return b * 2 * s
+		}
+		return blendChannel(BlendScreen, b, 2*s-1)
+	case BlendSoftLight:
+		if s <= 0.5 {
+			return b - (1-2*s)*b*(1-b)
+		}
+		d := math.Sqrt(b)
+		if b <= 0.25 {
+			d = ((16*b-12)*b + 4) * b
+		}
+		return b + (2*s-1)*(d-b)
+	case BlendDifference:
+		return math.Abs(b - s)
+	case BlendExclusion:
+		return b + s - 2*b*s
+	case BlendAddition:
+		return math.Min(1, b+s)
+	case BlendSubtract:
+		return math.Max(0, b-s)
+	case BlendDivide:
+		switch {
+		case b == 0:
+			return 0
+		case b >= s:
+			return 1
+		}
+		return b / s
+	}
+	return s
+}
+
+func lum(c [3]float64) float64 {
+	return 0.3*c[0] + 0.59*c[1] + 0.11*c[2]
+}
+
+func setLum(c [3]float64, l float64) [3]float64 {
+	d := l - lum(c)
+	for x := range c {
+		c[x] += d
+	}
+	// Clip the color back into range keeping its luminosity
+	l = lum(c)
+	low := math.Min(c[0], math.Min(c[1], c[2]))
+	high := math.Max(c[0], math.Max(c[1], c[2]))
+	for x := range c {
+		if low < 0 {
+			c[x] = l + (c[x]-l)*l/(l-low)
+		}
+		if high > 1 {
+			c[x] = l + (c[x]-l)*(1-l)/(high-l)
+		}
+	}
+	return c
+}
+
+func sat(c [3]float64) float64 {
+	return math.Max(c[0], math.Max(c[1], c[2])) - math.Min(c[0], math.Min(c[1], c[2]))
+}
+
+func setSat(c [3]float64, s float64) [3]float64 {
+	// Indices of the smallest, middle and largest channels
+	low, mid, high := 0, 1, 2
+	if c[low] > c[mid] {
+		low, mid = mid, low
+	}
+	if c[mid] > c[high] {
+		mid, high = high, mid
+	}
+	if c[low] > c[mid] {
+		low, mid = mid, low
+	}
+	var result [3]float64
+	if c[high] > c[low] {
+		result[mid] = (c[mid] - c[low]) * s / (c[high] - c[low])
+		result[high] = s
+	}
+	return result
+}
+
+/**
+ * ScaleNearest scales img by whole factors, repeating each pixel scaleX times
+ * across and scaleY times down, as done to correct for the pixel ratio of a
+ * sprite. It is exported so images flattened by other means can be corrected
+ * the same way as Render, RenderSheet and GIF do.
+ *
+ * *image.NRGBA, *image.RGBA and *image.Paletted images keep their type, the
+ * others are converted to *image.NRGBA. The result starts at the scaled top
+ * left corner of img. Results of more than 2^26 pixels give an error
+ * wrapping ErrImageTooLarge.
+ */
+func ScaleNearest(img image.Image, scaleX, scaleY int) (image.Image, error) {
+	if scaleX < 1 {
+		scaleX = 1
+	}
+	if scaleY < 1 {
+		scaleY = 1
+	}
+	bounds := img.Bounds()
+	if err := checkImageSize(int64(bounds.Dx())*int64(scaleX), int64(bounds.Dy())*int64(scaleY)); err != nil {
+		return nil, err
+	}
+	scaled := image.Rect(bounds.Min.X*scaleX, bounds.Min.Y*scaleY, bounds.Max.X*scaleX, bounds.Max.Y*scaleY)
+	var srcPix, dstPix []byte
+	var srcStride, dstStride, pixelSize int
+	var result image.Image
+	switch src := img.(type) {
+	case *image.NRGBA:
+		dst := image.NewNRGBA(scaled)
+		srcPix, srcStride, dstPix, dstStride, pixelSize = src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, dst.Pix, dst.Stride, 4
+		result = dst
+	case *image.RGBA:
+		dst := image.NewRGBA(scaled)
+		srcPix, srcStride, dstPix, dstStride, pixelSize = src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, dst.Pix, dst.Stride, 4
+		result = dst
+	case *image.Paletted:
+		dst := image.NewPaletted(scaled, src.Palette)
+		srcPix, srcStride, dstPix, dstStride, pixelSize = src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, dst.Pix, dst.Stride, 1
+		result = dst
+	default:
+		dst := image.NewNRGBA(scaled)
+		for y := scaled.Min.Y; y < scaled.Max.Y; y += 1 {
+			for x := scaled.Min.X; x < scaled.Max.X; x += 1 {
+				// Offsets from the origin are never negative, so that the
+				// division rounds down even left of or above 0,0
+				dst.Set(x, y, img.At(bounds.Min.X+(x-scaled.Min.X)/scaleX, bounds.Min.Y+(y-scaled.Min.Y)/scaleY))
+			}
+		}
+		return dst, nil
+	}
+	for y := 0; y < scaled.Dy(); y += 1 {
+		srcRow := srcPix[y/scaleY*srcStride:]
+		dstRow := dstPix[y*dstStride:]
+		for x := 0; x < scaled.Dx(); x += 1 {
+			copy(dstRow[x*pixelSize:(x+1)*pixelSize], srcRow[x/scaleX*pixelSize:])
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,192 @@
+package asefile
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func renderSprite(t *testing.T, aseFile *AsepriteFile, opts RenderOptions) (image.Image, error) {
+	t.Helper()
+	sprite, err := SpriteFromFile(aseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sprite.Render(0, opts)
+}
+
+func TestRenderTilemap(t *testing.T) {
+	img, err := renderSprite(t, tilemapSprite(t, 3, 1, 0, 2, 0x20000001), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{0, 0, color.NRGBA{64, 0, 0, 255}},
+		{4, 0, color.NRGBA{0, 0, 0, 255}},
+		{0, 4, color.NRGBA{128, 0, 0, 255}},
+		// Tile 1 flipped across
+		{7, 7, color.NRGBA{64, 0, 0, 255}},
+	} {
+		if got := img.(*image.NRGBA).NRGBAAt(test.x, test.y); got != test.want {
+			t.Errorf("pixel %d,%d is %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestRenderShortTileset(t *testing.T) {
+	// The tileset holds one tile of the three it claims
+	aseFile := tilemapSprite(t, 3, 0, 1, 2, 2)
+	tileset := &aseFile.Frames[0].Tilesets[0]
+	tileset.CompressedTilesetImg = compressData(t, solidImage(4, 4, color.NRGBA{255, 0, 0, 255}).Pix)
+	tileset.CompressedDatLen = uint32(len(tileset.CompressedTilesetImg))
+	var formatErr FormatError
+	if _, err := renderSprite(t, aseFile, RenderOptions{}); !errors.As(err, &formatErr) {
+		t.Errorf("short tileset gave %v, want a FormatError", err)
+	}
+
+	aseFile = tilemapSprite(t, 1, 0)
+	aseFile.Frames[0].Tilesets[0].TileWidth = 0
+	if _, err := renderSprite(t, aseFile, RenderOptions{}); !errors.As(err, &formatErr) {
+		t.Errorf("tiles of zero width gave %v, want a FormatError", err)
+	}
+}
+
+func TestRenderColorProfile(t *testing.T) {
+	gray := solidImage(1, 1, color.NRGBA{128, 128, 128, 255})
+	builder := NewSprite(1, 1, ColorModeRGBA)
+	builder.SetCel(builder.AddFrame(100), builder.AddLayer("Layer", -1), gray, image.Pt(0, 0))
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		profile AsepriteColorProfileChunk2007
+		want    uint8
+	}{
+		{"sRGB", AsepriteColorProfileChunk2007{Type: ColorProfileSRGB}, 128},
+		// Values of a gamma 1.0 profile are linear light already
+		{"fixed gamma 1.0", AsepriteColorProfileChunk2007{Type: ColorProfileSRGB, Flags: ColorProfileFlagFixedGamma,
+			FixedGamma: FixedFromFloat64(1)}, 188},
+		{"ICC gamma 2.2", AsepriteColorProfileChunk2007{Type: ColorProfileICC,
+			ICCProfileDat: iccProfile(srgbPrimaries, paraTag(0, 2.2))}, 129},
+	} {
+		aseFile.Frames[0].ColorProfiles = []AsepriteColorProfileChunk2007{test.profile}
+		for _, opts := range []RenderOptions{{}, {LinearBlend: true}, {LinearBlend: true, Premultiplied: true}} {
+			img, err := renderSprite(t, aseFile, opts)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if r, _, _, _ := img.At(0, 0).RGBA(); absDiff(uint8(r>>8), test.want) > 1 {
+				t.Errorf("%s, %+v: 128 rendered as %d, want %d", test.name, opts, r>>8, test.want)
+			}
+		}
+	}
+
+	aseFile.Frames[0].ColorProfiles = []AsepriteColorProfileChunk2007{{Type: ColorProfileICC,
+		ICCProfileDat: iccProfile(srgbPrimaries, paraTag(5, 1))}}
+	if _, err := renderSprite(t, aseFile, RenderOptions{}); !errors.Is(err, ErrUnsupportedColorProfile) {
+		t.Errorf("unsupported profile gave %v, want ErrUnsupportedColorProfile", err)
+	}
+}
+
+func TestPixelRatio(t *testing.T) {
+	for _, test := range []struct {
+		pixelWidth, pixelHeight byte
+		width, height           int
+	}{{0, 0, 1, 1}, {2, 0, 1, 1}, {2, 1, 2, 1}, {2, 2, 1, 1}, {4, 6, 2, 3}, {255, 255, 1, 1}} {
+		header := AsepriteHeader{PixelWidth: test.pixelWidth, PixelHeight: test.pixelHeight}
+		if width, height := header.PixelRatio(); width != test.width || height != test.height {
+			t.Errorf("%d:%d gave %d:%d, want %d:%d", test.pixelWidth, test.pixelHeight, width, height, test.width, test.height)
+		}
+	}
+}
+
+func TestScaleNearest(t *testing.T) {
+	palette := color.Palette{color.NRGBA{}, color.NRGBA{255, 0, 0, 255}}
+	paletted := image.NewPaletted(image.Rect(0, 0, 2, 1), palette)
+	paletted.Pix[1] = 1
+	scaled, err := ScaleNearest(paletted, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, ok := scaled.(*image.Paletted)
+	if !ok || result.Bounds() != image.Rect(0, 0, 4, 3) {
+		t.Fatalf("scaled to %T of %v", scaled, scaled.Bounds())
+	}
+	if result.ColorIndexAt(1, 2) != 0 || result.ColorIndexAt(2, 2) != 1 {
+		t.Errorf("scaled pixels %v", result.Pix)
+	}
+
+	// Images without a fast path, with bounds left of and above 0,0
+	gray := image.NewGray(image.Rect(-2, -1, 1, 1))
+	for x := range gray.Pix {
+		gray.Pix[x] = uint8(x * 10)
+	}
+	scaled, err = ScaleNearest(gray, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scaled.Bounds() != image.Rect(-4, -3, 2, 3) {
+		t.Fatalf("scaled to %v", scaled.Bounds())
+	}
+	for y := scaled.Bounds().Min.Y; y < scaled.Bounds().Max.Y; y += 1 {
+		for x := scaled.Bounds().Min.X; x < scaled.Bounds().Max.X; x += 1 {
+			// Counted from the top left corner, as dividing -1 by 2 truncates to 0
+			srcX, srcY := (x+4)/2-2, (y+3)/3-1
+			want := gray.GrayAt(srcX, srcY).Y
+			if r, _, _, _ := scaled.At(x, y).RGBA(); uint8(r>>8) != want {
+				t.Errorf("pixel %d,%d is %d, want %d from %d,%d", x, y, r>>8, want, srcX, srcY)
+			}
+		}
+	}
+	if _, err := ScaleNearest(image.NewNRGBA(image.Rect(0, 0, 2048, 1)), 255, 255); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("scaling past the size limit gave %v, want ErrImageTooLarge", err)
+	}
+}
+
+// pixelRatioSprite builds a 2x1 sprite of 2:1 pixels whose two frames show
+// the given colors
+func pixelRatioSprite(t *testing.T, frames ...[2]color.NRGBA) *Sprite {
+	t.Helper()
+	builder := NewSprite(2, 1, ColorModeRGBA)
+	layer := builder.AddLayer("Layer", -1)
+	for x, colors := range frames {
+		img := solidImage(2, 1, colors[0])
+		img.SetNRGBA(1, 0, colors[1])
+		builder.SetCel(builder.AddFrame(100/(x+1)), layer, img, image.Pt(0, 0))
+	}
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aseFile.Header.PixelWidth, aseFile.Header.PixelHeight = 2, 1
+	sprite, err := SpriteFromFile(aseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sprite
+}
+
+func TestRenderPixelRatio(t *testing.T) {
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	sprite := pixelRatioSprite(t, [2]color.NRGBA{red, blue})
+	img, err := sprite.Render(0, RenderOptions{CorrectPixelRatio: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nrgba := img.(*image.NRGBA)
+	if nrgba.Bounds() != image.Rect(0, 0, 4, 1) || nrgba.NRGBAAt(1, 0) != red || nrgba.NRGBAAt(2, 0) != blue {
+		t.Errorf("rendered %v with pixels %v", nrgba.Bounds(), nrgba.Pix)
+	}
+
+	// 8192x8192 is at the limit, but not once scaled
+	sprite.Width, sprite.Height = 1<<13, 1<<13
+	if _, err := sprite.Render(0, RenderOptions{CorrectPixelRatio: true}); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("scaling past the size limit gave %v, want ErrImageTooLarge", err)
+	}
+}
@@ -0,0 +1,727 @@
+package asefile
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+)
+
+/**
+ * Sprite is a sprite with the references between its chunks resolved: layers
+ * know their group, cels their layer and frame, linked cels the cel whose
+ * pixels they show and tags their frames. It is built from a decoded file with
+ * SpriteFromFile and lowered back to chunks with ToFile.
+ *
+ * The cels of a sprite share their pixel data with the file it was built from.
+ */
+type Sprite struct {
+	Width, Height int
+	ColorMode     ColorMode
+	// Palette index that is transparent in indexed sprites
+	TransparentIndex int
+	header           AsepriteHeader
+	colorProfiles    []AsepriteColorProfileChunk2007
+	externalFiles    []AsepriteExternalFilesChunk2008Entry
+	layers           []*Layer
+	frames           []*Frame
+	tags             []*Tag
+	slices           []*Slice
+	tilesets         []*Tileset
+	palette          color.Palette
+	userData         UserData
+}
+
+// UserData is the text, color and properties that can be attached to the
+// sprite and most of its parts. Color is nil when none was set.
+type UserData struct {
+	Text       string
+	Color      color.Color
+	Properties []PropertiesMap
+}
+
+// Layer is a layer of the sprite, Index being its position in Sprite.Layers
+type Layer struct {
+	Index     int
+	Name      string
+	Type      LayerType
+	Flags     LayerFlags
+	BlendMode BlendMode
+	Opacity   byte
+	// Group the layer is in, or nil at the top level
+	Parent   *Layer
+	Children []*Layer
+	// Tileset of a tilemap layer
+	Tileset  *Tileset
+	UserData UserData
+}
+
+// Frame is a frame of the sprite, Index being its position in Sprite.Frames
+type Frame struct {
+	Index    int
+	Duration time.Duration
+	// Cels by layer index, nil where the layer has no cel
+	cels []*Cel
+}
+
+// Cel is the content of a layer in a frame
+type Cel struct {
+	Layer *Layer
+	Frame *Frame
+	// Position of the top left corner of the cel in the sprite
+	Position image.Point
+	Opacity  byte
+	// Frame holding the cel this one links to, or nil if it is not linked
+	Linked *Frame
+	sprite *Sprite
+	// The chunk of the cel itself and the one holding its pixels, which
+	// differ for a linked cel
+	chunk, source *AsepriteCelChunk2005
+}
+
+// Tag is a named range of frames played in a loop direction
+type Tag struct {
+	Name      string
+	From, To  *Frame
+	Direction LoopDirection
+	Color     color.Color
+	UserData  UserData
+	sprite    *Sprite
+}
+
+// Slice is a named region of the sprite whose bounds may change over the
+// animation, with one key for every frame they change at
+type Slice struct {
+	Name     string
+	Keys     []SliceKey
+	UserData UserData
+	flags    SliceFlags
+}
+
+// Tileset is a set of tiles of the same size used by tilemap layers
+type Tileset struct {
+	ID                    int
+	Name                  string
+	TileWidth, TileHeight int
+	NumTiles              int
+	BaseIndex             int
+	UserData              UserData
+	colorMode             ColorMode
+	chunk                 AsepriteTilesetChunk2023
+}
+
+// SpriteFromFile builds the model of a decoded sprite, failing if the chunks
+// refer to layers, frames or tilesets that do not exist
+func SpriteFromFile(aseFile *AsepriteFile) (*Sprite, error) {
+	if len(aseFile.Frames) == 0 {
+		return nil, fmt.Errorf("sprite has no frames")
+	}
+	first := &aseFile.Frames[0]
+	sprite := &Sprite{
+		Width:            int(aseFile.Header.WidthInPixels),
+		Height:           int(aseFile.Header.HeightInPixels),
+		ColorMode:        aseFile.Header.ColorDepth,
+		TransparentIndex: int(aseFile.Header.PaletteEntry),
+		header:           aseFile.Header,
+		colorProfiles:    append([]AsepriteColorProfileChunk2007(nil), first.ColorProfiles...),
+		palette:          framePalette(first),
+		userData:         userDataFromChunk(first.SpriteUserData),
+	}
+	for x := range aseFile.Frames {
+		for _, extFiles := range aseFile.Frames[x].ExternalFiles {
+			sprite.externalFiles = append(sprite.externalFiles, extFiles.ExternalFile...)
+		}
+		for _, tileset := range aseFile.Frames[x].Tilesets {
+			sprite.tilesets = append(sprite.tilesets, &Tileset{
+				ID:         int(tileset.TilesetID),
+				Name:       tileset.Name,
+				TileWidth:  int(tileset.TileWidth),
+				TileHeight: int(tileset.TileHeight),
+				NumTiles:   int(tileset.NumTiles),
+				BaseIndex:  int(tileset.BaseIndex),
+				UserData:   userDataFromChunk(tileset.UserData),
+				colorMode:  sprite.ColorMode,
+				chunk:      tileset,
+			})
+		}
+	}
+	if err := sprite.resolveLayers(first.Layers); err != nil {
+		return nil, err
+	}
+	if err := sprite.resolveFrames(aseFile.Frames); err != nil {
+		return nil, err
+	}
+	for x := range aseFile.Frames {
+		if err := sprite.resolveTags(&aseFile.Frames[x].Tags); err != nil {
+			return nil, err
+		}
+		for _, sliceChunk := range aseFile.Frames[x].Slices {
+			sprite.slices = append(sprite.slices, &Slice{
+				Name:     sliceChunk.Name,
+				Keys:     append([]SliceKey(nil), sliceChunk.SliceKeysData...),
+				UserData: userDataFromChunk(sliceChunk.UserData),
+				flags:    sliceChunk.Flags,
+			})
+		}
+	}
+	return sprite, nil
+}
+
+// resolveLayers builds the layer tree from the child levels of the layers
+func (sprite *Sprite) resolveLayers(layerChunks []AsepriteLayerChunk2004) error {
+	var groupPath []*Layer
+	for x, layerChunk := range layerChunks {
+		layer := &Layer{
+			Index:     x,
+			Name:      layerChunk.LayerName,
+			Type:      layerChunk.LayerType,
+			Flags:     layerChunk.Flags,
+			BlendMode: layerChunk.BlendMode,
+			Opacity:   layerChunk.Opacity,
+			UserData:  userDataFromChunk(layerChunk.UserData),
+		}
+		level := int(layerChunk.LayerChildLevel)
+		if level > len(groupPath) {
+			return fmt.Errorf("layer %q: child level %d has no parent group", layer.Name, level)
+		}
+		if level > 0 {
+			layer.Parent = groupPath[level-1]
+			if layer.Parent.Type != LayerTypeGroup {
+				return fmt.Errorf("layer %q: parent %q is not a group", layer.Name, layer.Parent.Name)
+			}
+			layer.Parent.Children = append(layer.Parent.Children, layer)
+		}
+		groupPath = append(groupPath[:level], layer)
+		if layer.Type == LayerTypeTilemap {
+			layer.Tileset = sprite.Tileset(int(layerChunk.TilesetIndex))
+			if layer.Tileset == nil {
+				return fmt.Errorf("layer %q: tileset %d not found", layer.Name, layerChunk.TilesetIndex)
+			}
+		}
+		sprite.layers = append(sprite.layers, layer)
+	}
+	return nil
+}
+
+// resolveFrames places the cels of every frame on their layers and follows
+// the links of linked cels
+func (sprite *Sprite) resolveFrames(aseFrames []AsepriteFrame) error {
+	for x := range aseFrames {
+		frame := &Frame{
+			Index:    x,
+			Duration: time.Duration(aseFrames[x].FrameDurationMilliseconds) * time.Millisecond,
+			cels:     make([]*Cel, len(sprite.layers)),
+		}
+		for y := range aseFrames[x].Cels {
+			chunk := &aseFrames[x].Cels[y]
+			if int(chunk.LayerIndex) >= len(sprite.layers) {
+				return fmt.Errorf("frame %d: cel layer %d out of range, the sprite has %d layers", x, chunk.LayerIndex, len(sprite.layers))
+			}
+			frame.cels[chunk.LayerIndex] = &Cel{
+				Layer:    sprite.layers[chunk.LayerIndex],
+				Frame:    frame,
+				Position: image.Pt(int(chunk.X), int(chunk.Y)),
+				Opacity:  chunk.OpacityLevel,
+				sprite:   sprite,
+				chunk:    chunk,
+				source:   chunk,
+			}
+		}
+		sprite.frames = append(sprite.frames, frame)
+	}
+	for _, frame := range sprite.frames {
+		for layer, cel := range frame.cels {
+			if cel == nil || cel.chunk.CelType != CelTypeLinked {
+				continue
+			}
+			visited := make(map[int]bool)
+			linked := cel
+			for linked != nil && linked.chunk.CelType == CelTypeLinked && !visited[linked.Frame.Index] {
+				visited[linked.Frame.Index] = true
+				linked = sprite.Frame(int(linked.chunk.FramePosToLinkWith)).Cel(layer)
+			}
+			if linked == nil || linked.chunk.CelType == CelTypeLinked {
+				return fmt.Errorf("frame %d: cel on layer %d links to a missing or circular frame", frame.Index, layer)
+			}
+			cel.Linked = sprite.frames[cel.chunk.FramePosToLinkWith]
+			cel.source = linked.chunk
+		}
+	}
+	return nil
+}
+
+// resolveTags adds the tags of a tags chunk, with the colors of their user
+// data when they have one
+func (sprite *Sprite) resolveTags(tagsChunk *AsepriteTagsChunk2018) error {
+	for x, tagChunk := range tagsChunk.Tags {
+		if tagChunk.FromFrame > tagChunk.ToFrame || int(tagChunk.ToFrame) >= len(sprite.frames) {
+			return fmt.Errorf("tag %q: frame range %d..%d is invalid", tagChunk.TagName, tagChunk.FromFrame, tagChunk.ToFrame)
+		}
+		tag := &Tag{
+			Name:      tagChunk.TagName,
+			From:      sprite.frames[tagChunk.FromFrame],
+			To:        sprite.frames[tagChunk.ToFrame],
+			Direction: tagChunk.LoopAnimDirection,
+			Color:     color.NRGBA{tagChunk.TagColor[0], tagChunk.TagColor[1], tagChunk.TagColor[2], 255},
+			sprite:    sprite,
+		}
+		if x < len(tagsChunk.UserData) {
+			tag.UserData = userDataFromChunk(tagsChunk.UserData[x])
+			if tag.UserData.Color != nil {
+				tag.Color = tag.UserData.Color
+			}
+		}
+		sprite.tags = append(sprite.tags, tag)
+	}
+	return nil
+}
+
+// framePalette returns the palette set by the palette chunks of a frame,
+// falling back to the old palette chunks written by Aseprite before 1.2
+func framePalette(aseFrame *AsepriteFrame) color.Palette {
+	var palette color.Palette
+	// The header counts colors in a WORD, sizes and indices past that come
+	// from damaged chunks
+	const maxColors = 0x10000
+	grow := func(size int) bool {
+		if size > maxColors {
+			return false
+		}
+		for len(palette) < size {
+			palette = append(palette, color.NRGBA{A: 255})
+		}
+		return true
+	}
+	for _, paletteChunk := range aseFrame.Palettes {
+		size := int64(paletteChunk.PaletteSize)
+		if size > maxColors {
+			size = maxColors
+		}
+		if int(size) < len(palette) {
+			palette = palette[:size]
+		}
+		grow(int(size))
+		for x, entry := range paletteChunk.PaletteEntries {
+			index := int64(paletteChunk.FirstColIndexToChange) + int64(x)
+			if !grow(int(index + 1)) {
+				break
+			}
+			palette[index] = color.NRGBA{entry.R, entry.G, entry.B, entry.A}
+		}
+	}
+	if palette != nil {
+		return palette
+	}
+	for _, oldPalette := range aseFrame.OldPalettes0004 {
+		index := 0
+		for _, packet := range oldPalette.Packets {
+			index += int(packet.NumPalletteEntriesToSkip)
+			for _, rgb := range packet.Colors {
+				grow(index + 1)
+				palette[index] = color.NRGBA{rgb.R, rgb.G, rgb.B, 255}
+				index += 1
+			}
+		}
+	}
+	for _, oldPalette := range aseFrame.OldPalettes0011 {
+		index := 0
+		for _, packet := range oldPalette.Packets {
+			index += int(packet.NumPalletteEntriesToSkip)
+			for _, rgb := range packet.Colors {
+				grow(index + 1)
+				// Colors range 0-63
+				palette[index] = color.NRGBA{rgb.R<<2 | rgb.R>>4, rgb.G<<2 | rgb.G>>4, rgb.B<<2 | rgb.B>>4, 255}
+				index += 1
+			}
+		}
+	}
+	return palette
+}
+
+func userDataFromChunk(userDat AsepriteUserDataChunk2020) UserData {
+	var userData UserData
+	if userDat.Flags.HasText() {
+		userData.Text = userDat.Text
+	}
+	if userDat.Flags.HasColor() {
+		userData.Color = color.NRGBA{userDat.R, userDat.G, userDat.B, userDat.A}
+	}
+	if userDat.Flags.HasProperties() {
+		userData.Properties = cloneProperties(userDat.Properties)
+	}
+	return userData
+}
+
+// chunk lowers the user data to a chunk, whose flags are 0 when it is empty
+func (userData UserData) chunk() AsepriteUserDataChunk2020 {
+	var userDat AsepriteUserDataChunk2020
+	if userData.Text != "" {
+		userDat.Flags |= UserDataFlagHasText
+		userDat.Text = userData.Text
+	}
+	if userData.Color != nil {
+		col := color.NRGBAModel.Convert(userData.Color).(color.NRGBA)
+		userDat.Flags |= UserDataFlagHasColor
+		userDat.R, userDat.G, userDat.B, userDat.A = col.R, col.G, col.B, col.A
+	}
+	if len(userData.Properties) > 0 {
+		userDat.Flags |= UserDataFlagHasProperties
+		userDat.Properties = cloneProperties(userData.Properties)
+	}
+	return userDat
+}
+
+// Layers returns the layers from the bottom up, each group before the layers
+// in it
+func (sprite *Sprite) Layers() []*Layer {
+	return sprite.layers
+}
+
+// Layer returns the layer with the given index, or nil if there is none
+func (sprite *Sprite) Layer(index int) *Layer {
+	if index < 0 || index >= len(sprite.layers) {
+		return nil
+	}
+	return sprite.layers[index]
+}
+
+// Frames returns the frames in playing order
+func (sprite *Sprite) Frames() []*Frame {
+	return sprite.frames
+}
+
+// Frame returns the frame with the given index, or nil if there is none
+func (sprite *Sprite) Frame(index int) *Frame {
+	if index < 0 || index >= len(sprite.frames) {
+		return nil
+	}
+	return sprite.frames[index]
+}
+
+func (sprite *Sprite) Tags() []*Tag {
+	return sprite.tags
+}
+
+// Tag returns the tag with the given name, or nil if there is none
+func (sprite *Sprite) Tag(name string) *Tag {
+	for _, tag := range sprite.tags {
+		if tag.Name == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+func (sprite *Sprite) Slices() []*Slice {
+	return sprite.slices
+}
+
+func (sprite *Sprite) Tilesets() []*Tileset {
+	return sprite.tilesets
+}
+
+// Tileset returns the tileset with the given ID, or nil if there is none
+func (sprite *Sprite) Tileset(id int) *Tileset {
+	for _, tileset := range sprite.tilesets {
+		if tileset.ID == id {
+			return tileset
+		}
+	}
+	return nil
+}
+
+// Palette returns the palette of the first frame
+func (sprite *Sprite) Palette() color.Palette {
+	return sprite.palette
+}
+
+// UserData returns the user data of the sprite itself
+func (sprite *Sprite) UserData() UserData {
+	return sprite.userData
+}
+
+// PixelRatio returns the width and height of a pixel of the sprite, see
+// AsepriteHeader.PixelRatio
+func (sprite *Sprite) PixelRatio() (width, height int) {
+	return sprite.header.PixelRatio()
+}
+
+// Path returns the names of the groups the layer is in and its own, joined by
+// LayerPath
+func (layer *Layer) Path() string {
+	names := []string{layer.Name}
+	for parent := layer.Parent; parent != nil; parent = parent.Parent {
+		names = append([]string{parent.Name}, names...)
+	}
+	return LayerPath(names...)
+}
+
+// Cel returns the cel of the layer with the given index, or nil if the layer
+// has no cel in this frame
+func (frame *Frame) Cel(layer int) *Cel {
+	if frame == nil || layer < 0 || layer >= len(frame.cels) {
+		return nil
+	}
+	return frame.cels[layer]
+}
+
+// Cels returns the cels of the frame in layer order
+func (frame *Frame) Cels() []*Cel {
+	var cels []*Cel
+	for _, cel := range frame.cels {
+		if cel != nil {
+			cels = append(cels, cel)
+		}
+	}
+	return cels
+}
+
+// Tilemap reports whether the cel holds tile indices rather than pixels
+func (cel *Cel) Tilemap() bool {
+	return cel.source.CelType == CelTypeCompressedTilemap
+}
+
+// Bounds returns the area of the sprite the cel covers
+func (cel *Cel) Bounds() image.Rectangle {
+	w, h := int(cel.source.WidthInPix), int(cel.source.HeightInPix)
+	if cel.Tilemap() {
+		w, h = int(cel.source.WidthInTiles), int(cel.source.HeightInTiles)
+		if tileset := cel.Layer.Tileset; tileset != nil {
+			w, h = w*tileset.TileWidth, h*tileset.TileHeight
+		}
+	}
+	return image.Rect(cel.Position.X, cel.Position.Y, cel.Position.X+w, cel.Position.Y+h)
+}
+
+// Pixels returns the raw pixels of an image cel or the tile data of a
+// tilemap cel, those of the cel it links to for a linked cel
+func (cel *Cel) Pixels() ([]byte, error) {
+	return cel.source.Pixels()
+}
+
+// Image returns the pixels of an image cel, placed at its position in the
+// sprite. Indexed sprites give an *image.Paletted whose transparent index is
+// transparent except on the background layer, other sprites an *image.NRGBA.
+func (cel *Cel) Image() (image.Image, error) {
+	if cel.Tilemap() {
+		return nil, fmt.Errorf("cel on layer %q is a tilemap", cel.Layer.Name)
+	}
+	pixels, err := cel.Pixels()
+	if err != nil {
+		return nil, err
+	}
+	bounds := cel.Bounds()
+	pixelSize := bytesPerPixel(&cel.sprite.header)
+	if len(pixels) < bounds.Dx()*bounds.Dy()*pixelSize {
+		return nil, FormatError(fmt.Sprintf("cel on layer %q has %d bytes of pixels, %dx%d needs %d",
+			cel.Layer.Name, len(pixels), bounds.Dx(), bounds.Dy(), bounds.Dx()*bounds.Dy()*pixelSize))
+	}
+	switch cel.sprite.ColorMode {
+	case ColorModeIndexed:
+		palette := append(color.Palette(nil), cel.sprite.palette...)
+		transparent := cel.sprite.TransparentIndex
+		if !cel.Layer.Flags.Background() && transparent < len(palette) {
+			palette[transparent] = color.NRGBA{}
+		}
+		img := image.NewPaletted(bounds, palette)
+		copy(img.Pix, pixels)
+		return img, nil
+	case ColorModeGrayscale:
+		img := image.NewNRGBA(bounds)
+		for x := 0; x < bounds.Dx()*bounds.Dy(); x += 1 {
+			gray, alpha := pixels[x*2], pixels[x*2+1]
+			copy(img.Pix[x*4:], []byte{gray, gray, gray, alpha})
+		}
+		return img, nil
+	}
+	img := image.NewNRGBA(bounds)
+	copy(img.Pix, pixels)
+	return img, nil
+}
+
+// Frames returns the frames of the tag from From to To
+func (tag *Tag) Frames() []*Frame {
+	return tag.sprite.frames[tag.From.Index : tag.To.Index+1]
+}
+
+// Key returns the key of the slice in effect at frame, the last one starting
+// at or before it. It returns false if the slice starts after frame.
+func (slice *Slice) Key(frame int) (SliceKey, bool) {
+	var found SliceKey
+	ok := false
+	for _, key := range slice.Keys {
+		if int(key.FrameNumber) > frame {
+			break
+		}
+		found, ok = key, true
+	}
+	return found, ok
+}
+
+// Pixels inflates the tileset image, the tiles stacked from top to bottom in
+// TileWidth x TileHeight*NumTiles pixels. It returns nil for a tileset kept
+// in an external file.
+func (tileset *Tileset) Pixels() ([]byte, error) {
+	if !tileset.chunk.Flags.TilesInFile() {
+		return nil, nil
+	}
+	size := int64(tileset.TileWidth) * int64(tileset.TileHeight) * int64(tileset.NumTiles) * int64(tileset.colorMode/8)
+	return inflateData(bytes.NewReader(tileset.chunk.CompressedTilesetImg), size)
+}
+
+// ToFile lowers the sprite back to chunks, ready to be encoded. The layer
+// tree is written from the Parent of each layer, cels and tags refer to
+// layers and frames by their position in Layers and Frames, and the frame and
+// chunk counts are filled in. The file and frame sizes are left to Encode.
+func (sprite *Sprite) ToFile() (*AsepriteFile, error) {
+	if len(sprite.frames) == 0 || len(sprite.frames) > 0xFFFF {
+		return nil, fmt.Errorf("sprite has %d frames, 1 to 65535 are allowed", len(sprite.frames))
+	}
+	if sprite.Width <= 0 || sprite.Height <= 0 || sprite.Width > 0xFFFF || sprite.Height > 0xFFFF {
+		return nil, fmt.Errorf("sprite size %dx%d out of range", sprite.Width, sprite.Height)
+	}
+	palette := sprite.palette
+	if len(palette) == 0 {
+		palette = color.Palette{color.Black}
+	}
+	if len(palette) > 0xFFFF {
+		return nil, fmt.Errorf("palette size %d out of range", len(palette))
+	}
+	aseFile := &AsepriteFile{Header: sprite.header, Frames: make([]AsepriteFrame, len(sprite.frames))}
+	header := &aseFile.Header
+	header.MagicNumber = 0xA5E0
+	header.Frames = uint16(len(sprite.frames))
+	header.FileSize = 0
+	header.WidthInPixels, header.HeightInPixels = uint16(sprite.Width), uint16(sprite.Height)
+	header.ColorDepth = sprite.ColorMode
+	header.PaletteEntry = byte(sprite.TransparentIndex)
+	header.NumberOfColors = uint16(len(palette))
+
+	first := &aseFile.Frames[0]
+	first.ColorProfiles = append([]AsepriteColorProfileChunk2007(nil), sprite.colorProfiles...)
+	if len(sprite.externalFiles) > 0 {
+		first.ExternalFiles = []AsepriteExternalFilesChunk2008{{
+			NumEntries:   uint32(len(sprite.externalFiles)),
+			ExternalFile: append([]AsepriteExternalFilesChunk2008Entry(nil), sprite.externalFiles...),
+		}}
+	}
+	first.Palettes = []AsepritePaletteChunk2019{newPaletteChunk(palette)}
+	first.SpriteUserData = sprite.userData.chunk()
+	for _, tileset := range sprite.tilesets {
+		tilesetChunk := tileset.chunk
+		tilesetChunk.TilesetID = uint32(tileset.ID)
+		tilesetChunk.Name = tileset.Name
+		tilesetChunk.TileWidth, tilesetChunk.TileHeight = uint16(tileset.TileWidth), uint16(tileset.TileHeight)
+		tilesetChunk.NumTiles = uint32(tileset.NumTiles)
+		tilesetChunk.BaseIndex = int16(tileset.BaseIndex)
+		tilesetChunk.UserData = tileset.UserData.chunk()
+		first.Tilesets = append(first.Tilesets, tilesetChunk)
+	}
+	layerIndex := make(map[*Layer]int, len(sprite.layers))
+	for x, layer := range sprite.layers {
+		layerIndex[layer] = x
+		layerChunk := AsepriteLayerChunk2004{
+			Flags:     layer.Flags,
+			LayerType: layer.Type,
+			BlendMode: layer.BlendMode,
+			Opacity:   layer.Opacity,
+			LayerName: layer.Name,
+			UserData:  layer.UserData.chunk(),
+		}
+		for parent := layer.Parent; parent != nil; parent = parent.Parent {
+			layerChunk.LayerChildLevel += 1
+		}
+		if layer.Type == LayerTypeTilemap {
+			if layer.Tileset == nil {
+				return nil, fmt.Errorf("tilemap layer %q has no tileset", layer.Name)
+			}
+			layerChunk.TilesetIndex = uint32(layer.Tileset.ID)
+		}
+		first.Layers = append(first.Layers, layerChunk)
+	}
+	frameIndex := make(map[*Frame]int, len(sprite.frames))
+	for x, frame := range sprite.frames {
+		frameIndex[frame] = x
+	}
+
+	for x, frame := range sprite.frames {
+		aseFrame := &aseFile.Frames[x]
+		aseFrame.parentHeader = header
+		aseFrame.MagicNumber = 0xF1FA
+		aseFrame.FrameDurationMilliseconds = uint16(frame.Duration / time.Millisecond)
+		for _, cel := range frame.Cels() {
+			layer, ok := layerIndex[cel.Layer]
+			if !ok {
+				return nil, fmt.Errorf("frame %d: cel layer %q is not in the sprite", x, cel.Layer.Name)
+			}
+			celChunk := *cel.source
+			if cel.Linked != nil {
+				linked, ok := frameIndex[cel.Linked]
+				if !ok {
+					return nil, fmt.Errorf("frame %d: cel on layer %q links to a frame not in the sprite", x, cel.Layer.Name)
+				}
+				celChunk = AsepriteCelChunk2005{CelType: CelTypeLinked, FramePosToLinkWith: uint16(linked)}
+			}
+			celChunk.parentHeader = header
+			celChunk.LayerIndex = uint16(layer)
+			celChunk.X, celChunk.Y = int16(cel.Position.X), int16(cel.Position.Y)
+			celChunk.OpacityLevel = cel.Opacity
+			celChunk.Extra = cel.chunk.Extra
+			aseFrame.Cels = append(aseFrame.Cels, celChunk)
+		}
+	}
+
+	if len(sprite.tags) > 0 {
+		tagsChunk := AsepriteTagsChunk2018{NumTags: uint16(len(sprite.tags))}
+		hasUserData := false
+		for _, tag := range sprite.tags {
+			from, fromOk := frameIndex[tag.From]
+			to, toOk := frameIndex[tag.To]
+			if !fromOk || !toOk || from > to {
+				return nil, fmt.Errorf("tag %q: frame range is invalid", tag.Name)
+			}
+			tagChunk := AsepriteTagsChunk2018Tag{
+				FromFrame:         uint16(from),
+				ToFrame:           uint16(to),
+				LoopAnimDirection: tag.Direction,
+				TagName:           tag.Name,
+			}
+			if tag.Color != nil {
+				col := color.NRGBAModel.Convert(tag.Color).(color.NRGBA)
+				tagChunk.TagColor = [3]byte{col.R, col.G, col.B}
+			}
+			tagsChunk.Tags = append(tagsChunk.Tags, tagChunk)
+			userDat := tag.UserData.chunk()
+			tagsChunk.UserData = append(tagsChunk.UserData, userDat)
+			hasUserData = hasUserData || userDat.Flags != 0
+		}
+		// User data follows the tags in order, so it is all or nothing
+		if !hasUserData {
+			tagsChunk.UserData = nil
+		}
+		first.Tags = tagsChunk
+	}
+	first.Slices = make([]AsepriteSliceChunk2022, len(sprite.slices))
+	for x, slice := range sprite.slices {
+		sliceChunk := &first.Slices[x]
+		sliceChunk.NumSliceKeys = uint32(len(slice.Keys))
+		sliceChunk.Flags = slice.flags
+		sliceChunk.Name = slice.Name
+		sliceChunk.UserData = slice.UserData.chunk()
+		sliceChunk.SliceKeysData = append([]SliceKey(nil), slice.Keys...)
+		for y := range sliceChunk.SliceKeysData {
+			key := &sliceChunk.SliceKeysData[y]
+			key.parentChunk = sliceChunk
+			if key.CenterWidth != 0 || key.CenterHeight != 0 {
+				sliceChunk.Flags |= SliceFlagNinePatch
+			}
+			if key.PivotX != 0 || key.PivotY != 0 {
+				sliceChunk.Flags |= SliceFlagHasPivot
+			}
+		}
+	}
+
+	aseFile.fillCounts()
+	return aseFile, nil
+}
@@ -0,0 +1,159 @@
+package asefile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+func compressData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zwriter := zlib.NewWriter(&buf)
+	zwriter.Write(data)
+	if err := zwriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tilemapSprite builds an 8x8 RGBA sprite with a tileset of numTiles 4x4
+// tiles, tile n filled with the color {n * 64, 0, 0, 255}, and one frame
+// holding a 2x2 tilemap cel of the given tile IDs
+func tilemapSprite(t *testing.T, numTiles int, tileIDs ...uint32) *AsepriteFile {
+	t.Helper()
+	builder := NewSprite(8, 8, ColorModeRGBA)
+	layer := builder.AddLayer("Tiles", -1)
+	builder.AddFrame(100)
+	aseFile, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tiles []byte
+	for x := 0; x < numTiles; x += 1 {
+		col := color.NRGBA{uint8(x * 64), 0, 0, 255}
+		tiles = append(tiles, solidImage(4, 4, col).Pix...)
+	}
+	tilesetImg := compressData(t, tiles)
+	first := &aseFile.Frames[0]
+	first.Tilesets = []AsepriteTilesetChunk2023{{
+		Flags:                TilesetFlagTilesInFile,
+		NumTiles:             uint32(numTiles),
+		TileWidth:            4,
+		TileHeight:           4,
+		Name:                 "Tileset",
+		CompressedDatLen:     uint32(len(tilesetImg)),
+		CompressedTilesetImg: tilesetImg,
+	}}
+	first.Layers[layer].LayerType = LayerTypeTilemap
+	tileData := make([]byte, 4*len(tileIDs))
+	for x, id := range tileIDs {
+		binary.LittleEndian.PutUint32(tileData[4*x:], id)
+	}
+	first.Cels = []AsepriteCelChunk2005{{
+		parentHeader:      &aseFile.Header,
+		LayerIndex:        uint16(layer),
+		OpacityLevel:      255,
+		CelType:           CelTypeCompressedTilemap,
+		WidthInTiles:      2,
+		HeightInTiles:     2,
+		BitsPerTile:       32,
+		BitMaskForTileID:  0x1FFFFFFF,
+		BitMaskForXFlip:   0x20000000,
+		BitMaskForYFlip:   0x40000000,
+		BitMaskFor90CWRot: 0x80000000,
+		Tiles:             tileData,
+	}}
+	return aseFile
+}
+
+func TestTilesetUserData(t *testing.T) {
+	aseFile := tilemapSprite(t, 2, 1, 0, 0, 1)
+	aseFile.Frames[0].Tilesets[0].UserData = AsepriteUserDataChunk2020{Flags: UserDataFlagHasText, Text: "grass"}
+	decoded := encodeDecode(t, aseFile, EncodeOptions{})
+	if got := decoded.Frames[0].Tilesets[0].UserData; got.Text != "grass" {
+		t.Fatalf("tileset user data decoded as %+v", got)
+	}
+	// The layer after the tileset keeps its own user data
+	if got := decoded.Frames[0].Layers[0].UserData; got.Flags != 0 {
+		t.Errorf("layer after the tileset got user data %+v", got)
+	}
+
+	sprite, err := SpriteFromFile(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tileset := sprite.Tileset(0)
+	if tileset.UserData.Text != "grass" {
+		t.Errorf("tileset model has user data %+v", tileset.UserData)
+	}
+	tileset.UserData.Text = "sand"
+	lowered, err := sprite.ToFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lowered.Frames[0].Tilesets[0].UserData.Text; got != "sand" {
+		t.Errorf("lowered tileset has user data %q", got)
+	}
+}
+
+func TestToFileCounts(t *testing.T) {
+	sprite, err := SpriteFromFile(decodeChica(t, DecodeOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aseFile, err := sprite.ToFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ToFile compresses nothing, so it leaves the sizes to Encode
+	if aseFile.Header.FileSize != 0 || aseFile.Frames[0].BytesThisFrame != 0 {
+		t.Errorf("ToFile set a file size of %d and frame size of %d", aseFile.Header.FileSize, aseFile.Frames[0].BytesThisFrame)
+	}
+	want := encodeDecode(t, aseFile, EncodeOptions{})
+	if aseFile.Header.Frames != want.Header.Frames {
+		t.Errorf("ToFile set %d frames, want %d", aseFile.Header.Frames, want.Header.Frames)
+	}
+	for x := range aseFile.Frames {
+		got, wantFrame := aseFile.Frames[x], want.Frames[x]
+		if got.MagicNumber != 0xF1FA || got.ChunksThisFrame != wantFrame.ChunksThisFrame ||
+			got.ChunksThisFrameExt != wantFrame.ChunksThisFrameExt {
+			t.Errorf("frame %d: ToFile counted %d/%d chunks, encoding %d/%d", x, got.ChunksThisFrame,
+				got.ChunksThisFrameExt, wantFrame.ChunksThisFrame, wantFrame.ChunksThisFrameExt)
+		}
+	}
+}
+
+func TestExternalTileset(t *testing.T) {
+	aseFile := tilemapSprite(t, 2, 1, 0, 0, 1)
+	tileset := &aseFile.Frames[0].Tilesets[0]
+	tileset.Flags = TilesetFlagExternalFile
+	tileset.ExternalFileID, tileset.TilesetIDInExternalFile = 3, 7
+	tileset.CompressedDatLen, tileset.CompressedTilesetImg = 0, nil
+	aseFile.Frames[0].ExternalFiles = []AsepriteExternalFilesChunk2008{{NumEntries: 1,
+		ExternalFile: []AsepriteExternalFilesChunk2008Entry{{EntryID: 3, ExternalFilename: "tiles.aseprite"}}}}
+	if problems := aseFile.Validate(); problems != nil {
+		t.Fatalf("sprite has problems %v", problems)
+	}
+	sprite, err := SpriteFromFile(encodeDecode(t, aseFile, EncodeOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := sprite.ToFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := rebuilt.Validate(); problems != nil {
+		t.Errorf("rebuilt sprite has problems %v", problems)
+	}
+	decoded := encodeDecode(t, rebuilt, EncodeOptions{})
+	extFiles := decoded.Frames[0].ExternalFiles
+	if len(extFiles) != 1 || len(extFiles[0].ExternalFile) != 1 || extFiles[0].ExternalFile[0] != aseFile.Frames[0].ExternalFiles[0].ExternalFile[0] {
+		t.Errorf("external files rebuilt as %+v", extFiles)
+	}
+	if got := decoded.Frames[0].Tilesets[0]; got.ExternalFileID != 3 || got.TilesetIDInExternalFile != 7 {
+		t.Errorf("tileset rebuilt in external file %d as tileset %d", got.ExternalFileID, got.TilesetIDInExternalFile)
+	}
+}
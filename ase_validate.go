@@ -0,0 +1,269 @@
+package asefile
+
+import (
+	"fmt"
+)
+
+// Problem is a broken invariant found by Validate. Frame is the index of the
+// frame holding the chunk involved, or -1 for the header and the sprite as a
+// whole, in which case ChunkType is 0.
+type Problem struct {
+	Frame     int
+	ChunkType ChunkType
+	Message   string
+}
+
+func (problem Problem) String() string {
+	if problem.Frame < 0 {
+		return problem.Message
+	}
+	return fmt.Sprintf("frame %d: %s", problem.Frame, problem.Message)
+}
+
+// validator collects the problems of a sprite
+type validator struct {
+	aseFile  *AsepriteFile
+	problems []Problem
+	layers   []*AsepriteLayerChunk2004
+}
+
+func (v *validator) report(frame int, chunkType ChunkType, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{frame, chunkType, fmt.Sprintf(format, args...)})
+}
+
+/**
+ * Validate checks the invariants that hold between chunks and that the
+ * decoder does not enforce, so that hand-built or edited sprites can be
+ * checked before they are encoded: the layer tree, the layers and frames cels
+ * refer to, the external files of tilesets, tag, palette and slice key
+ * ranges, the counts stored next to lists and the types of user data
+ * properties. It returns nil when no problem is found.
+ */
+func (aseFile *AsepriteFile) Validate() []Problem {
+	v := &validator{aseFile: aseFile}
+	v.header()
+	v.layerTree()
+	v.externalTilesets()
+	for x := range aseFile.Frames {
+		aseFrame := &aseFile.Frames[x]
+		v.cels(x, aseFrame)
+		v.tags(x, &aseFrame.Tags)
+		v.userData(x, aseFrame)
+		for _, palette := range aseFrame.Palettes {
+			v.palette(x, &palette)
+		}
+		for _, sliceChunk := range aseFrame.Slices {
+			v.slice(x, &sliceChunk)
+		}
+		for _, extFiles := range aseFrame.ExternalFiles {
+			if int(extFiles.NumEntries) != len(extFiles.ExternalFile) {
+				v.report(x, ChunkExternalFiles, "external files chunk counts %d files but holds %d",
+					extFiles.NumEntries, len(extFiles.ExternalFile))
+			}
+		}
+	}
+	return v.problems
+}
+
+// externalTilesets checks that tilesets kept in an external file refer to an
+// entry of an external files chunk
+func (v *validator) externalTilesets() {
+	entries := make(map[uint32]bool)
+	for x := range v.aseFile.Frames {
+		for _, extFiles := range v.aseFile.Frames[x].ExternalFiles {
+			for _, entry := range extFiles.ExternalFile {
+				entries[entry.EntryID] = true
+			}
+		}
+	}
+	for x := range v.aseFile.Frames {
+		for _, tileset := range v.aseFile.Frames[x].Tilesets {
+			if tileset.Flags.ExternalFile() && !entries[tileset.ExternalFileID] {
+				v.report(x, ChunkTileset, "tileset %d is in external file %d, which is not listed",
+					tileset.TilesetID, tileset.ExternalFileID)
+			}
+		}
+	}
+}
+
+// userData checks that the user data properties of the chunks of a frame
+// hold values of their types
+func (v *validator) userData(frame int, aseFrame *AsepriteFrame) {
+	check := func(owner string, userDat AsepriteUserDataChunk2020) {
+		if !userDat.Flags.HasProperties() {
+			return
+		}
+		for _, propertiesMap := range userDat.Properties {
+			for _, property := range propertiesMap.Properties {
+				if err := property.Value.check(); err != nil {
+					v.report(frame, ChunkUserData, "property %q of %s: %v", property.Name, owner, err)
+				}
+			}
+		}
+	}
+	check("the sprite", aseFrame.SpriteUserData)
+	for _, layer := range aseFrame.Layers {
+		check(fmt.Sprintf("layer %q", layer.LayerName), layer.UserData)
+	}
+	for x, userDat := range aseFrame.Tags.UserData {
+		check(fmt.Sprintf("tag %d", x), userDat)
+	}
+	for _, sliceChunk := range aseFrame.Slices {
+		check(fmt.Sprintf("slice %q", sliceChunk.Name), sliceChunk.UserData)
+	}
+	for _, tileset := range aseFrame.Tilesets {
+		check(fmt.Sprintf("tileset %d", tileset.TilesetID), tileset.UserData)
+	}
+}
+
+func (v *validator) header() {
+	header := &v.aseFile.Header
+	numFrames := len(v.aseFile.Frames)
+	if numFrames == 0 || numFrames > 0xFFFF {
+		v.report(-1, 0, "sprite has %d frames, 1 to 65535 are allowed", numFrames)
+	}
+	if header.WidthInPixels == 0 || header.HeightInPixels == 0 {
+		v.report(-1, 0, "sprite size %dx%d is empty", header.WidthInPixels, header.HeightInPixels)
+	}
+	switch header.ColorDepth {
+	case ColorModeIndexed, ColorModeGrayscale, ColorModeRGBA:
+	default:
+		v.report(-1, 0, "unknown color mode %v", header.ColorDepth)
+	}
+}
+
+// layerTree checks that every child level is at most one more than the level
+// before it, that parents are groups and that tilemap layers have a tileset
+func (v *validator) layerTree() {
+	tilesets := make(map[uint32]bool)
+	for x := range v.aseFile.Frames {
+		for _, tileset := range v.aseFile.Frames[x].Tilesets {
+			tilesets[tileset.TilesetID] = true
+		}
+	}
+	var groupPath []*AsepriteLayerChunk2004
+	for x := range v.aseFile.Frames {
+		for y := range v.aseFile.Frames[x].Layers {
+			layer := &v.aseFile.Frames[x].Layers[y]
+			v.layers = append(v.layers, layer)
+			level := int(layer.LayerChildLevel)
+			if level > len(groupPath) {
+				v.report(x, ChunkLayer, "layer %q has child level %d, at most %d is allowed there",
+					layer.LayerName, level, len(groupPath))
+				level = len(groupPath)
+			}
+			if level > 0 && groupPath[level-1].LayerType != LayerTypeGroup {
+				v.report(x, ChunkLayer, "layer %q is a child of layer %q, which is not a group",
+					layer.LayerName, groupPath[level-1].LayerName)
+			}
+			groupPath = append(groupPath[:level], layer)
+			if layer.LayerType == LayerTypeTilemap && !tilesets[layer.TilesetIndex] {
+				v.report(x, ChunkLayer, "tilemap layer %q uses tileset %d, which does not exist",
+					layer.LayerName, layer.TilesetIndex)
+			}
+		}
+	}
+}
+
+// cels checks that the cels of a frame are on existing layers, one per layer,
+// and that linked cels point at a cel on the same layer
+func (v *validator) cels(frame int, aseFrame *AsepriteFrame) {
+	numFrames := len(v.aseFile.Frames)
+	seen := make(map[uint16]bool)
+	for _, cel := range aseFrame.Cels {
+		if int(cel.LayerIndex) >= len(v.layers) {
+			v.report(frame, ChunkCel, "cel layer %d out of range, the sprite has %d layers", cel.LayerIndex, len(v.layers))
+			continue
+		}
+		layer := v.layers[cel.LayerIndex]
+		if seen[cel.LayerIndex] {
+			v.report(frame, ChunkCel, "layer %q has more than one cel", layer.LayerName)
+		}
+		seen[cel.LayerIndex] = true
+		switch {
+		case layer.LayerType == LayerTypeGroup:
+			v.report(frame, ChunkCel, "cel on group layer %q", layer.LayerName)
+		case cel.CelType == CelTypeCompressedTilemap && layer.LayerType != LayerTypeTilemap:
+			v.report(frame, ChunkCel, "tilemap cel on layer %q, which is not a tilemap layer", layer.LayerName)
+		}
+		if cel.CelType != CelTypeLinked {
+			continue
+		}
+		target := int(cel.FramePosToLinkWith)
+		if target >= numFrames {
+			v.report(frame, ChunkCel, "cel on layer %q links to frame %d, the sprite has %d frames",
+				layer.LayerName, target, numFrames)
+			continue
+		}
+		var linked *AsepriteCelChunk2005
+		for y := range v.aseFile.Frames[target].Cels {
+			if v.aseFile.Frames[target].Cels[y].LayerIndex == cel.LayerIndex {
+				linked = &v.aseFile.Frames[target].Cels[y]
+				break
+			}
+		}
+		switch {
+		case linked == nil:
+			v.report(frame, ChunkCel, "cel on layer %q links to frame %d, which has no cel on that layer",
+				layer.LayerName, target)
+		case linked.CelType == CelTypeLinked:
+			v.report(frame, ChunkCel, "cel on layer %q links to frame %d, whose cel is linked too",
+				layer.LayerName, target)
+		}
+	}
+}
+
+func (v *validator) tags(frame int, tagsChunk *AsepriteTagsChunk2018) {
+	if int(tagsChunk.NumTags) != len(tagsChunk.Tags) {
+		v.report(frame, ChunkTags, "tags chunk counts %d tags but holds %d", tagsChunk.NumTags, len(tagsChunk.Tags))
+	}
+	numFrames := len(v.aseFile.Frames)
+	for _, tag := range tagsChunk.Tags {
+		switch {
+		case tag.FromFrame > tag.ToFrame:
+			v.report(frame, ChunkTags, "tag %q: frame range %d..%d is reversed", tag.TagName, tag.FromFrame, tag.ToFrame)
+		case int(tag.ToFrame) >= numFrames:
+			v.report(frame, ChunkTags, "tag %q: frame range %d..%d ends after the last of %d frames",
+				tag.TagName, tag.FromFrame, tag.ToFrame, numFrames)
+		}
+	}
+}
+
+func (v *validator) palette(frame int, palette *AsepritePaletteChunk2019) {
+	first, last := palette.FirstColIndexToChange, palette.LastColIndexToChange
+	switch {
+	case first > last:
+		v.report(frame, ChunkPalette, "palette color range %d..%d is reversed", first, last)
+		return
+	case last >= palette.PaletteSize:
+		v.report(frame, ChunkPalette, "palette of %d colors changes colors %d..%d", palette.PaletteSize, first, last)
+	}
+	if uint64(len(palette.PaletteEntries)) != uint64(last-first)+1 {
+		v.report(frame, ChunkPalette, "palette changes colors %d..%d but holds %d entries", first, last, len(palette.PaletteEntries))
+	}
+	header := &v.aseFile.Header
+	if frame == 0 && header.ColorDepth == ColorModeIndexed && uint32(header.PaletteEntry) >= palette.PaletteSize {
+		v.report(frame, ChunkPalette, "transparent index %d is outside the palette of %d colors", header.PaletteEntry, palette.PaletteSize)
+	}
+}
+
+func (v *validator) slice(frame int, sliceChunk *AsepriteSliceChunk2022) {
+	if int(sliceChunk.NumSliceKeys) != len(sliceChunk.SliceKeysData) {
+		v.report(frame, ChunkSlice, "slice %q counts %d keys but holds %d",
+			sliceChunk.Name, sliceChunk.NumSliceKeys, len(sliceChunk.SliceKeysData))
+	}
+	if len(sliceChunk.SliceKeysData) == 0 {
+		v.report(frame, ChunkSlice, "slice %q has no keys", sliceChunk.Name)
+	}
+	numFrames := len(v.aseFile.Frames)
+	for x, key := range sliceChunk.SliceKeysData {
+		if int64(key.FrameNumber) >= int64(numFrames) {
+			v.report(frame, ChunkSlice, "slice %q has a key at frame %d, the sprite has %d frames",
+				sliceChunk.Name, key.FrameNumber, numFrames)
+		}
+		if x > 0 && key.FrameNumber <= sliceChunk.SliceKeysData[x-1].FrameNumber {
+			v.report(frame, ChunkSlice, "slice %q: key for frame %d follows the key for frame %d",
+				sliceChunk.Name, key.FrameNumber, sliceChunk.SliceKeysData[x-1].FrameNumber)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package asefile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if problems := decodeChica(t, DecodeOptions{}).Validate(); problems != nil {
+		t.Fatalf("Chica has problems %v", problems)
+	}
+	slice := func(keys ...SliceKey) AsepriteSliceChunk2022 {
+		return AsepriteSliceChunk2022{NumSliceKeys: uint32(len(keys)), Name: "Hitbox", SliceKeysData: keys}
+	}
+	for _, test := range []struct {
+		name      string
+		edit      func(aseFile *AsepriteFile)
+		frame     int
+		chunkType ChunkType
+		want      string
+	}{
+		{"no frames", func(aseFile *AsepriteFile) { aseFile.Frames = nil }, -1, 0, "sprite has 0 frames"},
+		{"empty canvas", func(aseFile *AsepriteFile) { aseFile.Header.WidthInPixels = 0 }, -1, 0, "sprite size 0x32 is empty"},
+		{"color mode", func(aseFile *AsepriteFile) { aseFile.Header.ColorDepth = 24 }, -1, 0, "unknown color mode ColorMode(24)"},
+		{"child level", func(aseFile *AsepriteFile) { aseFile.Frames[0].Layers[0].LayerChildLevel = 1 }, 0, ChunkLayer,
+			"has child level 1, at most 0 is allowed there"},
+		{"parent not a group", func(aseFile *AsepriteFile) { aseFile.Frames[0].Layers[1].LayerChildLevel = 1 }, 0, ChunkLayer,
+			"which is not a group"},
+		{"missing tileset", func(aseFile *AsepriteFile) { aseFile.Frames[0].Layers[1].LayerType = LayerTypeTilemap }, 0, ChunkLayer,
+			"uses tileset 0, which does not exist"},
+		{"cel layer", func(aseFile *AsepriteFile) { aseFile.Frames[2].Cels[0].LayerIndex = 5 }, 2, ChunkCel,
+			"cel layer 5 out of range, the sprite has 2 layers"},
+		{"two cels on a layer", func(aseFile *AsepriteFile) {
+			aseFile.Frames[2].Cels[1].LayerIndex = aseFile.Frames[2].Cels[0].LayerIndex
+		}, 2, ChunkCel, "has more than one cel"},
+		{"cel on group", func(aseFile *AsepriteFile) { aseFile.Frames[0].Layers[0].LayerType = LayerTypeGroup }, 0, ChunkCel,
+			"cel on group layer"},
+		{"external tileset", func(aseFile *AsepriteFile) {
+			aseFile.Frames[0].Tilesets = []AsepriteTilesetChunk2023{{TilesetID: 2, Flags: TilesetFlagExternalFile, ExternalFileID: 3}}
+		}, 0, ChunkTileset, "tileset 2 is in external file 3, which is not listed"},
+		{"tilemap cel on image layer", func(aseFile *AsepriteFile) {
+			aseFile.Frames[3].Cels[0].CelType = CelTypeCompressedTilemap
+		}, 3, ChunkCel, "which is not a tilemap layer"},
+		{"link past the end", func(aseFile *AsepriteFile) {
+			aseFile.Frames[3].Cels[0].CelType = CelTypeLinked
+			aseFile.Frames[3].Cels[0].FramePosToLinkWith = 13
+		}, 3, ChunkCel, "links to frame 13, the sprite has 13 frames"},
+		{"link to no cel", func(aseFile *AsepriteFile) {
+			aseFile.Frames[3].Cels[0].CelType = CelTypeLinked
+			aseFile.Frames[3].Cels[0].FramePosToLinkWith = 1
+			aseFile.Frames[1].Cels = nil
+		}, 3, ChunkCel, "links to frame 1, which has no cel on that layer"},
+		{"link to link", func(aseFile *AsepriteFile) {
+			aseFile.Frames[3].Cels[0].CelType = CelTypeLinked
+			aseFile.Frames[3].Cels[0].FramePosToLinkWith = 3
+		}, 3, ChunkCel, "whose cel is linked too"},
+		{"tag count", func(aseFile *AsepriteFile) { aseFile.Frames[0].Tags.NumTags = 9 }, 0, ChunkTags,
+			"tags chunk counts 9 tags but holds 3"},
+		{"reversed tag", func(aseFile *AsepriteFile) { aseFile.Frames[0].Tags.Tags[0].FromFrame = 12 }, 0, ChunkTags,
+			"is reversed"},
+		{"tag past the end", func(aseFile *AsepriteFile) { aseFile.Frames[0].Tags.Tags[2].ToFrame = 13 }, 0, ChunkTags,
+			"ends after the last of 13 frames"},
+		{"reversed palette", func(aseFile *AsepriteFile) { aseFile.Frames[0].Palettes[0].FirstColIndexToChange = 1 << 20 }, 0,
+			ChunkPalette, "palette color range 1048576.."},
+		{"palette range", func(aseFile *AsepriteFile) { aseFile.Frames[0].Palettes[0].PaletteSize = 1 }, 0, ChunkPalette,
+			"palette of 1 colors changes colors"},
+		{"palette entries", func(aseFile *AsepriteFile) {
+			palette := &aseFile.Frames[0].Palettes[0]
+			palette.PaletteEntries = palette.PaletteEntries[1:]
+		}, 0, ChunkPalette, "entries"},
+		{"transparent index", func(aseFile *AsepriteFile) {
+			aseFile.Header.ColorDepth = ColorModeIndexed
+			aseFile.Header.PaletteEntry = 255
+			palette := &aseFile.Frames[0].Palettes[0]
+			palette.PaletteSize, palette.LastColIndexToChange = 2, 1
+			palette.PaletteEntries = palette.PaletteEntries[:2]
+		}, 0, ChunkPalette, "transparent index 255 is outside the palette of 2 colors"},
+		{"slice key count", func(aseFile *AsepriteFile) {
+			sliceChunk := slice(SliceKey{FrameNumber: 0, SliceWidth: 1, SliceHeight: 1})
+			sliceChunk.NumSliceKeys = 2
+			aseFile.Frames[0].Slices = append(aseFile.Frames[0].Slices, sliceChunk)
+		}, 0, ChunkSlice, `slice "Hitbox" counts 2 keys but holds 1`},
+		{"slice without keys", func(aseFile *AsepriteFile) {
+			aseFile.Frames[0].Slices = append(aseFile.Frames[0].Slices, slice())
+		}, 0, ChunkSlice, `slice "Hitbox" has no keys`},
+		{"slice key past the end", func(aseFile *AsepriteFile) {
+			aseFile.Frames[0].Slices = append(aseFile.Frames[0].Slices, slice(SliceKey{FrameNumber: 20, SliceWidth: 1, SliceHeight: 1}))
+		}, 0, ChunkSlice, "has a key at frame 20, the sprite has 13 frames"},
+		{"slice keys out of order", func(aseFile *AsepriteFile) {
+			aseFile.Frames[0].Slices = append(aseFile.Frames[0].Slices,
+				slice(SliceKey{FrameNumber: 4, SliceWidth: 1, SliceHeight: 1}, SliceKey{FrameNumber: 2, SliceWidth: 1, SliceHeight: 1}))
+		}, 0, ChunkSlice, "key for frame 2 follows the key for frame 4"},
+		{"external files count", func(aseFile *AsepriteFile) {
+			aseFile.Frames[0].ExternalFiles = []AsepriteExternalFilesChunk2008{{NumEntries: 1}}
+		}, 0, ChunkExternalFiles, "external files chunk counts 1 files but holds 0"},
+	} {
+		aseFile := decodeChica(t, DecodeOptions{})
+		test.edit(aseFile)
+		problems := aseFile.Validate()
+		found := false
+		for _, problem := range problems {
+			if problem.Frame == test.frame && problem.ChunkType == test.chunkType && strings.Contains(problem.Message, test.want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: got problems %v, want %q in frame %d", test.name, problems, test.want, test.frame)
+		}
+	}
+}
+
+func TestProblemString(t *testing.T) {
+	for _, test := range []struct {
+		problem Problem
+		want    string
+	}{
+		{Problem{-1, 0, "sprite has 0 frames"}, "sprite has 0 frames"},
+		{Problem{3, ChunkCel, "cel on group layer"}, "frame 3: cel on group layer"},
+	} {
+		if got := test.problem.String(); got != test.want {
+			t.Errorf("String() = %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package asefile
+
+import (
+	"fmt"
+)
+
+// Logger receives the warnings of a decoder. Its method matches the Warn
+// method of *slog.Logger, so one can be used directly.
+type Logger interface {
+	Warn(msg string, args ...interface{})
+}
+
+// WarningKind classifies the non-fatal issues found while decoding
+type WarningKind int
+
+const (
+	// A chunk of a type this package does not decode was skipped
+	WarningUnknownChunk WarningKind = iota + 1
+	// The zlib data of a cel was damaged, the pixels that inflated before
+	// the damage were kept
+	WarningZlib
+	// Two counts in the file that should agree do not
+	WarningCountMismatch
+	// A deprecated chunk that Aseprite no longer uses, such as a mask or
+	// path chunk, was skipped
+	WarningDeprecatedChunk
+	// A chunk of a known type was skipped because it is out of place, such
+	// as a cel extra chunk that follows no cel
+	WarningSkippedChunk
+)
+
+func (kind WarningKind) String() string {
+	switch kind {
+	case WarningUnknownChunk:
+		return "unknown chunk"
+	case WarningZlib:
+		return "zlib error"
+	case WarningCountMismatch:
+		return "count mismatch"
+	case WarningDeprecatedChunk:
+		return "deprecated chunk"
+	case WarningSkippedChunk:
+		return "skipped chunk"
+	}
+	return fmt.Sprintf("WarningKind(%d)", int(kind))
+}
+
+/**
+ * Warning is a non-fatal issue found while decoding. With
+ * DecodeOptions.Strict the first warning is returned as the error instead.
+ */
+type Warning struct {
+	Kind WarningKind
+	// Frame the issue was found in, and the type of the chunk involved or 0
+	Frame     int
+	ChunkType ChunkType
+	Message   string
+}
+
+func (warning Warning) Error() string {
+	return fmt.Sprintf("frame %d: %s", warning.Frame, warning.Message)
+}
+
+// Warnings returns the non-fatal issues found when the sprite was decoded
+func (aseFile *AsepriteFile) Warnings() []Warning {
+	return aseFile.warnings
+}
+
+// warn records a warning for the frame being decoded, passes it to the
+// logger and returns it as an error in strict mode
+func (dec *decoder) warn(kind WarningKind, chunkType ChunkType, format string, args ...interface{}) error {
+	warning := Warning{kind, dec.frame, chunkType, fmt.Sprintf(format, args...)}
+	return dec.addWarning(warning)
+}
+
+func (dec *decoder) addWarning(warning Warning) error {
+	dec.warnings = append(dec.warnings, warning)
+	if dec.opts.Logger != nil {
+		dec.opts.Logger.Warn(warning.Message, "kind", warning.Kind.String(), "frame", warning.Frame, "chunkType", warning.ChunkType)
+	}
+	if dec.opts.Strict {
+		return warning
+	}
+	return nil
+}
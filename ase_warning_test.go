@@ -0,0 +1,123 @@
+package asefile
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testLogger records the messages and frames of the warnings it receives
+type testLogger struct {
+	messages []string
+	frames   []interface{}
+}
+
+func (logger *testLogger) Warn(msg string, args ...interface{}) {
+	logger.messages = append(logger.messages, msg)
+	for x := 0; x+1 < len(args); x += 2 {
+		if args[x] == "frame" {
+			logger.frames = append(logger.frames, args[x+1])
+		}
+	}
+}
+
+// externalFilesChunk encodes an external files chunk listing one file
+func externalFilesChunk(id uint32, name string) []byte {
+	var buf bytes.Buffer
+	extFiles := AsepriteExternalFilesChunk2008{NumEntries: 1,
+		ExternalFile: []AsepriteExternalFilesChunk2008Entry{{EntryID: id, ExternalFilename: name}}}
+	extFiles.Encode(&buf)
+	return buf.Bytes()
+}
+
+func TestDecodeWarnings(t *testing.T) {
+	chica := readChica(t)
+	for _, test := range []struct {
+		name      string
+		chunkType ChunkType
+		chunkDat  []byte
+		kind      WarningKind
+		strict    bool
+		// Chunk inserted in frame 2 before the one tested, if any
+		before ChunkType
+	}{
+		{"unknown chunk", 0x7A01, []byte{1, 2, 3}, WarningUnknownChunk, true, 0},
+		{"mask chunk", ChunkMask, make([]byte, 16), WarningDeprecatedChunk, true, 0},
+		{"path chunk", ChunkPath, nil, WarningDeprecatedChunk, true, 0},
+		{"cel extra chunk after no cel", ChunkCelExtra, make([]byte, 36), WarningSkippedChunk, true, ChunkUserData},
+		{"external files chunk", ChunkExternalFiles, externalFilesChunk(3, "palette.aseprite"), 0, false, 0},
+	} {
+		sprite := chica
+		if test.before != 0 {
+			sprite = insertChunk(sprite, 2, test.before, userDataChunk("before"))
+		}
+		sprite = insertChunk(sprite, 2, test.chunkType, test.chunkDat)
+		var aseFile AsepriteFile
+		if err := aseFile.Decode(bytes.NewReader(sprite)); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		warnings := aseFile.Warnings()
+		switch {
+		case test.kind == 0 && len(warnings) != 0:
+			t.Errorf("%s: got warnings %v", test.name, warnings)
+		case test.kind != 0 && (len(warnings) != 1 || warnings[0].Kind != test.kind ||
+			warnings[0].Frame != 2 || warnings[0].ChunkType != test.chunkType):
+			t.Errorf("%s: got warnings %v, want a %v warning in frame 2", test.name, warnings, test.kind)
+		}
+
+		err := aseFile.DecodeWithOptions(bytes.NewReader(sprite), DecodeOptions{Strict: true})
+		var warning Warning
+		if test.strict != (err != nil) || err != nil && (!errors.As(err, &warning) || warning.Kind != test.kind) {
+			t.Errorf("%s: strict decode gave %v", test.name, err)
+		}
+	}
+}
+
+func TestDecodeExternalFiles(t *testing.T) {
+	sprite := insertChunk(readChica(t), 0, ChunkExternalFiles, externalFilesChunk(3, "palette.aseprite"))
+	var aseFile AsepriteFile
+	if err := aseFile.DecodeWithOptions(bytes.NewReader(sprite), DecodeOptions{Strict: true}); err != nil {
+		t.Fatal(err)
+	}
+	extFiles := aseFile.Frames[0].ExternalFiles
+	if len(extFiles) != 1 || len(extFiles[0].ExternalFile) != 1 || extFiles[0].ExternalFile[0].EntryID != 3 ||
+		extFiles[0].ExternalFile[0].ExternalFilename != "palette.aseprite" {
+		t.Fatalf("decoded external files %+v", extFiles)
+	}
+	if diff := Diff(&aseFile, encodeDecode(t, &aseFile, EncodeOptions{}), EqualOptions{}); diff != "" {
+		t.Errorf("external files changed by encoding:\n%s", diff)
+	}
+	aseFile.Frames[0].ExternalFiles[0].NumEntries = 2
+	if problems := aseFile.Validate(); len(problems) != 1 || problems[0].ChunkType != ChunkExternalFiles {
+		t.Errorf("miscounted external files gave problems %v", problems)
+	}
+	if err := aseFile.DecodeWithOptions(bytes.NewReader(sprite), DecodeOptions{MaxStringLength: 10}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("long file name gave %v, want ErrLimitExceeded", err)
+	}
+}
+
+func TestWarningOrder(t *testing.T) {
+	sprite := insertChunk(insertChunk(readChica(t), 5, 0x7A01, nil), 2, 0x7A02, nil)
+	logger := &testLogger{}
+	var aseFile AsepriteFile
+	if err := aseFile.DecodeWithOptions(bytes.NewReader(sprite), DecodeOptions{Logger: logger}); err != nil {
+		t.Fatal(err)
+	}
+	var frames []interface{}
+	var messages []string
+	for _, warning := range aseFile.Warnings() {
+		frames = append(frames, warning.Frame)
+		messages = append(messages, warning.Message)
+	}
+	if !reflect.DeepEqual(frames, []interface{}{2, 5}) {
+		t.Errorf("warnings in frames %v, want 2 then 5", frames)
+	}
+	if !reflect.DeepEqual(logger.frames, frames) || !reflect.DeepEqual(logger.messages, messages) {
+		t.Errorf("logger got %v in frames %v, want %v in frames %v", logger.messages, logger.frames, messages, frames)
+	}
+	if got := aseFile.Warnings()[0].Error(); got != "frame 2: "+messages[0] {
+		t.Errorf("Error() = %q", got)
+	}
+}
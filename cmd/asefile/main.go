@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Racinettee/asefile"
+)
+
+const usage = `usage: asefile <command> [arguments]
+
+commands:
+  dump [-hex] file.aseprite   print the frames and chunks of a sprite
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "dump":
+		os.Exit(dumpCommand(os.Args[2:]))
+	default:
+		fmt.Fprintf(os.Stderr, "asefile: unknown command %q\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+func dumpCommand(args []string) int {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	hexDump := flags.Bool("hex", false, "print a hex dump of every chunk payload")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "asefile:", err)
+		return 1
+	}
+	defer file.Close()
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if err := dump(out, bufio.NewReader(file), *hexDump); err != nil {
+		out.Flush()
+		fmt.Fprintln(os.Stderr, "asefile:", err)
+		return 1
+	}
+	return 0
+}
+
+// dump prints the header, then every frame and the chunks in it with their
+// offsets from the start of the file
+func dump(w io.Writer, r io.Reader, hexDump bool) error {
+	scanner, err := asefile.NewChunkScanner(r)
+	if err != nil {
+		return err
+	}
+	header := scanner.Header
+	fmt.Fprintf(w, "header: %d bytes, %d frames, %dx%d, %v, flags %v\n",
+		header.FileSize, header.Frames, header.WidthInPixels, header.HeightInPixels, header.ColorDepth, header.Flags)
+	for scanner.NextFrame() {
+		frame := scanner.Frame()
+		fmt.Fprintf(w, "frame %d at %d: %d bytes, %d chunks, %d ms\n",
+			frame.Index, frame.Offset, frame.Size, frame.Chunks, frame.Duration)
+		for scanner.NextChunk() {
+			chunk := scanner.Chunk()
+			fmt.Fprintf(w, "  chunk %04X %-20s at %d: %d bytes\n", uint16(chunk.Type), chunk.Type, chunk.Offset, chunk.Size)
+			if hexDump && len(chunk.Data) > 0 {
+				for _, line := range strings.SplitAfter(strings.TrimSuffix(hex.Dump(chunk.Data), "\n"), "\n") {
+					fmt.Fprintf(w, "    %s", line)
+				}
+				fmt.Fprintln(w)
+			}
+		}
+	}
+	return scanner.Err()
+}